@@ -10,6 +10,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -78,10 +79,68 @@ func (f stringArrayFlag) String() string {
 	return strings.Join(f, ",")
 }
 
+// sizeMapFlag is a comma separated list of `key=size` pairs.
+type sizeMapFlag map[string]int64
+
+func (f *sizeMapFlag) Set(value string) error {
+	m := map[string]int64{}
+	for _, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid pair: %s", pair)
+		}
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid size: %s", pair)
+		}
+		m[k] = size
+	}
+	*f = m
+	return nil
+}
+
+// stringMapFlag is a comma separated list of `key=value` pairs.
+type stringMapFlag map[string]string
+
+func (f *stringMapFlag) Set(value string) error {
+	m := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid pair: %s", pair)
+		}
+		m[k] = v
+	}
+	*f = m
+	return nil
+}
+
+func (f stringMapFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f sizeMapFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for k, v := range f {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
 // ServerConfig wraps simpleuploadserver.ServerConfig to provide JSON marshaling.
 type ServerConfig struct {
 	// Address where the server listens on.
 	Addr string `json:"addr"`
+	// Path to the certificate file for HTTPS.
+	TLSCertFile string `json:"tls_cert"`
+	// Path to the private key file for HTTPS.
+	TLSKeyFile string `json:"tls_key"`
 	// Path to the document root.
 	DocumentRoot string `json:"document_root"`
 	// Determines whether to enable CORS header.
@@ -98,6 +157,94 @@ type ServerConfig struct {
 	ReadOnlyTokens []string `json:"read_only_tokens"`
 	// Authentication tokens for read-write access.
 	ReadWriteTokens []string `json:"read_write_tokens"`
+	// Authentication tokens for read-write access keyed by their names, which are recorded as the owners of the files.
+	NamedTokens map[string]string `json:"named_tokens"`
+	// Authentication tokens for admin access.
+	AdminTokens []string `json:"admin_tokens"`
+	// Path to the file to persist the owners of the files.
+	OwnersFile string `json:"owners_file"`
+	// URL of the upstream storage to stream uploads to.
+	ProxyUploadURL string `json:"proxy_upload_url"`
+	// Directory to keep uploads until they are promoted.
+	StagingDir string `json:"staging_dir"`
+	// Treat file names case-insensitively on checking the existence.
+	CaseInsensitiveNames *bool `json:"case_insensitive_names"`
+	// Start in maintenance mode.
+	MaintenanceMode *bool `json:"maintenance_mode"`
+	// Directory to store large multipart contents temporarily.
+	MultipartTempDir string `json:"multipart_temp_dir"`
+	// Name of the multipart form field carrying the uploaded file.
+	FormFieldName string `json:"form_field_name"`
+	// Path to the file to serve at `/`.
+	SingleFileMode string `json:"single_file_mode"`
+	// Path or URL to redirect `GET /` to.
+	RootRedirect string `json:"root_redirect"`
+	// Maintain the metadata index of the files.
+	EnableIndex *bool `json:"enable_index"`
+	// Path to the file to persist the metadata index.
+	IndexFile string `json:"index_file"`
+	// Fail on startup if the index cannot be built.
+	RequireIndex *bool `json:"require_index"`
+	// Number of retries on failing to create directories or files.
+	WriteRetries int `json:"write_retries"`
+	// Time in milliseconds to cache directory listings.
+	ListingCacheTTL int `json:"listing_cache_ttl"`
+	// Maximum time in milliseconds to receive the content of an upload.
+	MaxUploadDuration int `json:"max_upload_duration"`
+	// Permission of the uploaded files in octal.
+	FileMode string `json:"file_mode"`
+	// Permission of the directories created for the uploaded files in octal.
+	DirMode string `json:"dir_mode"`
+	// Count downloads per file.
+	EnableStats *bool `json:"enable_stats"`
+	// Emit CORS headers only when the request has Origin header.
+	CORSOnlyWithOrigin *bool `json:"cors_only_with_origin"`
+	// Origins allowed by CORS. Any origin is allowed if empty.
+	AllowedOrigins []string `json:"allowed_origins"`
+	// Maximum bytes of multipart contents kept in memory.
+	MultipartMaxMemory int64 `json:"multipart_max_memory"`
+	// IP addresses or CIDRs of trusted reverse proxies.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// Log headers and bodies of requests and responses.
+	DebugLogBodies *bool `json:"debug_log_bodies"`
+	// File extensions accepted on upload.
+	AllowedExtensions []string `json:"allowed_extensions"`
+	// File extensions rejected on upload.
+	BlockedExtensions []string `json:"blocked_extensions"`
+	// Maximum upload sizes by the prefix of the content type.
+	MaxUploadSizeByType map[string]int64 `json:"max_upload_size_by_type"`
+	// Run the post-processing of uploads in background.
+	EnableAsyncProcessing *bool `json:"enable_async_processing"`
+	// Name all files uploaded by POST with the file naming strategy.
+	GenerateFileNames *bool `json:"generate_file_names"`
+	// Additional path prefixes to download files.
+	FileAliases []string `json:"file_aliases"`
+	// Checksums of the uploaded content returned in the upload response.
+	Checksums []string `json:"checksums"`
+	// Maximum number of uploads processed at once.
+	MaxInFlightUploads int `json:"max_in_flight_uploads"`
+	// Maximum number of requests processed at once per client IP.
+	MaxConnectionsPerIP int `json:"max_connections_per_ip"`
+	// Reject uploads resulting in files without an extension.
+	RequireExtension *bool `json:"require_extension"`
+	// Reject file names which are invalid on Windows.
+	WindowsCompatibleNames *bool `json:"windows_compatible_names"`
+	// Normalize file names to Unicode NFC.
+	NormalizeUnicode *bool `json:"normalize_unicode"`
+	// List the entries on GET of a directory.
+	EnableDirectoryListing *bool `json:"enable_directory_listing"`
+	// Re-read the stored file and report it in the upload response.
+	DebugVerifyUploads *bool `json:"debug_verify_uploads"`
+	// Ignore Range requests and serve the whole content.
+	DisableRanges *bool `json:"disable_ranges"`
+	// Include the details of the existing file in 409 responses.
+	ConflictDetails *bool `json:"conflict_details"`
+	// Fsync the uploaded files before responding.
+	SyncOnUpload *bool `json:"sync_on_upload"`
+	// Move deleted files to the trash directory.
+	SoftDelete *bool `json:"soft_delete"`
+	// Time in milliseconds to keep deleted files in the trash.
+	TrashRetention int `json:"trash_retention"`
 }
 
 func (c *ServerConfig) AsConfig() simpleuploadserver.ServerConfig {
@@ -107,17 +254,117 @@ func (c *ServerConfig) AsConfig() simpleuploadserver.ServerConfig {
 	if c.EnableAuth == nil {
 		c.EnableAuth = BoolPointer(false)
 	}
+	if c.CaseInsensitiveNames == nil {
+		c.CaseInsensitiveNames = BoolPointer(false)
+	}
+	if c.MaintenanceMode == nil {
+		c.MaintenanceMode = BoolPointer(false)
+	}
+	if c.EnableIndex == nil {
+		c.EnableIndex = BoolPointer(false)
+	}
+	if c.RequireIndex == nil {
+		c.RequireIndex = BoolPointer(false)
+	}
+	if c.EnableStats == nil {
+		c.EnableStats = BoolPointer(false)
+	}
+	if c.CORSOnlyWithOrigin == nil {
+		c.CORSOnlyWithOrigin = BoolPointer(false)
+	}
+	if c.DebugLogBodies == nil {
+		c.DebugLogBodies = BoolPointer(false)
+	}
+	if c.EnableAsyncProcessing == nil {
+		c.EnableAsyncProcessing = BoolPointer(false)
+	}
+	if c.GenerateFileNames == nil {
+		c.GenerateFileNames = BoolPointer(false)
+	}
+	if c.SyncOnUpload == nil {
+		c.SyncOnUpload = BoolPointer(false)
+	}
+	if c.RequireExtension == nil {
+		c.RequireExtension = BoolPointer(false)
+	}
+	if c.WindowsCompatibleNames == nil {
+		c.WindowsCompatibleNames = BoolPointer(false)
+	}
+	if c.NormalizeUnicode == nil {
+		c.NormalizeUnicode = BoolPointer(false)
+	}
+	if c.EnableDirectoryListing == nil {
+		c.EnableDirectoryListing = BoolPointer(false)
+	}
+	if c.DebugVerifyUploads == nil {
+		c.DebugVerifyUploads = BoolPointer(false)
+	}
+	if c.DisableRanges == nil {
+		c.DisableRanges = BoolPointer(false)
+	}
+	if c.ConflictDetails == nil {
+		c.ConflictDetails = BoolPointer(false)
+	}
+	if c.SoftDelete == nil {
+		c.SoftDelete = BoolPointer(false)
+	}
 
 	return simpleuploadserver.ServerConfig{
-		Addr:               c.Addr,
-		DocumentRoot:       c.DocumentRoot,
-		EnableCORS:         *c.EnableCORS,
-		MaxUploadSize:      c.MaxUploadSize,
-		FileNamingStrategy: c.FileNamingStrategy,
-		ShutdownTimeout:    c.ShutdownTimeout,
-		EnableAuth:         *c.EnableAuth,
-		ReadOnlyTokens:     c.ReadOnlyTokens,
-		ReadWriteTokens:    c.ReadWriteTokens,
+		Addr:                   c.Addr,
+		TLSCertFile:            c.TLSCertFile,
+		TLSKeyFile:             c.TLSKeyFile,
+		DocumentRoot:           c.DocumentRoot,
+		EnableCORS:             *c.EnableCORS,
+		MaxUploadSize:          c.MaxUploadSize,
+		FileNamingStrategy:     c.FileNamingStrategy,
+		ShutdownTimeout:        c.ShutdownTimeout,
+		EnableAuth:             *c.EnableAuth,
+		ReadOnlyTokens:         c.ReadOnlyTokens,
+		ReadWriteTokens:        c.ReadWriteTokens,
+		NamedTokens:            c.NamedTokens,
+		AdminTokens:            c.AdminTokens,
+		OwnersFile:             c.OwnersFile,
+		ProxyUploadURL:         c.ProxyUploadURL,
+		StagingDir:             c.StagingDir,
+		CaseInsensitiveNames:   *c.CaseInsensitiveNames,
+		MaintenanceMode:        *c.MaintenanceMode,
+		MultipartTempDir:       c.MultipartTempDir,
+		FormFieldName:          c.FormFieldName,
+		SingleFileMode:         c.SingleFileMode,
+		RootRedirect:           c.RootRedirect,
+		EnableIndex:            *c.EnableIndex,
+		IndexFile:              c.IndexFile,
+		RequireIndex:           *c.RequireIndex,
+		WriteRetries:           c.WriteRetries,
+		ListingCacheTTL:        c.ListingCacheTTL,
+		MaxUploadDuration:      c.MaxUploadDuration,
+		FileMode:               c.FileMode,
+		DirMode:                c.DirMode,
+		EnableStats:            *c.EnableStats,
+		CORSOnlyWithOrigin:     *c.CORSOnlyWithOrigin,
+		AllowedOrigins:         c.AllowedOrigins,
+		MultipartMaxMemory:     c.MultipartMaxMemory,
+		TrustedProxies:         c.TrustedProxies,
+		DebugLogBodies:         *c.DebugLogBodies,
+		AllowedExtensions:      c.AllowedExtensions,
+		BlockedExtensions:      c.BlockedExtensions,
+		MaxUploadSizeByType:    c.MaxUploadSizeByType,
+		EnableAsyncProcessing:  *c.EnableAsyncProcessing,
+		GenerateFileNames:      *c.GenerateFileNames,
+		FileAliases:            c.FileAliases,
+		Checksums:              c.Checksums,
+		MaxInFlightUploads:     c.MaxInFlightUploads,
+		MaxConnectionsPerIP:    c.MaxConnectionsPerIP,
+		RequireExtension:       *c.RequireExtension,
+		WindowsCompatibleNames: *c.WindowsCompatibleNames,
+		NormalizeUnicode:       *c.NormalizeUnicode,
+		EnableDirectoryListing: *c.EnableDirectoryListing,
+		DebugVerifyUploads:     *c.DebugVerifyUploads,
+		DisableRanges:          *c.DisableRanges,
+		ConflictDetails:        *c.ConflictDetails,
+		SoftDelete:             *c.SoftDelete,
+		TrashRetention:         c.TrashRetention,
+		SyncOnUpload:           *c.SyncOnUpload,
 	}
 }
 
@@ -126,17 +373,63 @@ func main() {
 }
 
 type app struct {
-	flagSet            *flag.FlagSet
-	configFilePath     string
-	documentRoot       string
-	addr               string
-	enableCORS         boolOptFlag
-	maxUploadSize      int64
-	fileNamingStrategy string
-	shutdownTimeout    int
-	enableAuth         boolOptFlag
-	readOnlyTokens     stringArrayFlag
-	readWriteTokens    stringArrayFlag
+	flagSet                *flag.FlagSet
+	configFilePath         string
+	documentRoot           string
+	addr                   string
+	tlsCertFile            string
+	tlsKeyFile             string
+	enableCORS             boolOptFlag
+	maxUploadSize          int64
+	fileNamingStrategy     string
+	shutdownTimeout        int
+	enableAuth             boolOptFlag
+	readOnlyTokens         stringArrayFlag
+	readWriteTokens        stringArrayFlag
+	namedTokens            stringMapFlag
+	adminTokens            stringArrayFlag
+	ownersFile             string
+	proxyUploadURL         string
+	stagingDir             string
+	caseInsensitive        boolOptFlag
+	maintenanceMode        boolOptFlag
+	multipartTempDir       string
+	formFieldName          string
+	singleFileMode         string
+	rootRedirect           string
+	enableIndex            boolOptFlag
+	indexFile              string
+	requireIndex           boolOptFlag
+	writeRetries           int
+	listingCacheTTL        int
+	maxUploadDuration      int
+	fileMode               string
+	dirMode                string
+	enableStats            boolOptFlag
+	corsOnlyWithOrigin     boolOptFlag
+	allowedOrigins         stringArrayFlag
+	multipartMaxMemory     int64
+	trustedProxies         stringArrayFlag
+	debugLogBodies         boolOptFlag
+	allowedExtensions      stringArrayFlag
+	blockedExtensions      stringArrayFlag
+	maxUploadSizeByType    sizeMapFlag
+	enableAsyncProcessing  boolOptFlag
+	generateFileNames      boolOptFlag
+	fileAliases            stringArrayFlag
+	checksums              stringArrayFlag
+	maxInFlightUploads     int
+	maxConnectionsPerIP    int
+	requireExtension       boolOptFlag
+	windowsCompatibleNames boolOptFlag
+	normalizeUnicode       boolOptFlag
+	enableDirectoryListing boolOptFlag
+	debugVerifyUploads     boolOptFlag
+	disableRanges          boolOptFlag
+	conflictDetails        boolOptFlag
+	softDelete             boolOptFlag
+	trashRetention         int
+	syncOnUpload           boolOptFlag
 }
 
 func NewApp(name string) *app {
@@ -145,6 +438,8 @@ func NewApp(name string) *app {
 	fs.StringVar(&a.configFilePath, "config", "", "path to config file")
 	fs.StringVar(&a.documentRoot, "document_root", "", "path to document root directory")
 	fs.StringVar(&a.addr, "addr", "", "address to listen")
+	fs.StringVar(&a.tlsCertFile, "tls_cert", "", "path to the certificate file to serve HTTPS (requires tls_key)")
+	fs.StringVar(&a.tlsKeyFile, "tls_key", "", "path to the private key file to serve HTTPS (requires tls_cert)")
 	fs.Var(&a.enableCORS, "enable_cors", "enable CORS header")
 	fs.Int64Var(&a.maxUploadSize, "max_upload_size", 0, "max upload size in bytes")
 	fs.StringVar(&a.fileNamingStrategy, "file_naming_strategy", "", "File naming strategy")
@@ -152,6 +447,50 @@ func NewApp(name string) *app {
 	fs.Var(&a.enableAuth, "enable_auth", "enable authentication")
 	fs.Var(&a.readOnlyTokens, "read_only_tokens", "comma separated list of read only tokens")
 	fs.Var(&a.readWriteTokens, "read_write_tokens", "comma separated list of read write tokens")
+	fs.Var(&a.namedTokens, "named_tokens", "comma separated list of token name and read write token recorded as the owner of the uploaded files (e.g. alice=token1)")
+	fs.Var(&a.adminTokens, "admin_tokens", "comma separated list of admin tokens")
+	fs.StringVar(&a.ownersFile, "owners_file", "", "path to the file to persist the owners of the files")
+	fs.StringVar(&a.proxyUploadURL, "proxy_upload_url", "", "URL of the upstream storage to stream uploads to")
+	fs.StringVar(&a.stagingDir, "staging_dir", "", "directory to keep uploads until they are promoted by POST /promote/:id")
+	fs.Var(&a.caseInsensitive, "case_insensitive_names", "treat file names case-insensitively on checking the existence")
+	fs.Var(&a.maintenanceMode, "maintenance_mode", "start in maintenance mode (reject write requests)")
+	fs.StringVar(&a.multipartTempDir, "multipart_temp_dir", "", "directory to store large multipart contents temporarily")
+	fs.StringVar(&a.formFieldName, "form_field_name", "", "name of the multipart form field carrying the uploaded file (default \"file\")")
+	fs.StringVar(&a.singleFileMode, "single_file_mode", "", "path to the single file to serve at / (disables other endpoints)")
+	fs.StringVar(&a.rootRedirect, "root_redirect", "", "path or URL to redirect GET / to with 302 (e.g. /files/)")
+	fs.Var(&a.enableIndex, "enable_index", "maintain the metadata index of the files")
+	fs.StringVar(&a.indexFile, "index_file", "", "path to the file to persist the metadata index")
+	fs.Var(&a.requireIndex, "require_index", "fail on startup if the metadata index cannot be built")
+	fs.IntVar(&a.writeRetries, "write_retries", 0, "number of retries on failing to create directories or files")
+	fs.IntVar(&a.listingCacheTTL, "listing_cache_ttl", 0, "time in milliseconds to cache directory listings (0 disables the cache)")
+	fs.IntVar(&a.maxUploadDuration, "max_upload_duration", 0, "maximum time in milliseconds to receive the content of an upload (0 means unlimited)")
+	fs.StringVar(&a.fileMode, "file_mode", "", "permission of the uploaded files in octal (default \"0666\")")
+	fs.StringVar(&a.dirMode, "dir_mode", "", "permission of the directories created for the uploaded files in octal (default \"0755\")")
+	fs.Var(&a.enableDirectoryListing, "enable_directory_listing", "list the entries on GET of a directory as HTML or JSON")
+	fs.Var(&a.enableStats, "enable_stats", "count downloads per file and enable /stats/popular")
+	fs.Var(&a.corsOnlyWithOrigin, "cors_only_with_origin", "emit CORS headers only when the request has Origin header")
+	fs.Var(&a.allowedOrigins, "allowed_origins", "comma separated list of origins allowed by CORS (default: any origin)")
+	fs.Int64Var(&a.multipartMaxMemory, "multipart_max_memory", 0, "max bytes of multipart contents kept in memory (default 33554432)")
+	fs.Var(&a.trustedProxies, "trusted_proxies", "comma separated list of IP addresses or CIDRs of trusted reverse proxies")
+	fs.Var(&a.debugVerifyUploads, "debug_verify_uploads", "re-read the stored file and report its size and SHA-256 checksum in the upload response (for debugging)")
+	fs.Var(&a.disableRanges, "disable_ranges", "ignore Range requests and serve the whole content with Accept-Ranges: none")
+	fs.Var(&a.conflictDetails, "conflict_details", "include the size, the modification time and the SHA-256 checksum of the existing file in 409 responses")
+	fs.Var(&a.softDelete, "soft_delete", "move deleted files to the trash and enable /trash/restore")
+	fs.IntVar(&a.trashRetention, "trash_retention", 0, "time in milliseconds to keep deleted files in the trash (0 keeps them forever)")
+	fs.Var(&a.debugLogBodies, "debug_log_bodies", "log headers and bodies of requests and responses (for debugging)")
+	fs.Var(&a.allowedExtensions, "allowed_extensions", "comma separated list of file extensions accepted on upload (e.g. .jpg,.png)")
+	fs.Var(&a.blockedExtensions, "blocked_extensions", "comma separated list of file extensions rejected on upload (e.g. .exe,.sh)")
+	fs.Var(&a.maxUploadSizeByType, "max_upload_size_by_type", "comma separated list of content type prefix and max upload size in bytes (e.g. image/=5242880)")
+	fs.Var(&a.enableAsyncProcessing, "enable_async_processing", "respond 202 Accepted to uploads and enable /jobs/:id to poll the post-processing")
+	fs.Var(&a.generateFileNames, "generate_file_names", "name all files uploaded by POST with the file naming strategy")
+	fs.IntVar(&a.maxInFlightUploads, "max_in_flight_uploads", 0, "max number of uploads processed at once; excess uploads are rejected with 503 (0 means unlimited)")
+	fs.IntVar(&a.maxConnectionsPerIP, "max_connections_per_ip", 0, "max number of requests processed at once per client IP; excess requests are rejected with 429 (0 means unlimited)")
+	fs.Var(&a.checksums, "checksums", "comma separated list of checksums returned in the upload response (md5, sha256)")
+	fs.Var(&a.fileAliases, "file_aliases", "comma separated list of additional path prefixes to download files (e.g. /download)")
+	fs.Var(&a.requireExtension, "require_extension", "reject uploads resulting in files without an extension")
+	fs.Var(&a.windowsCompatibleNames, "windows_compatible_names", "reject file names which are invalid on Windows (ending with a space or a dot, or reserved names such as CON)")
+	fs.Var(&a.normalizeUnicode, "normalize_unicode", "normalize file names in uploads and request URLs to Unicode NFC")
+	fs.Var(&a.syncOnUpload, "sync_on_upload", "fsync uploaded files and their directories before responding")
 	a.flagSet = fs
 	return a
 }
@@ -163,7 +502,7 @@ func (a *app) Run(args []string) {
 	}
 	log.Printf("configured: %+v", config)
 
-	if config.EnableAuth && len(config.ReadOnlyTokens) == 0 && len(config.ReadWriteTokens) == 0 {
+	if config.EnableAuth && len(config.ReadOnlyTokens) == 0 && len(config.ReadWriteTokens) == 0 && len(config.NamedTokens) == 0 && len(config.AdminTokens) == 0 {
 		log.Print("[NOTICE] Authentication is enabled but no tokens provided. generating random tokens")
 		readOnlyToken, err := generateToken()
 		if err != nil {
@@ -223,13 +562,41 @@ func (a *app) ParseConfig(args []string) (*simpleuploadserver.ServerConfig, erro
 	}
 
 	configFromFlags := ServerConfig{
-		DocumentRoot:       a.documentRoot,
-		Addr:               a.addr,
-		MaxUploadSize:      a.maxUploadSize,
-		FileNamingStrategy: a.fileNamingStrategy,
-		ShutdownTimeout:    a.shutdownTimeout,
-		ReadOnlyTokens:     a.readOnlyTokens,
-		ReadWriteTokens:    a.readWriteTokens,
+		DocumentRoot:        a.documentRoot,
+		Addr:                a.addr,
+		TLSCertFile:         a.tlsCertFile,
+		TLSKeyFile:          a.tlsKeyFile,
+		MaxUploadSize:       a.maxUploadSize,
+		FileNamingStrategy:  a.fileNamingStrategy,
+		ShutdownTimeout:     a.shutdownTimeout,
+		ReadOnlyTokens:      a.readOnlyTokens,
+		ReadWriteTokens:     a.readWriteTokens,
+		NamedTokens:         a.namedTokens,
+		AdminTokens:         a.adminTokens,
+		OwnersFile:          a.ownersFile,
+		ProxyUploadURL:      a.proxyUploadURL,
+		StagingDir:          a.stagingDir,
+		MultipartTempDir:    a.multipartTempDir,
+		FormFieldName:       a.formFieldName,
+		SingleFileMode:      a.singleFileMode,
+		RootRedirect:        a.rootRedirect,
+		IndexFile:           a.indexFile,
+		WriteRetries:        a.writeRetries,
+		ListingCacheTTL:     a.listingCacheTTL,
+		MaxUploadDuration:   a.maxUploadDuration,
+		FileMode:            a.fileMode,
+		DirMode:             a.dirMode,
+		MultipartMaxMemory:  a.multipartMaxMemory,
+		TrustedProxies:      a.trustedProxies,
+		AllowedOrigins:      a.allowedOrigins,
+		AllowedExtensions:   a.allowedExtensions,
+		BlockedExtensions:   a.blockedExtensions,
+		MaxUploadSizeByType: a.maxUploadSizeByType,
+		FileAliases:         a.fileAliases,
+		Checksums:           a.checksums,
+		MaxInFlightUploads:  a.maxInFlightUploads,
+		MaxConnectionsPerIP: a.maxConnectionsPerIP,
+		TrashRetention:      a.trashRetention,
 	}
 	if a.enableCORS.IsSet() {
 		configFromFlags.EnableCORS = &a.enableCORS.value
@@ -237,6 +604,60 @@ func (a *app) ParseConfig(args []string) (*simpleuploadserver.ServerConfig, erro
 	if a.enableAuth.IsSet() {
 		configFromFlags.EnableAuth = &a.enableAuth.value
 	}
+	if a.caseInsensitive.IsSet() {
+		configFromFlags.CaseInsensitiveNames = &a.caseInsensitive.value
+	}
+	if a.maintenanceMode.IsSet() {
+		configFromFlags.MaintenanceMode = &a.maintenanceMode.value
+	}
+	if a.enableIndex.IsSet() {
+		configFromFlags.EnableIndex = &a.enableIndex.value
+	}
+	if a.requireIndex.IsSet() {
+		configFromFlags.RequireIndex = &a.requireIndex.value
+	}
+	if a.enableStats.IsSet() {
+		configFromFlags.EnableStats = &a.enableStats.value
+	}
+	if a.corsOnlyWithOrigin.IsSet() {
+		configFromFlags.CORSOnlyWithOrigin = &a.corsOnlyWithOrigin.value
+	}
+	if a.debugLogBodies.IsSet() {
+		configFromFlags.DebugLogBodies = &a.debugLogBodies.value
+	}
+	if a.enableAsyncProcessing.IsSet() {
+		configFromFlags.EnableAsyncProcessing = &a.enableAsyncProcessing.value
+	}
+	if a.generateFileNames.IsSet() {
+		configFromFlags.GenerateFileNames = &a.generateFileNames.value
+	}
+	if a.syncOnUpload.IsSet() {
+		configFromFlags.SyncOnUpload = &a.syncOnUpload.value
+	}
+	if a.requireExtension.IsSet() {
+		configFromFlags.RequireExtension = &a.requireExtension.value
+	}
+	if a.windowsCompatibleNames.IsSet() {
+		configFromFlags.WindowsCompatibleNames = &a.windowsCompatibleNames.value
+	}
+	if a.normalizeUnicode.IsSet() {
+		configFromFlags.NormalizeUnicode = &a.normalizeUnicode.value
+	}
+	if a.enableDirectoryListing.IsSet() {
+		configFromFlags.EnableDirectoryListing = &a.enableDirectoryListing.value
+	}
+	if a.debugVerifyUploads.IsSet() {
+		configFromFlags.DebugVerifyUploads = &a.debugVerifyUploads.value
+	}
+	if a.disableRanges.IsSet() {
+		configFromFlags.DisableRanges = &a.disableRanges.value
+	}
+	if a.conflictDetails.IsSet() {
+		configFromFlags.ConflictDetails = &a.conflictDetails.value
+	}
+	if a.softDelete.IsSet() {
+		configFromFlags.SoftDelete = &a.softDelete.value
+	}
 	log.Printf("config from flag: %+v", configFromFlags)
 	if err := mergo.Merge(&config, configFromFlags, mergo.WithOverride); err != nil {
 		return nil, fmt.Errorf("failed to merge config from flags: %w", err)
@@ -7,6 +7,6 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/mux v1.8.1
 	github.com/spf13/afero v1.11.0
+	golang.org/x/crypto v0.19.0
+	golang.org/x/text v0.14.0
 )
-
-require golang.org/x/text v0.14.0 // indirect
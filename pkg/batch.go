@@ -0,0 +1,160 @@
+package simpleuploadserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// BatchQueryKey is the query parameter to make a multipart request a batch upload responding BatchUploadSummary.
+var BatchQueryKey = "batch"
+
+// NDJSONContentType is the media type of the response to a batch upload. Each line is a BatchUploadResult.
+const NDJSONContentType = "application/x-ndjson"
+
+// BatchUploadResult is the result of a file in a batch upload. Result is the response body that the upload of the
+// file alone would get, and is omitted if it has none.
+type BatchUploadResult struct {
+	Status   int    `json:"status"`
+	Filename string `json:"filename"`
+	Result   any    `json:"result,omitempty"`
+}
+
+// acceptsNDJSON reports whether Accept header of `r` includes NDJSONContentType.
+func acceptsNDJSON(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(v, ",") {
+			mediaType, _, _ := strings.Cut(mediaRange, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), NDJSONContentType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// BatchUploadSummary is the response to a batch upload without NDJSONContentType. OK is true if no file failed.
+type BatchUploadSummary struct {
+	OK         bool                `json:"ok"`
+	Count      int                 `json:"count"`
+	TotalBytes int64               `json:"total_bytes"`
+	Files      []BatchUploadResult `json:"files"`
+}
+
+// isBatchUpload reports whether `r` is a batch upload, which is a multipart request accepting NDJSONContentType or
+// having BatchQueryKey.
+func isBatchUpload(r *http.Request) bool {
+	return isMultipartRequest(r) && (acceptsNDJSON(r) || parseBoolishValue(r.URL.Query().Get(BatchQueryKey)))
+}
+
+// handleBatchUpload stores all the file parts in the multipart body of `r` in order.
+// If `r` accepts NDJSONContentType, the result of each file is written as a line of newline-delimited JSON as soon as
+// it is stored. The response is 200 once the first line is written, so the status of each file is told in its result.
+// Otherwise, BatchUploadSummary is responded after all the files are stored.
+func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) (int, any) {
+	if r.Header.Get(UploadPathHeader) != "" {
+		return http.StatusBadRequest, fmt.Errorf("%s cannot be used for a batch upload", UploadPathHeader)
+	}
+	if trailerChecksum(r) != nil {
+		return http.StatusBadRequest, fmt.Errorf("%s trailer cannot be used for a batch upload", ChecksumTrailer)
+	}
+	ifNewer, err := parseIfNewerQuery(r.URL.Query())
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	cancel := s.limitUploadDuration(r)
+	defer cancel()
+	mr, err := r.MultipartReader()
+	if err != nil {
+		log.Printf("failed to read multipart form: %v", err)
+		return http.StatusInternalServerError, fmt.Errorf("cannot obtain the uploaded content")
+	}
+	if !acceptsNDJSON(r) {
+		summary := BatchUploadSummary{OK: true, Files: []BatchUploadResult{}}
+		s.uploadParts(w, r, mr, ifNewer, func(result BatchUploadResult, size int64) error {
+			summary.OK = summary.OK && result.Status < http.StatusBadRequest
+			summary.Count++
+			summary.TotalBytes += size
+			summary.Files = append(summary.Files, result)
+			return nil
+		})
+		return http.StatusOK, summary
+	}
+
+	rc := http.NewResponseController(w)
+	// HTTP/1.x server stops reading the request body once the response is written unless full duplex is enabled
+	if err := rc.EnableFullDuplex(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("failed to enable full duplex: %v", err)
+	}
+	w.Header().Set("Content-Type", NDJSONContentType)
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	s.uploadParts(w, r, mr, ifNewer, func(result BatchUploadResult, _ int64) error {
+		if err := enc.Encode(result); err != nil {
+			log.Printf("failed to write response: %v", err)
+			return err
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Printf("failed to flush response: %v", err)
+		}
+		return nil
+	})
+	return justOK()
+}
+
+// uploadParts stores the file parts read from `mr` in order, and passes the result of each file with the number of
+// bytes stored to `done`. It stops if `done` returns an error, or the rest of the body cannot be read.
+func (s *Server) uploadParts(w http.ResponseWriter, r *http.Request, mr *multipart.Reader, ifNewer time.Time, done func(BatchUploadResult, int64) error) {
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			// the rest of the body cannot be read, so the batch ends here
+			log.Printf("failed to obtain form file: %v", err)
+			status, resultErr := http.StatusInternalServerError, fmt.Errorf("cannot obtain the uploaded content")
+			if errors.Is(err, errUploadTimeout) {
+				status, resultErr = http.StatusRequestTimeout, errUploadTimeout
+			}
+			done(BatchUploadResult{Status: status, Result: s.errorResult(status, resultErr)}, 0)
+			return
+		}
+		if part.FormName() != s.formFieldName() {
+			continue
+		}
+		status, result, size := s.uploadPart(w, r, part, ifNewer)
+		if err := done(BatchUploadResult{status, part.FileName(), result}, size); err != nil {
+			return
+		}
+	}
+}
+
+// uploadPart stores the file of `part` as the single upload does and returns its status and response body, and the
+// number of bytes stored.
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, part *multipart.Part, ifNewer time.Time) (int, any, int64) {
+	srcFile, info, status, err := s.openPart(w, r, part, "")
+	if err != nil {
+		return status, s.errorResult(status, err), 0
+	}
+	sums := s.newChecksums()
+	status, destPath, err := s.storeUploadedFile(w, r, srcFile, info, "", sums, ifNewer)
+	if err != nil {
+		return status, s.errorResult(status, err), 0
+	}
+	if status == http.StatusNotModified {
+		return status, nil, 0
+	}
+	status, result, _ := s.uploadResult(status, destPath, sums)
+	if err, ok := result.(error); ok {
+		return status, s.errorResult(status, err), 0
+	}
+	return status, result, *sums.size
+}
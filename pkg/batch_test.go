@@ -0,0 +1,215 @@
+package simpleuploadserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_BatchUploadNDJSON(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "existing.txt"), []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	ts := httptest.NewServer(server.router())
+	defer ts.Close()
+
+	files := []struct {
+		name       string
+		content    string
+		wantStatus int
+	}{
+		{"foo.txt", "hello, foo", http.StatusCreated},
+		{"existing.txt", "hello, bar", http.StatusConflict},
+		{"large.txt", "this is larger than the limit", http.StatusRequestEntityTooLarge},
+		{"baz.txt", "hello, baz", http.StatusCreated},
+	}
+	// each file is sent after the result of the previous one is received to check the results are streamed
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	received := make(chan struct{})
+	go func() {
+		for i, f := range files {
+			// the boundary of the next part ends the previous part
+			fw, err := mw.CreateFormFile("file", f.name)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if i > 0 {
+				<-received
+			}
+			if _, err := io.WriteString(fw, f.content); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(mw.Close())
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/upload", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", NDJSONContentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want = %d", resp.StatusCode, http.StatusOK)
+	}
+	if got := resp.Header.Get("Content-Type"); got != NDJSONContentType {
+		t.Errorf("Content-Type = %q, want = %q", got, NDJSONContentType)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for i, f := range files {
+		if !scanner.Scan() {
+			t.Fatalf("missing the result of %s: %v", f.name, scanner.Err())
+		}
+		var result struct {
+			Status   int             `json:"status"`
+			Filename string          `json:"filename"`
+			Result   json.RawMessage `json:"result"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatalf("failed to decode the line %q: %v", scanner.Text(), err)
+		}
+		if result.Status != f.wantStatus || result.Filename != f.name {
+			t.Errorf("result of %s = %d %s, want = %d %s", f.name, result.Status, result.Filename, f.wantStatus, f.name)
+		}
+		if f.wantStatus == http.StatusCreated {
+			var uploaded SuccessfullyUploadedResult
+			if err := json.Unmarshal(result.Result, &uploaded); err != nil {
+				t.Fatalf("failed to decode the result of %s: %v", f.name, err)
+			}
+			if uploaded.Path != "/files/"+f.name {
+				t.Errorf("path of %s = %s, want = /files/%s", f.name, uploaded.Path, f.name)
+			}
+			verifyLocalFile(t, fs, path.Join(docRoot, f.name), []byte(f.content))
+		}
+		if i < len(files)-1 {
+			received <- struct{}{}
+		}
+	}
+	if scanner.Scan() {
+		t.Errorf("unexpected line: %s", scanner.Text())
+	}
+	verifyLocalFile(t, fs, path.Join(docRoot, "existing.txt"), []byte("existing"))
+}
+
+func TestServer_BatchUploadNDJSON_UploadPath(t *testing.T) {
+	server := Server{ServerConfig: ServerConfig{MaxUploadSize: 16}, fs: afero.NewMemMapFs()}
+	req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "foo.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", NDJSONContentType)
+	req.Header.Set(UploadPathHeader, "/foo.txt")
+	rr := httptest.NewRecorder()
+	server.router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want = %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestServer_BatchUploadSummary(t *testing.T) {
+	docRoot := "/opt/app"
+	newRequest := func(files map[string]string, names ...string) *http.Request {
+		b := new(bytes.Buffer)
+		mw := multipart.NewWriter(b)
+		for _, name := range names {
+			fw, err := mw.CreateFormFile(FormFileKey, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(fw, files[name])
+		}
+		mw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/upload?"+BatchQueryKey+"=true", b)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req
+	}
+	files := map[string]string{
+		"a.txt": "hello, a",
+		"b.txt": "hello, bb",
+		"c.txt": "hello, ccc",
+	}
+
+	t.Run("all files are stored", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		server := Server{ServerConfig: ServerConfig{DocumentRoot: docRoot, MaxUploadSize: 16}, fs: afero.NewBasePathFs(fs, docRoot)}
+		rr := httptest.NewRecorder()
+		server.router().ServeHTTP(rr, newRequest(files, "a.txt", "b.txt", "c.txt"))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want = %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+		if got := rr.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q, want = %q", got, "application/json")
+		}
+		// decoded apart from BatchUploadSummary to pin the field names
+		var summary struct {
+			OK         bool                `json:"ok"`
+			Count      int                 `json:"count"`
+			TotalBytes int64               `json:"total_bytes"`
+			Files      []BatchUploadResult `json:"files"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&summary); err != nil {
+			t.Fatalf("failed to decode response body: %v", err)
+		}
+		if !summary.OK || summary.Count != 3 || summary.TotalBytes != 27 {
+			t.Errorf("summary = {ok: %v, count: %d, total_bytes: %d}, want = {ok: true, count: 3, total_bytes: 27}", summary.OK, summary.Count, summary.TotalBytes)
+		}
+		if len(summary.Files) != 3 {
+			t.Fatalf("files = %+v, want 3 files", summary.Files)
+		}
+		for i, name := range []string{"a.txt", "b.txt", "c.txt"} {
+			if f := summary.Files[i]; f.Status != http.StatusCreated || f.Filename != name {
+				t.Errorf("files[%d] = {status: %d, filename: %s}, want = {status: %d, filename: %s}", i, f.Status, f.Filename, http.StatusCreated, name)
+			}
+			verifyLocalFile(t, fs, path.Join(docRoot, name), []byte(files[name]))
+		}
+	})
+
+	t.Run("failed files are counted but not their bytes", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		if err := afero.WriteFile(fs, path.Join(docRoot, "b.txt"), []byte("existing"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		server := Server{ServerConfig: ServerConfig{DocumentRoot: docRoot, MaxUploadSize: 16}, fs: afero.NewBasePathFs(fs, docRoot)}
+		rr := httptest.NewRecorder()
+		server.router().ServeHTTP(rr, newRequest(files, "a.txt", "b.txt", "c.txt"))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want = %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+		var summary BatchUploadSummary
+		if err := json.NewDecoder(rr.Body).Decode(&summary); err != nil {
+			t.Fatalf("failed to decode response body: %v", err)
+		}
+		if summary.OK || summary.Count != 3 || summary.TotalBytes != 18 {
+			t.Errorf("summary = {ok: %v, count: %d, total_bytes: %d}, want = {ok: false, count: 3, total_bytes: 18}", summary.OK, summary.Count, summary.TotalBytes)
+		}
+		if len(summary.Files) != 3 || summary.Files[1].Status != http.StatusConflict {
+			t.Errorf("files = %+v, want the second one to conflict", summary.Files)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package simpleuploadserver
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ChecksumTrailer is the trailer to verify the uploaded content with its SHA-256 checksum in hex.
+var ChecksumTrailer = "X-Checksum-SHA256"
+
+// checksumAlgorithms are the checksums which can be returned in the upload response.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha256": sha256.New,
+}
+
+// checksums computes the size and the checksums over the uploaded content.
+// SHA-256 is always computed, and the others are computed if they are configured by Checksums.
+type checksums struct {
+	hashes map[string]hash.Hash
+	size   *int64
+}
+
+func (s *Server) newChecksums() checksums {
+	c := checksums{hashes: map[string]hash.Hash{"sha256": sha256.New()}, size: new(int64)}
+	for _, name := range s.Checksums {
+		name = strings.ToLower(name)
+		if newHash, ok := checksumAlgorithms[name]; ok && c.hashes[name] == nil {
+			c.hashes[name] = newHash()
+		}
+	}
+	return c
+}
+
+// Writer returns the writer which writes to `w` and all hashes at once, counting the bytes written.
+func (c checksums) Writer(w io.Writer) io.Writer {
+	writers := []io.Writer{w}
+	for _, h := range c.hashes {
+		writers = append(writers, h)
+	}
+	if c.size != nil {
+		writers = append(writers, sizeCounter{c.size})
+	}
+	return io.MultiWriter(writers...)
+}
+
+// Apply sets the size and the checksums to `result`.
+func (c checksums) Apply(result *SuccessfullyUploadedResult) {
+	if c.size != nil {
+		result.Size = *c.size
+	}
+	for name, h := range c.hashes {
+		sum := fmt.Sprintf("%x", h.Sum(nil))
+		switch name {
+		case "md5":
+			result.MD5 = sum
+		case "sha256":
+			result.SHA256 = sum
+		}
+	}
+}
+
+// sizeCounter adds the number of bytes written to it to `n`.
+type sizeCounter struct {
+	n *int64
+}
+
+func (c sizeCounter) Write(p []byte) (int, error) {
+	*c.n += int64(len(p))
+	return len(p), nil
+}
+
+// readStoredFile reads the file at `path` back from the storage to report what has been actually stored.
+func (s *Server) readStoredFile(path string) (*StoredFileResult, error) {
+	f, err := s.fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	return &StoredFileResult{Size: n, SHA256: fmt.Sprintf("%x", h.Sum(nil))}, nil
+}
+
+// validateChecksums returns an error if Checksums has an unsupported algorithm.
+func (s *Server) validateChecksums() error {
+	for _, name := range s.Checksums {
+		if _, ok := checksumAlgorithms[strings.ToLower(name)]; !ok {
+			return fmt.Errorf("unsupported checksum: %s", name)
+		}
+	}
+	return nil
+}
+
+// trailerChecksum returns the hash to verify the content by ChecksumTrailer if `r` declares it, or nil otherwise.
+func trailerChecksum(r *http.Request) hash.Hash {
+	if _, ok := r.Trailer[http.CanonicalHeaderKey(ChecksumTrailer)]; !ok {
+		return nil
+	}
+	return sha256.New()
+}
+
+// verifyTrailerChecksum checks the content hashed by `h` against ChecksumTrailer of `r`. It does nothing if `h` is nil.
+// The rest of the body is discarded since the trailer is available only after the body is read to the end.
+func verifyTrailerChecksum(r *http.Request, h hash.Hash) error {
+	if h == nil {
+		return nil
+	}
+	if _, err := io.Copy(io.Discard, r.Body); err != nil {
+		return fmt.Errorf("failed to read the request body")
+	}
+	want := strings.ToLower(strings.TrimSpace(r.Trailer.Get(ChecksumTrailer)))
+	if want == "" {
+		return fmt.Errorf("%s trailer is declared but not sent", ChecksumTrailer)
+	}
+	if got := fmt.Sprintf("%x", h.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
+}
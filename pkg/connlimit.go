@@ -0,0 +1,50 @@
+package simpleuploadserver
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// connCounter counts the requests being processed per client IP.
+type connCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// acquire counts up the requests from `ip` and reports whether it's within `max`.
+// The caller must call release only if it returns true.
+func (c *connCounter) acquire(ip string, max int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[ip] >= max {
+		return false
+	}
+	if c.counts == nil {
+		c.counts = map[string]int{}
+	}
+	c.counts[ip]++
+	return true
+}
+
+func (c *connCounter) release(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[ip]--; c.counts[ip] <= 0 {
+		delete(c.counts, ip)
+	}
+}
+
+// connLimitMiddleware rejects requests with 429 while MaxConnectionsPerIP requests from the same client are being processed.
+func (s *Server) connLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := s.clientIP(r)
+		if !s.conns.acquire(ip, s.MaxConnectionsPerIP) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(LoadSheddingRetryAfter.Seconds())))
+			writeError(w, r, http.StatusTooManyRequests, "too many connections")
+			return
+		}
+		defer s.conns.release(ip)
+		next.ServeHTTP(w, r)
+	})
+}
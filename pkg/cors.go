@@ -0,0 +1,49 @@
+package simpleuploadserver
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsMiddleware adds CORS headers to all responses, including error responses. Handlers must not set
+// Access-Control-Allow-Origin by themselves not to duplicate it.
+// Access-Control-Allow-Methods for preflight requests is added by handleOptions since it depends on the endpoint.
+func (s *Server) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.isCORSRequest(r) {
+			if len(s.AllowedOrigins) > 0 {
+				// the header depends on Origin, so caches must not share the response across origins
+				w.Header().Add("Vary", "Origin")
+			}
+			if origin := s.allowedOrigin(r); origin != "" {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedOrigin returns the value of Access-Control-Allow-Origin for `r`. It is `*` if AllowedOrigins is empty,
+// Origin of `r` if it is one of AllowedOrigins, and empty otherwise.
+func (s *Server) allowedOrigin(r *http.Request) string {
+	if len(s.AllowedOrigins) == 0 {
+		return "*"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return ""
+	}
+	if slices.ContainsFunc(s.AllowedOrigins, func(o string) bool { return strings.EqualFold(o, origin) }) {
+		return origin
+	}
+	return ""
+}
+
+// isCORSRequest reports whether CORS headers should be added to the response for `r`.
+func (s *Server) isCORSRequest(r *http.Request) bool {
+	if !s.EnableCORS {
+		return false
+	}
+	return !s.CORSOnlyWithOrigin || r.Header.Get("Origin") != ""
+}
@@ -0,0 +1,183 @@
+package simpleuploadserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_CORSOnlyWithOrigin(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "foo.txt"), []byte("hello, world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig{
+		DocumentRoot:       docRoot,
+		MaxUploadSize:      16,
+		EnableCORS:         true,
+		CORSOnlyWithOrigin: true,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	router := server.router()
+
+	tests := []struct {
+		name     string
+		method   string
+		origin   string
+		wantCORS bool
+	}{
+		{"PUT with Origin", http.MethodPut, "https://example.com", true},
+		{"PUT without Origin", http.MethodPut, "", false},
+		{"OPTIONS with Origin", http.MethodOptions, "https://example.com", true},
+		{"OPTIONS without Origin", http.MethodOptions, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/files/foo.txt", nil)
+			if tt.method == http.MethodPut {
+				var err error
+				req, err = makeFormRequest(&url.URL{Path: "/files/bar.txt"}, tt.method, "bar.txt", strings.NewReader("hello"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				q := req.URL.Query()
+				q.Set(OverwriteQueryKey, "true")
+				req.URL.RawQuery = q.Encode()
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if got := rr.Header().Get("Access-Control-Allow-Origin") != ""; got != tt.wantCORS {
+				t.Errorf("has Access-Control-Allow-Origin = %v, want = %v", got, tt.wantCORS)
+			}
+			if tt.method == http.MethodOptions {
+				if got := rr.Header().Get("Access-Control-Allow-Methods") != ""; got != tt.wantCORS {
+					t.Errorf("has Access-Control-Allow-Methods = %v, want = %v", got, tt.wantCORS)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_CORSMiddleware(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "foo.txt"), []byte("hello, world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rwToken := "read-write-token"
+	config := ServerConfig{
+		DocumentRoot:    docRoot,
+		MaxUploadSize:   16,
+		EnableCORS:      true,
+		EnableAuth:      true,
+		ReadWriteTokens: []string{rwToken},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	router := server.router()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		accept string
+		want   int
+	}{
+		{"GET existing file", http.MethodGet, "/files/foo.txt", rwToken, "", http.StatusOK},
+		{"GET missing file", http.MethodGet, "/files/missing.txt", rwToken, "", http.StatusNotFound},
+		{"PUT existing file", http.MethodPut, "/files/foo.txt", rwToken, "", http.StatusConflict},
+		{"PUT new file", http.MethodPut, "/files/new.txt", rwToken, "", http.StatusCreated},
+		{"POST new file", http.MethodPost, "/upload", rwToken, "", http.StatusCreated},
+		{"POST batch upload", http.MethodPost, "/upload", rwToken, NDJSONContentType, http.StatusOK},
+		{"GET without token", http.MethodGet, "/files/foo.txt", "", "", http.StatusUnauthorized},
+		{"OPTIONS", http.MethodOptions, "/files/foo.txt", "", "", http.StatusNoContent},
+		{"unknown endpoint", http.MethodGet, "/unknown", rwToken, "", http.StatusNotFound},
+		{"method not allowed", http.MethodPost, "/files/foo.txt", rwToken, "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.method == http.MethodPut || tt.path == "/upload" {
+				var err error
+				req, err = makeFormRequest(&url.URL{Path: tt.path}, tt.method, tt.name+".txt", strings.NewReader("hello"))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+			if acao := rr.Header().Values("Access-Control-Allow-Origin"); len(acao) != 1 || acao[0] != "*" {
+				t.Errorf("Access-Control-Allow-Origin = %v, want = [*]", acao)
+			}
+		})
+	}
+}
+
+func TestServer_AllowedOrigins(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "foo.txt"), []byte("hello, world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name           string
+		allowedOrigins []string
+		method         string
+		origin         string
+		wantOrigin     string
+		wantVary       bool
+	}{
+		{"wildcard by default", nil, http.MethodGet, "https://example.com", "*", false},
+		{"allowed origin", []string{"https://example.com"}, http.MethodGet, "https://example.com", "https://example.com", true},
+		{"allowed origin in different case", []string{"https://Example.com"}, http.MethodGet, "https://example.com", "https://example.com", true},
+		{"disallowed origin", []string{"https://example.com"}, http.MethodGet, "https://evil.example", "", true},
+		{"no origin", []string{"https://example.com"}, http.MethodGet, "", "", true},
+		{"preflight from allowed origin", []string{"https://example.com"}, http.MethodOptions, "https://example.com", "https://example.com", true},
+		{"preflight from disallowed origin", []string{"https://example.com"}, http.MethodOptions, "https://evil.example", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := ServerConfig{
+				DocumentRoot:   docRoot,
+				EnableCORS:     true,
+				AllowedOrigins: tt.allowedOrigins,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+			req := httptest.NewRequest(tt.method, "/files/foo.txt", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rr := httptest.NewRecorder()
+			server.router().ServeHTTP(rr, req)
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want = %q", got, tt.wantOrigin)
+			}
+			if got := rr.Header().Get("Vary") == "Origin"; got != tt.wantVary {
+				t.Errorf("has Vary: Origin = %v, want = %v", got, tt.wantVary)
+			}
+			if tt.method == http.MethodOptions {
+				if got, want := rr.Header().Get("Access-Control-Allow-Methods") != "", tt.wantOrigin != ""; got != want {
+					t.Errorf("has Access-Control-Allow-Methods = %v, want = %v", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package simpleuploadserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// errUploadTimeout is returned on reading the request body after MaxUploadDuration has passed.
+var errUploadTimeout = errors.New("upload took too long")
+
+// deadlineReader is the request body failing with errUploadTimeout once the deadline of `ctx` is exceeded.
+// The deadline is checked on each read, so a read blocked on a stalled connection is bounded by the server's timeouts.
+type deadlineReader struct {
+	ctx context.Context
+	r   io.ReadCloser
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	if errors.Is(d.ctx.Err(), context.DeadlineExceeded) {
+		return 0, errUploadTimeout
+	}
+	return d.r.Read(p)
+}
+
+func (d *deadlineReader) Close() error {
+	return d.r.Close()
+}
+
+// limitUploadDuration makes reading the body of `r` fail with errUploadTimeout after MaxUploadDuration.
+// The returned function must be called to release the timer. It does nothing if MaxUploadDuration is not set.
+func (s *Server) limitUploadDuration(r *http.Request) (cancel func()) {
+	if s.MaxUploadDuration <= 0 {
+		return func() {}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(s.MaxUploadDuration)*time.Millisecond)
+	r.Body = &deadlineReader{ctx, r.Body}
+	return cancel
+}
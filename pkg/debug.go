@@ -0,0 +1,126 @@
+package simpleuploadserver
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var (
+	// DebugLogBodyLimit is the maximum number of bytes of the request and response bodies written to the debug log.
+	DebugLogBodyLimit = 1024
+	// redactedValue replaces credentials in the debug log.
+	redactedValue = "[REDACTED]"
+)
+
+// debugLogMiddleware logs headers and bodies of requests and responses.
+// Bodies are captured while the handler reads or writes them, so the handler sees them unchanged.
+func (s *Server) debugLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody := &truncatedBuffer{limit: DebugLogBodyLimit}
+		if r.Body != nil {
+			r.Body = teeReadCloser{Reader: io.TeeReader(r.Body, reqBody), Closer: r.Body}
+		}
+		requestLine := fmt.Sprintf("%s %s %s", r.Method, redactTokenQuery(r.URL).RequestURI(), r.Proto)
+		reqHeader := formatDebugHeader(r.Header)
+
+		dw := &debugResponseWriter{ResponseWriter: w, status: http.StatusOK, body: truncatedBuffer{limit: DebugLogBodyLimit}}
+		next.ServeHTTP(dw, r)
+
+		log.Print(s.redactTokens(fmt.Sprintf("[DEBUG] request: %q headers={%s} body=%q", requestLine, reqHeader, reqBody)))
+		log.Print(s.redactTokens(fmt.Sprintf("[DEBUG] response: %d headers={%s} body=%q", dw.status, formatDebugHeader(dw.Header()), &dw.body)))
+	})
+}
+
+// redactTokens replaces all known tokens in `s`.
+func (s *Server) redactTokens(str string) string {
+	named := make([]string, 0, len(s.NamedTokens))
+	for _, token := range s.NamedTokens {
+		named = append(named, token)
+	}
+	for _, tokens := range [][]string{s.ReadWriteTokens, s.ReadOnlyTokens, named, s.AdminTokens} {
+		for _, token := range tokens {
+			if token != "" {
+				str = strings.ReplaceAll(str, token, redactedValue)
+			}
+		}
+	}
+	return str
+}
+
+// formatDebugHeader formats `h` in the sorted order of the keys. Credentials are redacted.
+func formatDebugHeader(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	vs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := strings.Join(h.Values(k), ", ")
+		switch k {
+		case "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie":
+			v = redactedValue
+		}
+		vs = append(vs, fmt.Sprintf("%s: %q", k, v))
+	}
+	return strings.Join(vs, ", ")
+}
+
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// truncatedBuffer keeps the first `limit` bytes written to it and discards the rest.
+type truncatedBuffer struct {
+	buf       []byte
+	limit     int
+	truncated bool
+}
+
+func (b *truncatedBuffer) Write(p []byte) (int, error) {
+	if rest := b.limit - len(b.buf); rest < len(p) {
+		b.buf = append(b.buf, p[:max(rest, 0)]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
+
+func (b *truncatedBuffer) String() string {
+	if b.truncated {
+		return string(b.buf) + "...(truncated)"
+	}
+	return string(b.buf)
+}
+
+type debugResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        truncatedBuffer
+}
+
+func (w *debugResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *debugResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the underlying writer for http.ResponseController to flush the response.
+func (w *debugResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
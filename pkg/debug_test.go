@@ -0,0 +1,66 @@
+package simpleuploadserver
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_DebugLogBodies(t *testing.T) {
+	var buf bytes.Buffer
+	orig := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(orig)
+
+	config := ServerConfig{
+		DocumentRoot:    "/opt/app",
+		MaxUploadSize:   16,
+		EnableAuth:      true,
+		ReadWriteTokens: []string{"rw-secret"},
+		ReadOnlyTokens:  []string{"ro-secret"},
+		AdminTokens:     []string{"admin-secret"},
+		DebugLogBodies:  true,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewMemMapFs()}
+	router := server.router()
+
+	req := httptest.NewRequest(http.MethodPost, "/maintenance?token=ro-secret", strings.NewReader(`{"enabled":false,"note":"rw-secret"}`))
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+	}
+
+	logged := buf.String()
+	for _, secret := range []string{"rw-secret", "ro-secret", "admin-secret"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("log contains the token %q: %s", secret, logged)
+		}
+	}
+	for _, want := range []string{
+		`Authorization: "[REDACTED]"`,
+		`POST /maintenance?token=%5BREDACTED%5D HTTP/1.1`,
+		`{\"enabled\":false,\"note\":\"[REDACTED]\"}`,
+		`response: 200`,
+		`{\"ok\":true,\"maintenance\":false}`,
+	} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log does not contain %q: %s", want, logged)
+		}
+	}
+}
+
+func TestTruncatedBuffer(t *testing.T) {
+	b := truncatedBuffer{limit: 5}
+	b.Write([]byte("hel"))
+	b.Write([]byte("lo, world"))
+	if got, want := b.String(), "hello...(truncated)"; got != want {
+		t.Errorf("String() = %q, want = %q", got, want)
+	}
+}
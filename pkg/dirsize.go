@@ -0,0 +1,100 @@
+package simpleuploadserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// SizeQueryKey is the query parameter to request the total size of the files under a directory instead of its content.
+var SizeQueryKey = "size"
+
+// DirectorySizeMaxEntries is the maximum number of entries walked to compute the size of a directory.
+// Larger directories are rejected with 422 not to spend the server on a single request.
+var DirectorySizeMaxEntries = 100000
+
+var errTooManyEntries = errors.New("too many entries")
+
+type DirectorySizeResult struct {
+	OK        bool  `json:"ok"`
+	Size      int64 `json:"size"`
+	FileCount int   `json:"file_count"`
+}
+
+// serveDirectorySize responds the total size and the number of the files under `requestPath` recursively.
+// Symbolic links are not followed. A regular file is counted as itself. The files are taken from the index if it is
+// enabled, instead of walking the directory.
+func (s *Server) serveDirectorySize(r *http.Request, requestPath string) (int, any) {
+	if s.index != nil {
+		return s.indexedDirectorySize(requestPath)
+	}
+	var result DirectorySizeResult
+	entries := 0
+	err := afero.Walk(s.fs, requestPath, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		if fi.IsDir() && isReservedPath(canonicalPath(p)) {
+			return filepath.SkipDir
+		}
+		entries++
+		if entries > DirectorySizeMaxEntries {
+			return errTooManyEntries
+		}
+		if fi.Mode().IsRegular() && !isReservedPath(canonicalPath(p)) {
+			result.Size += fi.Size()
+			result.FileCount++
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound, fmt.Errorf("file not found")
+	case errors.Is(err, os.ErrPermission):
+		log.Printf("permission denied (path=%s): %v", requestPath, err)
+		return http.StatusForbidden, fmt.Errorf("permission denied")
+	case errors.Is(err, errTooManyEntries):
+		return http.StatusUnprocessableEntity, fmt.Errorf("the directory has more than %d entries", DirectorySizeMaxEntries)
+	case err != nil:
+		log.Printf("failed to compute the size (path=%s): %v", requestPath, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to compute the size")
+	}
+	result.OK = true
+	return http.StatusOK, result
+}
+
+// indexedDirectorySize is serveDirectorySize computed from the index.
+func (s *Server) indexedDirectorySize(requestPath string) (int, any) {
+	fi, err := s.fs.Stat(requestPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound, fmt.Errorf("file not found")
+	case errors.Is(err, os.ErrPermission):
+		log.Printf("permission denied (path=%s): %v", requestPath, err)
+		return http.StatusForbidden, fmt.Errorf("permission denied")
+	case err != nil:
+		log.Printf("failed to compute the size (path=%s): %v", requestPath, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to compute the size")
+	}
+	result := DirectorySizeResult{OK: true}
+	if !fi.IsDir() {
+		if fi.Mode().IsRegular() {
+			result.Size = fi.Size()
+			result.FileCount = 1
+		}
+		return http.StatusOK, result
+	}
+	for _, m := range s.index.ListDir(requestPath) {
+		result.Size += m.Size
+		result.FileCount++
+	}
+	return http.StatusOK, result
+}
@@ -0,0 +1,89 @@
+package simpleuploadserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_DirectorySize(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		"top.txt":            "top",
+		"dir/a.txt":          "hello",
+		"dir/sub/b.txt":      "hello, world",
+		"dir/sub/deep/c.bin": "0123456789",
+	}
+	for name, content := range files {
+		if err := afero.WriteFile(fs, path.Join(docRoot, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.MkdirAll(path.Join(docRoot, "dir/empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig{
+		DocumentRoot:   docRoot,
+		EnableAuth:     true,
+		ReadOnlyTokens: []string{"ro-token"},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	router := server.router()
+
+	tests := []struct {
+		name string
+		url  string
+		want DirectorySizeResult
+	}{
+		{"directory", "/files/dir?size=true", DirectorySizeResult{true, 27, 3}},
+		{"subdirectory", "/files/dir/sub/?size=true", DirectorySizeResult{true, 22, 2}},
+		{"empty directory", "/files/dir/empty?size=true", DirectorySizeResult{true, 0, 0}},
+		{"document root", "/files/?size=true", DirectorySizeResult{true, 30, 4}},
+		{"file", "/files/top.txt?size=true", DirectorySizeResult{true, 3, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.Header.Set("Authorization", "Bearer ro-token")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want = %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+			}
+			var result DirectorySizeResult
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if result != tt.want {
+				t.Errorf("result = %+v, want = %+v", result, tt.want)
+			}
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/files/missing?size=true", nil)
+		req.Header.Set("Authorization", "Bearer ro-token")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("too many entries", func(t *testing.T) {
+		defer func(n int) { DirectorySizeMaxEntries = n }(DirectorySizeMaxEntries)
+		DirectorySizeMaxEntries = 3
+		req := httptest.NewRequest(http.MethodGet, "/files/dir?size=true", nil)
+		req.Header.Set("Authorization", "Bearer ro-token")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusUnprocessableEntity)
+		}
+	})
+}
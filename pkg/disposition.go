@@ -0,0 +1,52 @@
+package simpleuploadserver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DownloadQueryKey is the query parameter to request the file as an attachment.
+var DownloadQueryKey = "download"
+
+// contentDisposition builds a value of Content-Disposition header for `filename`.
+// Non-ASCII names are encoded as RFC 5987 `filename*` with an ASCII fallback in `filename`.
+func contentDisposition(dispositionType, filename string) string {
+	fallback, isASCII := asciiFilename(filename)
+	if isASCII {
+		return fmt.Sprintf(`%s; filename="%s"`, dispositionType, fallback)
+	}
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, dispositionType, fallback, encodeRFC5987(filename))
+}
+
+// asciiFilename returns `filename` with non-ASCII and quoting characters replaced with '_'.
+// The second return value reports whether the name consists of ASCII characters only.
+func asciiFilename(filename string) (string, bool) {
+	isASCII := true
+	var b strings.Builder
+	for _, c := range filename {
+		switch {
+		case c > 0x7e:
+			isASCII = false
+			b.WriteByte('_')
+		case c < 0x20, c == '"', c == '\\':
+			b.WriteByte('_')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String(), isASCII
+}
+
+// encodeRFC5987 percent-encodes `s` except attr-chars defined in RFC 5987.
+func encodeRFC5987(s string) string {
+	const attrChars = "!#$&+-.^_`|~"
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte(attrChars, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
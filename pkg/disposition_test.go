@@ -0,0 +1,60 @@
+package simpleuploadserver
+
+import (
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func Test_contentDisposition(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		want     string
+	}{
+		{"ascii", "hello.txt", `attachment; filename="hello.txt"`},
+		{"quote", `a"b.txt`, `attachment; filename="a_b.txt"`},
+		{"japanese", "日本語.txt", `attachment; filename="___.txt"; filename*=UTF-8''%E6%97%A5%E6%9C%AC%E8%AA%9E.txt`},
+		{"space", "hello world ü.txt", `attachment; filename="hello world _.txt"; filename*=UTF-8''hello%20world%20%C3%BC.txt`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := contentDisposition("attachment", tt.filename); got != tt.want {
+				t.Errorf("contentDisposition() = %s, want = %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_GetWithContentDisposition(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "日本語.txt"), []byte("こんにちは"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := Server{ServerConfig: ServerConfig{DocumentRoot: docRoot}, fs: afero.NewBasePathFs(fs, docRoot)}
+
+	req := httptest.NewRequest(http.MethodGet, "/files/%E6%97%A5%E6%9C%AC%E8%AA%9E.txt?download=true", nil)
+	rr := httptest.NewRecorder()
+	server.handle(server.handleGet).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+	}
+	cd := rr.Header().Get("Content-Disposition")
+	want := `attachment; filename="___.txt"; filename*=UTF-8''%E6%97%A5%E6%9C%AC%E8%AA%9E.txt`
+	if cd != want {
+		t.Errorf("Content-Disposition = %s, want = %s", cd, want)
+	}
+	// mime package decodes RFC 2231/5987 parameters, so the original name should be recovered
+	_, params, err := mime.ParseMediaType(cd)
+	if err != nil {
+		t.Fatalf("failed to parse Content-Disposition: %v", err)
+	}
+	if params["filename"] != "日本語.txt" {
+		t.Errorf("decoded filename = %s, want = 日本語.txt", params["filename"])
+	}
+}
@@ -4,36 +4,162 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 )
 
 type FileNamingStrategy func(multipart.File, *multipart.FileHeader) (string, error)
 
-func UUIDStrategy(multipart.File, *multipart.FileHeader) (string, error) {
-	return uuid.NewString(), nil
+// UUIDStrategy names the file with a random UUID. The extension of the original file name is kept if any.
+func UUIDStrategy(_ multipart.File, info *multipart.FileHeader) (string, error) {
+	return uuid.NewString() + originalExtension(info.Filename), nil
 }
 
+// SHA256Strategy names the file with the SHA-256 checksum of the content in hex.
+// The extension of the original file name is kept if any.
 func SHA256Strategy(file multipart.File, info *multipart.FileHeader) (string, error) {
 	h := sha256.New()
 	if _, err := io.Copy(h, file); err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("%x", h.Sum(nil)), nil
+	return fmt.Sprintf("%x", h.Sum(nil)) + originalExtension(info.Filename), nil
 }
 
-var strategies = map[string]FileNamingStrategy{
-	"uuid":   UUIDStrategy,
-	"sha256": SHA256Strategy,
+// originalExtension returns the extension of `filename` as it is, including `.tar` of compressed archives
+// (e.g. `.tar.gz`). It is empty if the file name has no extension or is a dotfile such as `.bashrc`.
+func originalExtension(filename string) string {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filepath.Base(filename), ext)
+	if base == "" {
+		return ""
+	}
+	if inner := filepath.Ext(base); strings.EqualFold(inner, ".tar") && inner != base {
+		ext = inner + ext
+	}
+	return ext
+}
+
+// preferredExtensions are the extensions for the content types having several extensions.
+var preferredExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"text/plain": ".txt",
+	"text/html":  ".html",
+}
+
+// extensionByType returns the extension for `contentType`, or an empty string if unknown.
+func extensionByType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "application/octet-stream" {
+		return ""
+	}
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+	exts, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	// prefer the extension same as the subtype (e.g. `.png` for `image/png`)
+	_, subtype, _ := strings.Cut(mediaType, "/")
+	for _, ext := range exts {
+		if ext == "."+subtype {
+			return ext
+		}
+	}
+	return exts[0]
+}
+
+// registeredStrategy is a file naming strategy registered by name.
+type registeredStrategy struct {
+	fn FileNamingStrategy
+	// the name is derived only from the content, so the existing file of the name has the same content
+	contentAddressed bool
 }
 
+var (
+	strategiesMu sync.RWMutex
+	strategies   = map[string]registeredStrategy{
+		"uuid":   {fn: UUIDStrategy},
+		"sha256": {fn: SHA256Strategy, contentAddressed: true},
+	}
+)
+
 var DefaultNamingStrategy FileNamingStrategy = UUIDStrategy
 
+// RegisterFileNamingStrategy makes `fn` available as the file naming strategy of `name`, which is case-insensitive.
+// The strategy of the same name is replaced, including the built-in ones. It is safe for concurrent use.
+func RegisterFileNamingStrategy(name string, fn FileNamingStrategy) {
+	if fn == nil {
+		panic("simpleuploadserver: RegisterFileNamingStrategy with nil strategy")
+	}
+	registerFileNamingStrategy(name, registeredStrategy{fn: fn})
+}
+
+// RegisterContentAddressedNamingStrategy is RegisterFileNamingStrategy for `fn` deriving the name only from the
+// content, such as SHA256Strategy. An upload named by it is not written again if the file of the name exists, since
+// the file has the same content.
+func RegisterContentAddressedNamingStrategy(name string, fn FileNamingStrategy) {
+	if fn == nil {
+		panic("simpleuploadserver: RegisterContentAddressedNamingStrategy with nil strategy")
+	}
+	registerFileNamingStrategy(name, registeredStrategy{fn: fn, contentAddressed: true})
+}
+
+func registerFileNamingStrategy(name string, strategy registeredStrategy) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	strategies[strings.ToLower(name)] = strategy
+}
+
 func ResolveFileNamingStrategy(name string) FileNamingStrategy {
+	return resolveNamingStrategy(name).fn
+}
+
+// resolveNamingStrategy returns the strategy registered as `name`, or DefaultNamingStrategy if `name` is empty.
+// The function of the returned strategy is nil if `name` is not registered.
+func resolveNamingStrategy(name string) registeredStrategy {
 	if name == "" {
-		return DefaultNamingStrategy
+		return registeredStrategy{fn: DefaultNamingStrategy}
 	}
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
 	return strategies[strings.ToLower(name)]
 }
+
+// fileNamingStrategyNames returns the names of the registered strategies in the sorted order.
+func fileNamingStrategyNames() []string {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// validateNamingStrategy returns an error if FileNamingStrategy is not registered.
+// It is not used if a strategy is given by WithNamingStrategy.
+func (s *Server) validateNamingStrategy() error {
+	if s.namer != nil || ResolveFileNamingStrategy(s.FileNamingStrategy) != nil {
+		return nil
+	}
+	return fmt.Errorf("unknown file naming strategy: %s (available: %s)", s.FileNamingStrategy, strings.Join(fileNamingStrategyNames(), ", "))
+}
+
+type StrategiesResult struct {
+	OK         bool     `json:"ok"`
+	Strategies []string `json:"strategies"`
+}
+
+// handleStrategies reports the names of the available file naming strategies in the sorted order.
+func (s *Server) handleStrategies(w http.ResponseWriter, r *http.Request) (int, any) {
+	return http.StatusOK, StrategiesResult{true, fileNamingStrategyNames()}
+}
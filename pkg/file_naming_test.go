@@ -0,0 +1,181 @@
+package simpleuploadserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_Strategies(t *testing.T) {
+	config := ServerConfig{
+		DocumentRoot:  "/opt/app",
+		MaxUploadSize: 16,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewMemMapFs()}
+	req := httptest.NewRequest(http.MethodGet, "/strategies", nil)
+	rr := httptest.NewRecorder()
+	server.router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+	}
+	var result StrategiesResult
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	for _, name := range []string{"uuid", "sha256"} {
+		if !slices.Contains(result.Strategies, name) {
+			t.Errorf("strategies = %v, want to contain %s", result.Strategies, name)
+		}
+	}
+}
+
+func TestRegisterFileNamingStrategy(t *testing.T) {
+	custom := func(_ multipart.File, info *multipart.FileHeader) (string, error) {
+		return "custom-" + info.Filename, nil
+	}
+	RegisterFileNamingStrategy("Custom", custom)
+	t.Cleanup(func() {
+		strategiesMu.Lock()
+		delete(strategies, "custom")
+		strategiesMu.Unlock()
+	})
+
+	namer := ResolveFileNamingStrategy("custom")
+	if namer == nil {
+		t.Fatal("ResolveFileNamingStrategy(custom) = nil")
+	}
+	name, err := namer(nil, &multipart.FileHeader{Filename: "foo.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "custom-foo.txt" {
+		t.Errorf("name = %s, want = custom-foo.txt", name)
+	}
+	if names := fileNamingStrategyNames(); !slices.Contains(names, "custom") {
+		t.Errorf("strategies = %v, want to contain custom", names)
+	}
+}
+
+func TestRegisterContentAddressedNamingStrategy(t *testing.T) {
+	fixed := func(multipart.File, *multipart.FileHeader) (string, error) {
+		return "fixed.txt", nil
+	}
+	t.Cleanup(func() {
+		strategiesMu.Lock()
+		delete(strategies, "fixed")
+		strategiesMu.Unlock()
+	})
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "fixed.txt"), []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig{
+		DocumentRoot:       docRoot,
+		MaxUploadSize:      1024,
+		FileNamingStrategy: "fixed",
+		GenerateFileNames:  true,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	post := func() int {
+		req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "foo.txt", strings.NewReader("uploaded"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		server.handle(server.handlePost).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// the existing file is taken as the same content
+	RegisterContentAddressedNamingStrategy("fixed", fixed)
+	if status := post(); status != http.StatusOK {
+		t.Errorf("content-addressed: status = %d, want = %d", status, http.StatusOK)
+	}
+	verifyLocalFile(t, fs, path.Join(docRoot, "fixed.txt"), []byte("existing"))
+
+	RegisterFileNamingStrategy("fixed", fixed)
+	if status := post(); status != http.StatusConflict {
+		t.Errorf("not content-addressed: status = %d, want = %d", status, http.StatusConflict)
+	}
+}
+
+// memFile is a multipart.File on memory.
+type memFile struct {
+	*bytes.Reader
+}
+
+func (memFile) Close() error { return nil }
+
+func TestNamingStrategies_Extension(t *testing.T) {
+	content := []byte("hello")
+	sum := fmt.Sprintf("%x", sha256.Sum256(content))
+	tests := []struct {
+		name     string
+		filename string
+		wantExt  string
+	}{
+		{"simple", "photo.jpg", ".jpg"},
+		{"compressed archive", "backup.tar.gz", ".tar.gz"},
+		{"uppercase", "PHOTO.JPG", ".JPG"},
+		{"no extension", "README", ""},
+		{"dotfile", ".bashrc", ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &multipart.FileHeader{Filename: tt.filename}
+			got, err := UUIDStrategy(nil, info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !regexp.MustCompile(`^[0-9a-f-]{36}` + regexp.QuoteMeta(tt.wantExt) + `$`).MatchString(got) {
+				t.Errorf("UUIDStrategy() = %s, want a UUID with %q", got, tt.wantExt)
+			}
+			got, err = SHA256Strategy(memFile{bytes.NewReader(content)}, info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := sum + tt.wantExt; got != want {
+				t.Errorf("SHA256Strategy() = %s, want = %s", got, want)
+			}
+		})
+	}
+}
+
+func TestServer_UnknownNamingStrategy(t *testing.T) {
+	server := NewServer(ServerConfig{MaxUploadSize: 16, FileNamingStrategy: "bogus"}, WithFileSystem(afero.NewMemMapFs()))
+	err := server.Start(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown file naming strategy: bogus") {
+		t.Fatalf("Start() error = %v, want unknown strategy error", err)
+	}
+	for _, name := range fileNamingStrategyNames() {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q should list %s", err, name)
+		}
+	}
+
+	// the handler is usable without Start
+	req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "", bytes.NewBufferString("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want = %d", rr.Code, http.StatusInternalServerError)
+	}
+}
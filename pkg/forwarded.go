@@ -0,0 +1,94 @@
+package simpleuploadserver
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// isTrustedProxy reports whether the request is sent from one of TrustedProxies.
+func (s *Server) isTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return s.isTrustedIP(ip)
+}
+
+// isTrustedIP reports whether `ip` is one of TrustedProxies.
+func (s *Server) isTrustedIP(ip net.IP) bool {
+	for _, p := range s.TrustedProxies {
+		if strings.Contains(p, "/") {
+			if _, cidr, err := net.ParseCIDR(p); err == nil && cidr.Contains(ip) {
+				return true
+			}
+		} else if trusted := net.ParseIP(p); trusted != nil && trusted.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestScheme returns the scheme which the client used.
+// X-Forwarded-Proto is respected only if the request comes from a trusted proxy.
+func (s *Server) requestScheme(r *http.Request) string {
+	if s.isTrustedProxy(r) {
+		// the first value is set by the proxy nearest to the client
+		proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		proto = strings.ToLower(strings.TrimSpace(proto))
+		if proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// absoluteURL returns the absolute URL for `path` on this server as seen by the client.
+func (s *Server) absoluteURL(r *http.Request, path string) string {
+	u := url.URL{
+		Scheme: s.requestScheme(r),
+		Host:   r.Host,
+		Path:   path,
+	}
+	return u.String()
+}
+
+// clientIP returns the IP address of the client.
+// X-Forwarded-For is respected only if the request comes from a trusted proxy. Each proxy appends the address it is
+// connected from, and the client can send any addresses in front of them. So the addresses are read from the last, and
+// the first one which is not a trusted proxy is taken as the client.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.isTrustedProxy(r) {
+		var addrs []string
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			addrs = append(addrs, strings.Split(v, ",")...)
+		}
+		var client net.IP
+		for i := len(addrs) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+			if ip == nil {
+				break
+			}
+			client = ip
+			if !s.isTrustedIP(ip) {
+				break
+			}
+		}
+		if client != nil {
+			return client.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,88 @@
+package simpleuploadserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_LocationWithForwardedProto(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		forwardedProto string
+		want           string
+	}{
+		{"trusted proxy", []string{"192.0.2.0/24"}, "https", "https://example.com/files/hello.txt"},
+		{"trusted proxy by IP", []string{"192.0.2.1"}, "https", "https://example.com/files/hello.txt"},
+		{"untrusted proxy", []string{"198.51.100.1"}, "https", "http://example.com/files/hello.txt"},
+		{"no header", []string{"192.0.2.0/24"}, "", "http://example.com/files/hello.txt"},
+		{"invalid proto", []string{"192.0.2.0/24"}, "gopher", "http://example.com/files/hello.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRoot := "/opt/app"
+			config := ServerConfig{
+				DocumentRoot:   docRoot,
+				MaxUploadSize:  16,
+				TrustedProxies: tt.trustedProxies,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+			req, err := makeFormRequest(&url.URL{Path: "/files/hello.txt"}, http.MethodPut, "hello.txt", strings.NewReader("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the address of the reverse proxy
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Host = "example.com"
+			if tt.forwardedProto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwardedProto)
+			}
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePut).ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+			}
+			if loc := rr.Header().Get("Location"); loc != tt.want {
+				t.Errorf("Location = %s, want = %s", loc, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_ClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"no header", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"untrusted proxy", "198.51.100.1:1234", []string{"203.0.113.7"}, "198.51.100.1"},
+		{"single proxy", "192.0.2.1:1234", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"chained proxies", "192.0.2.1:1234", []string{"203.0.113.7, 192.0.2.2"}, "203.0.113.7"},
+		{"spoofed leading entry", "192.0.2.1:1234", []string{"198.51.100.99, 203.0.113.7"}, "203.0.113.7"},
+		{"spoofed trusted entry", "192.0.2.1:1234", []string{"192.0.2.2, 203.0.113.7"}, "203.0.113.7"},
+		{"multiple headers", "192.0.2.1:1234", []string{"198.51.100.99", "203.0.113.7, 192.0.2.2"}, "203.0.113.7"},
+		{"all trusted", "192.0.2.1:1234", []string{"192.0.2.3, 192.0.2.2"}, "192.0.2.3"},
+		{"invalid entry", "192.0.2.1:1234", []string{"203.0.113.7, garbage, 192.0.2.2"}, "192.0.2.2"},
+		{"only invalid entry", "192.0.2.1:1234", []string{"garbage"}, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := Server{ServerConfig: ServerConfig{TrustedProxies: []string{"192.0.2.0/24"}}}
+			req := httptest.NewRequest(http.MethodGet, "/files/hello.txt", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if got := server.clientIP(req); got != tt.want {
+				t.Errorf("clientIP() = %s, want = %s", got, tt.want)
+			}
+		})
+	}
+}
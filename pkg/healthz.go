@@ -0,0 +1,16 @@
+package simpleuploadserver
+
+import "net/http"
+
+// HealthzPath is the path for liveness probes of load balancers and orchestrators.
+// It is available without authentication.
+var HealthzPath = "/healthz"
+
+type HealthzResult struct {
+	OK bool `json:"ok"`
+}
+
+// handleHealthz reports that the server is up. It does not touch the file system so that probes stay cheap.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) (int, any) {
+	return http.StatusOK, HealthzResult{true}
+}
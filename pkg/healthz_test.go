@@ -0,0 +1,33 @@
+package simpleuploadserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_Healthz(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ServerConfig
+	}{
+		{"auth enabled", ServerConfig{DocumentRoot: "/opt/app", EnableAuth: true, ReadWriteTokens: []string{"rw"}}},
+		{"single file mode", ServerConfig{DocumentRoot: "/opt/app", SingleFileMode: "/opt/app/index.html"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := Server{ServerConfig: tt.config, fs: afero.NewMemMapFs()}
+			rr := httptest.NewRecorder()
+			server.router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if rr.Code != http.StatusOK {
+				t.Errorf("status = %d, want = %d", rr.Code, http.StatusOK)
+			}
+			if body, want := rr.Body.String(), `{"ok":true}`; body != want {
+				t.Errorf("body = %s, want = %s", body, want)
+			}
+		})
+	}
+
+}
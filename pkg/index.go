@@ -0,0 +1,360 @@
+package simpleuploadserver
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// FileMetadata is an entry of Index.
+type FileMetadata struct {
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	ContentType string    `json:"content_type"`
+	SHA256      string    `json:"sha256"`
+}
+
+// IndexSaveDelay is the delay to save the index to the file after a change, so that a burst of changes is saved at once.
+var IndexSaveDelay = time.Second
+
+// Index is an in-memory index of the files in the document root.
+// It is safe for concurrent use.
+type Index struct {
+	mu      sync.RWMutex
+	entries map[string]FileMetadata
+	// modification times of the directories
+	dirs        map[string]time.Time
+	persistPath string
+	// set while a save is scheduled
+	saveTimer *time.Timer
+}
+
+// NewIndex creates an empty Index.
+// If `persistPath` is not empty, the index is saved to the file shortly after changes. Call Flush to save the pending
+// changes immediately.
+func NewIndex(persistPath string) *Index {
+	return &Index{
+		entries:     map[string]FileMetadata{},
+		dirs:        map[string]time.Time{},
+		persistPath: persistPath,
+	}
+}
+
+// Get returns the metadata of the file at `p`.
+func (idx *Index) Get(p string) (FileMetadata, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	m, ok := idx.entries[indexKey(p)]
+	return m, ok
+}
+
+// List returns all entries sorted by the path.
+func (idx *Index) List() []FileMetadata {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	list := make([]FileMetadata, 0, len(idx.entries))
+	for _, m := range idx.entries {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// ListDir returns the entries of the files under the directory at `dir` recursively, sorted by the path.
+func (idx *Index) ListDir(dir string) []FileMetadata {
+	prefix := indexKey(dir)
+	if prefix != "/" {
+		prefix += "/"
+	}
+	var list []FileMetadata
+	for _, m := range idx.List() {
+		if strings.HasPrefix(m.Path, prefix) {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// ReadDir returns the entries of the directory at `dir` sorted by name, in the same way as afero.ReadDir.
+func (idx *Index) ReadDir(dir string) []os.FileInfo {
+	prefix := indexKey(dir)
+	if prefix != "/" {
+		prefix += "/"
+	}
+	var infos []os.FileInfo
+	for _, m := range idx.ListDir(dir) {
+		if name := strings.TrimPrefix(m.Path, prefix); !strings.Contains(name, "/") {
+			infos = append(infos, &indexedFileInfo{name: name, size: m.Size, modTime: m.ModTime})
+		}
+	}
+	idx.mu.RLock()
+	for p, modTime := range idx.dirs {
+		if name, ok := strings.CutPrefix(p, prefix); ok && name != "" && !strings.Contains(name, "/") {
+			infos = append(infos, &indexedFileInfo{name: name, modTime: modTime, isDir: true})
+		}
+	}
+	idx.mu.RUnlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos
+}
+
+// indexedFileInfo is os.FileInfo of an entry given by Index.ReadDir. The permission is unknown.
+type indexedFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi *indexedFileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *indexedFileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *indexedFileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *indexedFileInfo) IsDir() bool {
+	return fi.isDir
+}
+
+func (fi *indexedFileInfo) Sys() any {
+	return nil
+}
+
+func (fi *indexedFileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir
+	}
+	return 0
+}
+
+// Update computes the metadata of the file at `p` and stores it to the index.
+// The directories containing the file are also stored since they may be created for the file.
+func (idx *Index) Update(fs afero.Fs, p string) error {
+	m, err := computeMetadata(fs, p)
+	if err != nil {
+		return err
+	}
+	dirs := statDirs(fs, path.Dir(m.Path))
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.entries[m.Path] = m
+	for dir, modTime := range dirs {
+		idx.dirs[dir] = modTime
+	}
+	idx.scheduleSave()
+	return nil
+}
+
+// Remove removes the entry of the file at `p`. The directory containing the file is kept.
+func (idx *Index) Remove(fs afero.Fs, p string) error {
+	key := indexKey(p)
+	dirs := statDirs(fs, path.Dir(key))
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.entries, key)
+	for dir, modTime := range dirs {
+		idx.dirs[dir] = modTime
+	}
+	idx.scheduleSave()
+	return nil
+}
+
+// statDirs returns the modification times of the directory at `dir` and its parents except the root.
+func statDirs(fs afero.Fs, dir string) map[string]time.Time {
+	dirs := map[string]time.Time{}
+	for ; dir != "/" && dir != "."; dir = path.Dir(dir) {
+		if fi, err := fs.Stat(dir); err == nil && fi.IsDir() {
+			dirs[dir] = fi.ModTime()
+		}
+	}
+	return dirs
+}
+
+// Rebuild replaces all entries by walking `fs`.
+// The persisted checksum is reused if the size and the modification time are not changed.
+func (idx *Index) Rebuild(fs afero.Fs) error {
+	persisted, err := idx.load()
+	if err != nil {
+		log.Printf("failed to load the persisted index; rebuilding from scratch: %v", err)
+	}
+	entries := map[string]FileMetadata{}
+	dirs := map[string]time.Time{}
+	err = afero.Walk(fs, "/", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		key := indexKey(p)
+		if isReservedPath(key) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if key != "/" {
+				dirs[key] = info.ModTime()
+			}
+			return nil
+		}
+		if m, ok := persisted[key]; ok && m.Size == info.Size() && m.ModTime.Equal(info.ModTime()) {
+			entries[key] = m
+			return nil
+		}
+		m, err := computeMetadata(fs, p)
+		if err != nil {
+			return err
+		}
+		entries[key] = m
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk the document root: %w", err)
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.entries, idx.dirs = entries, dirs
+	log.Printf("index rebuilt (%d files)", len(entries))
+	idx.cancelSave()
+	return idx.save()
+}
+
+// Flush saves the pending changes to persistPath immediately.
+func (idx *Index) Flush() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.saveTimer == nil {
+		return nil
+	}
+	idx.cancelSave()
+	return idx.save()
+}
+
+// scheduleSave saves the entries after IndexSaveDelay unless a save is already scheduled. The caller must hold the lock.
+func (idx *Index) scheduleSave() {
+	if idx.persistPath == "" || idx.saveTimer != nil {
+		return
+	}
+	idx.saveTimer = time.AfterFunc(IndexSaveDelay, func() {
+		if err := idx.Flush(); err != nil {
+			log.Printf("failed to save the index: %v", err)
+		}
+	})
+}
+
+// cancelSave cancels the scheduled save. The caller must hold the lock.
+func (idx *Index) cancelSave() {
+	if idx.saveTimer != nil {
+		idx.saveTimer.Stop()
+		idx.saveTimer = nil
+	}
+}
+
+// buildIndex rebuilds the index on startup.
+// If it fails, the index is disabled so that the metadata is computed from the files directly, unless RequireIndex is set.
+func (s *Server) buildIndex() error {
+	if s.index == nil {
+		return nil
+	}
+	if err := s.index.Rebuild(s.fs); err != nil {
+		if s.RequireIndex {
+			return fmt.Errorf("failed to build the index: %v", err)
+		}
+		log.Printf("[WARN] failed to build the index; serving without the index: %v", err)
+		s.index = nil
+	}
+	return nil
+}
+
+// save writes the entries to persistPath. The caller must hold the lock.
+func (idx *Index) save() error {
+	if idx.persistPath == "" {
+		return nil
+	}
+	b, err := json.Marshal(idx.entries)
+	if err != nil {
+		return err
+	}
+	tmp := idx.persistPath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, idx.persistPath)
+}
+
+func (idx *Index) load() (map[string]FileMetadata, error) {
+	if idx.persistPath == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(idx.persistPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	entries := map[string]FileMetadata{}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func indexKey(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
+
+// computeMetadata reads the file at `p` and returns its metadata.
+func computeMetadata(fs afero.Fs, p string) (FileMetadata, error) {
+	f, err := fs.Open(p)
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return FileMetadata{}, err
+	}
+	h := sha256.New()
+	h.Write(head[:n])
+	if _, err := io.Copy(h, f); err != nil {
+		return FileMetadata{}, err
+	}
+	// same as http.ServeContent: the extension takes precedence over the content
+	contentType := mime.TypeByExtension(filepath.Ext(p))
+	if contentType == "" {
+		contentType = http.DetectContentType(head[:n])
+	}
+	return FileMetadata{
+		Path:        indexKey(p),
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+		ContentType: contentType,
+		SHA256:      fmt.Sprintf("%x", h.Sum(nil)),
+	}, nil
+}
@@ -0,0 +1,377 @@
+package simpleuploadserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestIndex_UploadAndRemove(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "existing.txt"), []byte("lorem ipsum"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 1024,
+		EnableIndex:   true,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot), index: NewIndex("")}
+	if err := server.index.Rebuild(server.fs); err != nil {
+		t.Fatalf("Rebuild() error = %v", err)
+	}
+	if _, ok := server.index.Get("/existing.txt"); !ok {
+		t.Errorf("existing file is not indexed on rebuild")
+	}
+
+	content := []byte("<html><body>hello</body></html>")
+	req, err := makeFormRequest(&url.URL{Path: "/files/sub/hello.html"}, http.MethodPut, "hello.html", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.handle(server.handlePut).ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+	}
+
+	m, ok := server.index.Get("sub/hello.html")
+	if !ok {
+		t.Fatalf("uploaded file is not indexed")
+	}
+	want := FileMetadata{
+		Path:        "/sub/hello.html",
+		Size:        int64(len(content)),
+		ModTime:     m.ModTime,
+		ContentType: "text/html; charset=utf-8",
+		SHA256:      fmt.Sprintf("%x", sha256.Sum256(content)),
+	}
+	if m != want {
+		t.Errorf("metadata = %+v, want = %+v", m, want)
+	}
+	if n := len(server.index.List()); n != 2 {
+		t.Errorf("len(List()) = %d, want = 2", n)
+	}
+
+	if err := server.index.Remove(server.fs, "/sub/hello.html"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, ok := server.index.Get("/sub/hello.html"); ok {
+		t.Errorf("removed file is still indexed")
+	}
+}
+
+func TestIndex_Persist(t *testing.T) {
+	persistPath := filepath.Join(t.TempDir(), "index.json")
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "/foo.txt", []byte("hello, world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx := NewIndex(persistPath)
+	if err := idx.Rebuild(fs); err != nil {
+		t.Fatalf("Rebuild() error = %v", err)
+	}
+	orig, _ := idx.Get("/foo.txt")
+
+	// the persisted checksum is reused if the file is not changed
+	reloaded := NewIndex(persistPath)
+	if err := reloaded.Rebuild(fs); err != nil {
+		t.Fatalf("Rebuild() error = %v", err)
+	}
+	got, ok := reloaded.Get("/foo.txt")
+	if !ok || got.SHA256 != orig.SHA256 {
+		t.Errorf("reloaded metadata = %+v, want = %+v", got, orig)
+	}
+}
+
+func TestIndex_Listing(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	for name, content := range map[string]string{"foo.txt": "hello", "sub/bar.txt": "lorem ipsum"} {
+		if err := afero.WriteFile(fs, path.Join(docRoot, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := ServerConfig{
+		DocumentRoot:           docRoot,
+		EnableIndex:            true,
+		EnableDirectoryListing: true,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot), index: NewIndex("")}
+	if err := server.index.Rebuild(server.fs); err != nil {
+		t.Fatalf("Rebuild() error = %v", err)
+	}
+	sub, err := server.fs.Stat("/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a file written behind the server is not indexed, so it is not seen while the index is used
+	if err := afero.WriteFile(fs, path.Join(docRoot, "sub/unindexed.txt"), []byte("unindexed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("listing", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/files/", nil)
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		server.handle(server.handleGet).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+		}
+		var entries []DirectoryEntry
+		if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil {
+			t.Fatalf("failed to decode response body: %v", err)
+		}
+		foo, _ := server.index.Get("/foo.txt")
+		want := []DirectoryEntry{
+			{Name: "sub", Path: "/files/sub", IsDir: true, ModTime: sub.ModTime()},
+			{Name: "foo.txt", Path: "/files/foo.txt", Size: 5, ModTime: foo.ModTime},
+		}
+		if len(entries) != len(want) {
+			t.Fatalf("entries = %+v, want = %+v", entries, want)
+		}
+		for i := range want {
+			if !entries[i].ModTime.Equal(want[i].ModTime) {
+				t.Errorf("entries[%d].ModTime = %v, want = %v", i, entries[i].ModTime, want[i].ModTime)
+			}
+			entries[i].ModTime, want[i].ModTime = time.Time{}, time.Time{}
+			if entries[i] != want[i] {
+				t.Errorf("entries[%d] = %+v, want = %+v", i, entries[i], want[i])
+			}
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/files/sub", nil)
+		req.Header.Set("Accept", "application/json")
+		rr = httptest.NewRecorder()
+		server.handle(server.handleGet).ServeHTTP(rr, req)
+		entries = nil
+		if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil {
+			t.Fatalf("failed to decode response body: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Name != "bar.txt" {
+			t.Errorf("entries of /sub = %+v, want only bar.txt", entries)
+		}
+	})
+
+	t.Run("propfind", func(t *testing.T) {
+		req := httptest.NewRequest(MethodPropfind, "/files/sub/", nil)
+		rr := httptest.NewRecorder()
+		server.handle(server.handlePropfind).ServeHTTP(rr, req)
+		if rr.Code != http.StatusMultiStatus {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusMultiStatus)
+		}
+		body := rr.Body.String()
+		if !strings.Contains(body, "<D:href>/files/sub/bar.txt</D:href>") {
+			t.Errorf("body = %s, want bar.txt", body)
+		}
+		if strings.Contains(body, "unindexed.txt") {
+			t.Errorf("body = %s, should not have the unindexed file", body)
+		}
+	})
+
+	t.Run("dirsize", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/files/?size=true", nil)
+		rr := httptest.NewRecorder()
+		server.handle(server.handleGet).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+		}
+		var result DirectorySizeResult
+		if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response body: %v", err)
+		}
+		if want := (DirectorySizeResult{OK: true, Size: 16, FileCount: 2}); result != want {
+			t.Errorf("result = %+v, want = %+v", result, want)
+		}
+	})
+}
+
+func TestIndex_EmptyDirectory(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := fs.MkdirAll("/empty", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, "/sub/foo.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx := NewIndex("")
+	if err := idx.Rebuild(fs); err != nil {
+		t.Fatalf("Rebuild() error = %v", err)
+	}
+	names := func(infos []os.FileInfo) []string {
+		var names []string
+		for _, fi := range infos {
+			names = append(names, fi.Name())
+		}
+		return names
+	}
+	walked, err := afero.ReadDir(fs, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(idx.ReadDir("/")), names(walked); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadDir() = %v, want = %v", got, want)
+	}
+
+	// the directory is kept after its last file is removed
+	if err := fs.Remove("/sub/foo.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Remove(fs, "/sub/foo.txt"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if got, want := names(idx.ReadDir("/")), []string{"empty", "sub"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadDir() after removal = %v, want = %v", got, want)
+	}
+}
+
+func TestIndex_DelayedSave(t *testing.T) {
+	persistPath := filepath.Join(t.TempDir(), "index.json")
+	fs := afero.NewMemMapFs()
+	idx := NewIndex(persistPath)
+	if err := idx.Rebuild(fs); err != nil {
+		t.Fatalf("Rebuild() error = %v", err)
+	}
+	orig := IndexSaveDelay
+	IndexSaveDelay = time.Hour
+	defer func() { IndexSaveDelay = orig }()
+
+	for _, name := range []string{"/foo.txt", "/bar.txt"} {
+		if err := afero.WriteFile(fs, name, []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.Update(fs, name); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+	persisted, err := idx.load()
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if len(persisted) != 0 {
+		t.Errorf("changes are saved before the delay: %v", persisted)
+	}
+
+	if err := idx.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	persisted, err = idx.load()
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if len(persisted) != 2 {
+		t.Errorf("persisted entries = %v, want 2 entries", persisted)
+	}
+}
+
+// unreadableFs fails to open the file at `name`.
+type unreadableFs struct {
+	afero.Fs
+	name string
+}
+
+func (fs unreadableFs) Open(name string) (afero.File, error) {
+	if name == fs.name {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("input/output error")}
+	}
+	return fs.Fs.Open(name)
+}
+
+func TestIndex_Fallback(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	for _, name := range []string{"hello.txt", "broken.txt"} {
+		if err := afero.WriteFile(fs, path.Join(docRoot, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	baseFs := afero.NewBasePathFs(fs, docRoot)
+	brokenFs := unreadableFs{baseFs, "/broken.txt"}
+
+	t.Run("fallback", func(t *testing.T) {
+		config := ServerConfig{
+			DocumentRoot:           docRoot,
+			EnableIndex:            true,
+			EnableDirectoryListing: true,
+		}
+		server := Server{ServerConfig: config, fs: baseFs, index: NewIndex("")}
+		listNames := func() []string {
+			t.Helper()
+			req := httptest.NewRequest(http.MethodGet, "/files/", nil)
+			req.Header.Set("Accept", "application/json")
+			rr := httptest.NewRecorder()
+			server.handle(server.handleGet).ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+			}
+			var entries []DirectoryEntry
+			if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name)
+			}
+			return names
+		}
+
+		if err := server.buildIndex(); err != nil {
+			t.Fatalf("buildIndex() error = %v", err)
+		}
+		if server.index == nil {
+			t.Fatalf("index should be enabled")
+		}
+		// written behind the server, so only the walk can find it
+		if err := afero.WriteFile(fs, path.Join(docRoot, "unindexed.txt"), []byte("unindexed"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if names, want := listNames(), []string{"broken.txt", "hello.txt"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("entries with the index = %v, want = %v", names, want)
+		}
+
+		server.fs = brokenFs
+		if err := server.buildIndex(); err != nil {
+			t.Fatalf("buildIndex() error = %v", err)
+		}
+		if server.index != nil {
+			t.Fatalf("index should be disabled after the failure")
+		}
+		if names, want := listNames(), []string{"broken.txt", "hello.txt", "unindexed.txt"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("entries after the fallback = %v, want = %v", names, want)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/files/hello.txt?meta=true", nil)
+		rr := httptest.NewRecorder()
+		server.handle(server.handleGet).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("metadata status = %d, want = %d", rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("required", func(t *testing.T) {
+		config := ServerConfig{
+			DocumentRoot: docRoot,
+			EnableIndex:  true,
+			RequireIndex: true,
+		}
+		server := Server{ServerConfig: config, fs: brokenFs, index: NewIndex("")}
+		if err := server.buildIndex(); err == nil {
+			t.Errorf("buildIndex() should fail if RequireIndex is set")
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package simpleuploadserver
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// JobRetention is how long finished jobs are kept to be queried by GET /jobs/:id.
+var JobRetention = time.Hour
+
+type JobStatus string
+
+const (
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is the post-processing of an uploaded file running in background.
+type Job struct {
+	ID     string    `json:"id"`
+	Path   string    `json:"path"`
+	Status JobStatus `json:"status"`
+	Error  string    `json:"error,omitempty"`
+
+	finishedAt time.Time
+}
+
+type UploadAcceptedResult struct {
+	OK   bool   `json:"ok"`
+	Path string `json:"path"`
+	Job  string `json:"job"`
+}
+
+type JobResult struct {
+	OK bool `json:"ok"`
+	Job
+}
+
+// jobQueue runs jobs and keeps their status. It is safe for concurrent use.
+type jobQueue struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{jobs: map[string]*Job{}}
+}
+
+// Enqueue starts `f` in background as a job for the file at `path`.
+func (q *jobQueue) Enqueue(path string, f func() error) Job {
+	job := &Job{ID: uuid.NewString(), Path: path, Status: JobRunning}
+	q.mu.Lock()
+	q.expire()
+	q.jobs[job.ID] = job
+	q.mu.Unlock()
+
+	go func() {
+		err := f()
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if err != nil {
+			log.Printf("job %s failed (path=%s): %v", job.ID, job.Path, err)
+			job.Status = JobFailed
+			job.Error = err.Error()
+		} else {
+			job.Status = JobSucceeded
+		}
+		job.finishedAt = time.Now()
+	}()
+	return *job
+}
+
+// Get returns a copy of the job of `id`.
+func (q *jobQueue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// expire removes jobs finished before JobRetention. q.mu must be held.
+func (q *jobQueue) expire() {
+	for id, job := range q.jobs {
+		if job.Status != JobRunning && time.Since(job.finishedAt) > JobRetention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// postProcess runs PostProcess for the file at `path` if configured.
+func (s *Server) postProcess(path string) error {
+	if s.PostProcess == nil {
+		return nil
+	}
+	return s.PostProcess(s.fs, path)
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) (int, any) {
+	job, ok := s.jobs.Get(mux.Vars(r)["id"])
+	if !ok {
+		return http.StatusNotFound, fmt.Errorf("job not found")
+	}
+	return http.StatusOK, JobResult{true, job}
+}
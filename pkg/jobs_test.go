@@ -0,0 +1,120 @@
+package simpleuploadserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_AsyncProcessing(t *testing.T) {
+	docRoot := "/opt/app"
+	release := make(chan struct{})
+	config := ServerConfig{
+		DocumentRoot:          docRoot,
+		MaxUploadSize:         16,
+		EnableAsyncProcessing: true,
+		PostProcess: func(fs afero.Fs, path string) error {
+			<-release
+			if strings.HasSuffix(path, ".bad") {
+				return fmt.Errorf("unsupported file")
+			}
+			return nil
+		},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot), jobs: newJobQueue()}
+	router := server.router()
+
+	upload := func(t *testing.T, name string) UploadAcceptedResult {
+		req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, name, strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusAccepted)
+		}
+		var result UploadAcceptedResult
+		if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		if loc, want := rr.Header().Get("Location"), "/jobs/"+result.Job; !strings.HasSuffix(loc, want) {
+			t.Errorf("Location = %s, want suffix = %s", loc, want)
+		}
+		return result
+	}
+	getJob := func(t *testing.T, id string) (int, JobResult) {
+		req := httptest.NewRequest(http.MethodGet, "/jobs/"+id, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var result JobResult
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr.Code, result
+	}
+	waitJob := func(t *testing.T, id string) JobResult {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, result := getJob(t, id); result.Status != JobRunning {
+				return result
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("job %s did not finish", id)
+		return JobResult{}
+	}
+
+	ok := upload(t, "ok.txt")
+	if ok.Path != "/files/ok.txt" {
+		t.Errorf("path = %s, want = /files/ok.txt", ok.Path)
+	}
+	bad := upload(t, "file.bad")
+	if status, result := getJob(t, ok.Job); status != http.StatusOK || result.Status != JobRunning {
+		t.Errorf("GET /jobs/%s = %d %+v, want running", ok.Job, status, result)
+	}
+
+	close(release)
+	if result := waitJob(t, ok.Job); result.Status != JobSucceeded || result.Path != "/files/ok.txt" {
+		t.Errorf("job = %+v, want succeeded", result)
+	}
+	if result := waitJob(t, bad.Job); result.Status != JobFailed || result.Error != "unsupported file" {
+		t.Errorf("job = %+v, want failed", result)
+	}
+	if status, _ := getJob(t, "unknown"); status != http.StatusNotFound {
+		t.Errorf("GET /jobs/unknown = %d, want = %d", status, http.StatusNotFound)
+	}
+}
+
+func TestServer_PostProcess(t *testing.T) {
+	docRoot := "/opt/app"
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+		PostProcess: func(fs afero.Fs, path string) error {
+			return fmt.Errorf("rejected")
+		},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+	req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "test.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.handle(server.handlePost).ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want = %d", rr.Code, http.StatusInternalServerError)
+	}
+	if body, want := rr.Body.String(), `{"ok":false,"error":"failed to process the uploaded file"}`; body != want {
+		t.Errorf("body = %s, want = %s", body, want)
+	}
+}
@@ -0,0 +1,322 @@
+package simpleuploadserver
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// SinceQueryKey is the query parameter to filter directory entries by their modification time.
+var SinceQueryKey = "since"
+
+// parseSinceQuery parses the `since` query parameter as RFC 3339 timestamp.
+// It returns zero time if the parameter is not given.
+func parseSinceQuery(q url.Values) (time.Time, error) {
+	v := q.Get(SinceQueryKey)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s parameter: %w", SinceQueryKey, err)
+	}
+	return t, nil
+}
+
+// filterModifiedSince returns the entries modified after `since`.
+// All entries are returned if `since` is zero.
+func filterModifiedSince(entries []os.FileInfo, since time.Time) []os.FileInfo {
+	if since.IsZero() {
+		return entries
+	}
+	filtered := make([]os.FileInfo, 0, len(entries))
+	for _, fi := range entries {
+		if fi.ModTime().After(since) {
+			filtered = append(filtered, fi)
+		}
+	}
+	return filtered
+}
+
+var (
+	// SortQueryKey is the query parameter to sort directory entries by `name`, `size` or `date`.
+	SortQueryKey = "sort"
+	// OrderQueryKey is the query parameter to sort directory entries in `asc` or `desc` order.
+	OrderQueryKey = "order"
+)
+
+// DirectoryEntry is an entry of the directory listing.
+type DirectoryEntry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	IsDir   bool      `json:"is_dir"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// serveDirectory responds with the entries of the directory at `requestPath`.
+// It is HTML if the request prefers text/html to application/json, and JSON otherwise.
+func (s *Server) serveDirectory(w http.ResponseWriter, r *http.Request, requestPath string) (int, any) {
+	infos, err := s.readDirectory(requestPath)
+	if err != nil {
+		log.Printf("failed to read the directory (path=%s): %v", requestPath, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to read the directory")
+	}
+	since, err := parseSinceQuery(r.URL.Query())
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	infos = filterModifiedSince(infos, since)
+	sortKey := r.URL.Query().Get(SortQueryKey)
+	desc := r.URL.Query().Get(OrderQueryKey) == "desc"
+	entries := make([]DirectoryEntry, 0, len(infos))
+	for _, fi := range infos {
+		entry := DirectoryEntry{
+			Name:    fi.Name(),
+			Path:    filesURLPath(path.Join(canonicalPath(requestPath), fi.Name())),
+			IsDir:   fi.IsDir(),
+			ModTime: fi.ModTime(),
+		}
+		if !fi.IsDir() {
+			entry.Size = fi.Size()
+		}
+		entries = append(entries, entry)
+	}
+	sortDirectoryEntries(entries, sortKey, desc)
+
+	if !prefersHTML(r) {
+		return http.StatusOK, entries
+	}
+	var buf bytes.Buffer
+	if err := directoryTemplate.Execute(&buf, newDirectoryPage(canonicalPath(requestPath), entries, sortKey, desc)); err != nil {
+		log.Printf("failed to render the directory listing (path=%s): %v", requestPath, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to render the directory listing")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			log.Printf("failed to write response: %v", err)
+		}
+	}
+	return 0, nil
+}
+
+// readDirectory returns the entries of the directory at `dir`, from the index or listingCache if it is enabled.
+// The reserved paths are excluded.
+func (s *Server) readDirectory(dir string) ([]os.FileInfo, error) {
+	if s.index != nil {
+		return s.index.ReadDir(dir), nil
+	}
+	if s.listingCache == nil {
+		return s.readDirectoryEntries(dir)
+	}
+	fi, err := s.fs.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	key := canonicalPath(dir)
+	if infos, ok := s.listingCache.Get(key, fi.ModTime()); ok {
+		return infos, nil
+	}
+	infos, err := s.readDirectoryEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+	s.listingCache.Put(key, fi.ModTime(), infos)
+	return infos, nil
+}
+
+// readDirectoryEntries reads the entries of the directory at `dir` except the reserved paths.
+func (s *Server) readDirectoryEntries(dir string) ([]os.FileInfo, error) {
+	infos, err := afero.ReadDir(s.fs, dir)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(infos, func(fi os.FileInfo) bool {
+		return isReservedPath(path.Join(canonicalPath(dir), fi.Name()))
+	}), nil
+}
+
+// listingCache keeps the entries of directories for a short time. It is safe for concurrent use.
+// An entry is used only while the modification time of the directory is unchanged, and the server invalidates
+// the entries on its own writes since some file systems do not update the modification time of directories.
+type listingCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]listingCacheEntry
+}
+
+type listingCacheEntry struct {
+	modTime   time.Time
+	expiresAt time.Time
+	infos     []os.FileInfo
+}
+
+func newListingCache(ttl time.Duration) *listingCache {
+	return &listingCache{ttl: ttl, entries: map[string]listingCacheEntry{}}
+}
+
+// Get returns the cached entries of the directory at the canonical path `dir` modified at `modTime`.
+func (c *listingCache) Get(dir string, modTime time.Time) ([]os.FileInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[dir]
+	if !ok || !entry.modTime.Equal(modTime) || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.infos, true
+}
+
+func (c *listingCache) Put(dir string, modTime time.Time, infos []os.FileInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[dir] = listingCacheEntry{modTime, time.Now().Add(c.ttl), infos}
+}
+
+// Invalidate drops the entries of `p` and all of its parents, which may have got a new subdirectory.
+func (c *listingCache) Invalidate(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for dir := canonicalPath(p); ; dir = path.Dir(dir) {
+		delete(c.entries, dir)
+		if dir == "/" {
+			break
+		}
+	}
+}
+
+// sortDirectoryEntries sorts `entries` by `key` with the directories first. The name is used if `key` is unknown.
+func sortDirectoryEntries(entries []DirectoryEntry, key string, desc bool) {
+	slices.SortStableFunc(entries, func(a, b DirectoryEntry) int {
+		if a.IsDir != b.IsDir {
+			if a.IsDir {
+				return -1
+			}
+			return 1
+		}
+		var c int
+		switch key {
+		case "size":
+			c = cmp.Compare(a.Size, b.Size)
+		case "date":
+			c = a.ModTime.Compare(b.ModTime)
+		}
+		if c == 0 {
+			c = strings.Compare(a.Name, b.Name)
+		}
+		if desc {
+			return -c
+		}
+		return c
+	})
+}
+
+// prefersHTML reports whether text/html comes before application/json in Accept header of `r`.
+// Quality values are not taken into account.
+func prefersHTML(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(v, ",") {
+			mediaType, _, _ := strings.Cut(mediaRange, ";")
+			switch strings.TrimSpace(strings.ToLower(mediaType)) {
+			case "text/html":
+				return true
+			case "application/json":
+				return false
+			}
+		}
+	}
+	return false
+}
+
+type directoryPage struct {
+	Path        string
+	Breadcrumbs []directoryLink
+	Parent      string
+	Columns     []directoryLink
+	Entries     []directoryPageEntry
+}
+
+type directoryLink struct {
+	Name string
+	Href string
+}
+
+type directoryPageEntry struct {
+	DirectoryEntry
+	Href string
+}
+
+// newDirectoryPage builds the data of directoryTemplate for the directory at the canonical path `dir`.
+func newDirectoryPage(dir string, entries []DirectoryEntry, sortKey string, desc bool) directoryPage {
+	page := directoryPage{Path: dir}
+	page.Breadcrumbs = append(page.Breadcrumbs, directoryLink{"files", "/files/"})
+	if dir != "/" {
+		segments := strings.Split(strings.TrimPrefix(dir, "/"), "/")
+		for i, seg := range segments {
+			page.Breadcrumbs = append(page.Breadcrumbs, directoryLink{seg, escapeURLPath(filesURLPath("/"+path.Join(segments[:i+1]...)) + "/")})
+		}
+		page.Parent = "/files/"
+		if parent := path.Dir(dir); parent != "/" {
+			page.Parent = escapeURLPath(filesURLPath(parent)) + "/"
+		}
+	}
+	for _, col := range []struct{ key, name string }{{"name", "Name"}, {"size", "Size"}, {"date", "Last Modified"}} {
+		order := "asc"
+		if col.key == sortKey && !desc {
+			order = "desc"
+		}
+		q := url.Values{SortQueryKey: {col.key}, OrderQueryKey: {order}}
+		page.Columns = append(page.Columns, directoryLink{col.name, "?" + q.Encode()})
+	}
+	for _, entry := range entries {
+		href := escapeURLPath(entry.Path)
+		if entry.IsDir {
+			href += "/"
+		}
+		page.Entries = append(page.Entries, directoryPageEntry{entry, href})
+	}
+	return page
+}
+
+// escapeURLPath escapes `p` to be used as the path of URL.
+func escapeURLPath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+var directoryTemplate = template.Must(template.New("directory").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of {{.Path}}</title>
+</head>
+<body>
+<nav class="breadcrumbs">{{range $i, $b := .Breadcrumbs}}{{if $i}} / {{end}}<a href="{{$b.Href}}">{{$b.Name}}</a>{{end}}</nav>
+<table>
+<thead>
+<tr>{{range .Columns}}<th><a href="{{.Href}}">{{.Name}}</a></th>{{end}}</tr>
+</thead>
+<tbody>
+{{- if .Parent}}
+<tr><td><a href="{{.Parent}}">../</a></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr><td><a href="{{.Href}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td>{{if not .IsDir}}{{.Size}}{{end}}</td><td>{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+</body>
+</html>
+`))
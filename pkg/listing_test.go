@@ -0,0 +1,412 @@
+package simpleuploadserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func Test_filterModifiedSince(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := map[string]time.Time{
+		"old.txt":    base.Add(-time.Hour),
+		"same.txt":   base,
+		"newer.txt":  base.Add(time.Minute),
+		"newest.txt": base.Add(time.Hour),
+	}
+	for name, mtime := range files {
+		p := path.Join(docRoot, name)
+		if err := afero.WriteFile(fs, p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := afero.ReadDir(fs, docRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"no filter", "", []string{"newer.txt", "newest.txt", "old.txt", "same.txt"}},
+		{"since base", base.Format(time.RFC3339), []string{"newer.txt", "newest.txt"}},
+		{"since future", base.Add(2 * time.Hour).Format(time.RFC3339), []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			since, err := parseSinceQuery(url.Values{SinceQueryKey: []string{tt.query}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, fi := range filterModifiedSince(entries, since) {
+				got = append(got, fi.Name())
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("filterModifiedSince() = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("invalid since", func(t *testing.T) {
+		if _, err := parseSinceQuery(url.Values{SinceQueryKey: []string{"yesterday"}}); err == nil {
+			t.Errorf("parseSinceQuery() error = nil, want error")
+		}
+	})
+}
+
+func newListingTestServer(t *testing.T) *Server {
+	t.Helper()
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := []struct {
+		name  string
+		size  int
+		mtime time.Time
+	}{
+		{"dir/b.txt", 3, base.Add(time.Hour)},
+		{"dir/a b.txt", 10, base},
+		{"dir/sub/c.txt", 1, base},
+	}
+	for _, f := range files {
+		p := path.Join(docRoot, f.name)
+		if err := afero.WriteFile(fs, p, []byte(strings.Repeat("x", f.size)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.Chtimes(p, f.mtime, f.mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := ServerConfig{
+		DocumentRoot:           docRoot,
+		MaxUploadSize:          16,
+		EnableDirectoryListing: true,
+	}
+	return &Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+}
+
+func TestServer_DirectoryListingJSON(t *testing.T) {
+	server := newListingTestServer(t)
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"default", "", []string{"sub", "a b.txt", "b.txt"}},
+		{"by size", "?sort=size", []string{"sub", "b.txt", "a b.txt"}},
+		{"by date descending", "?sort=date&order=desc", []string{"sub", "b.txt", "a b.txt"}},
+		{"by name descending", "?sort=name&order=desc", []string{"sub", "b.txt", "a b.txt"}},
+		{"modified since", "?since=2024-01-01T00:30:00Z", []string{"sub", "b.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/files/dir"+tt.query, nil)
+			req.Header.Set("Accept", "application/json")
+			rr := httptest.NewRecorder()
+			server.handle(server.handleGet).ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %s, want = application/json", ct)
+			}
+			var entries []DirectoryEntry
+			if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("names = %v, want = %v", names, tt.want)
+			}
+		})
+	}
+
+	t.Run("invalid since", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/files/dir?since=yesterday", nil)
+		rr := httptest.NewRecorder()
+		server.handle(server.handleGet).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("entries", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/files/dir", nil)
+		rr := httptest.NewRecorder()
+		server.handle(server.handleGet).ServeHTTP(rr, req)
+		var entries []DirectoryEntry
+		if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil {
+			t.Fatalf("failed to decode response body: %v", err)
+		}
+		want := []DirectoryEntry{
+			{Name: "sub", Path: "/files/dir/sub", IsDir: true},
+			{Name: "a b.txt", Path: "/files/dir/a b.txt", Size: 10},
+			{Name: "b.txt", Path: "/files/dir/b.txt", Size: 3},
+		}
+		if len(entries) != len(want) {
+			t.Fatalf("entries = %+v, want = %+v", entries, want)
+		}
+		for i := range want {
+			got := entries[i]
+			got.ModTime = time.Time{}
+			if got != want[i] {
+				t.Errorf("entries[%d] = %+v, want = %+v", i, got, want[i])
+			}
+		}
+	})
+}
+
+func TestServer_DirectoryListingFormat(t *testing.T) {
+	server := newListingTestServer(t)
+	// the listing is JSON unless HTML is preferred, including requests of curl sending `*/*`
+	for _, accept := range []string{"", "*/*", "application/json"} {
+		t.Run("Accept: "+accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/files/dir", nil)
+			if accept != "" {
+				req.Header.Set("Accept", accept)
+			}
+			rr := httptest.NewRecorder()
+			server.handle(server.handleGet).ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %s, want = application/json", ct)
+			}
+			var entries []map[string]any
+			if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if len(entries) == 0 {
+				t.Fatalf("no entries")
+			}
+			for _, key := range []string{"name", "path", "size", "is_dir", "mod_time"} {
+				if _, ok := entries[0][key]; !ok {
+					t.Errorf("entry does not have %s: %v", key, entries[0])
+				}
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		server := newListingTestServer(t)
+		server.EnableDirectoryListing = false
+		rr := httptest.NewRecorder()
+		server.handle(server.handleGet).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/files/dir", nil))
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusNotFound)
+		}
+		var result ErrorResult
+		if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response body: %v", err)
+		}
+		if !strings.HasSuffix(result.Error, "is a directory") {
+			t.Errorf("error = %q, want to tell it is a directory", result.Error)
+		}
+	})
+}
+
+func TestServer_DirectoryListingHTML(t *testing.T) {
+	server := newListingTestServer(t)
+	req := httptest.NewRequest(http.MethodGet, "/files/dir/sub/?sort=name", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	rr := httptest.NewRecorder()
+	server.handle(server.handleGet).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %s, want = text/html; charset=utf-8", ct)
+	}
+	body := rr.Body.String()
+	for _, want := range []string{
+		`<title>Index of /dir/sub</title>`,
+		`<nav class="breadcrumbs"><a href="/files/">files</a> / <a href="/files/dir/">dir</a> / <a href="/files/dir/sub/">sub</a></nav>`,
+		`<a href="/files/dir/">../</a>`,
+		`<a href="?order=desc&amp;sort=name">Name</a>`,
+		`<a href="?order=asc&amp;sort=size">Size</a>`,
+		`<a href="/files/dir/sub/c.txt">c.txt</a></td><td>1</td><td>2024-01-01 00:00:00</td>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body does not contain %s\n%s", want, body)
+		}
+	}
+
+	t.Run("escaped links", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/files/dir", nil)
+		req.Header.Set("Accept", "text/html")
+		rr := httptest.NewRecorder()
+		server.handle(server.handleGet).ServeHTTP(rr, req)
+		body := rr.Body.String()
+		for _, want := range []string{
+			`<a href="/files/dir/a%20b.txt">a b.txt</a>`,
+			`<a href="/files/dir/sub/">sub/</a>`,
+			`<a href="/files/">../</a>`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("body does not contain %s\n%s", want, body)
+			}
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		server := newListingTestServer(t)
+		server.EnableDirectoryListing = false
+		req := httptest.NewRequest(http.MethodGet, "/files/dir", nil)
+		rr := httptest.NewRecorder()
+		server.handle(server.handleGet).ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestServer_DocumentRootListing(t *testing.T) {
+	for _, target := range []string{"/files/", "/files"} {
+		t.Run(target, func(t *testing.T) {
+			server := newListingTestServer(t)
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			rr := httptest.NewRecorder()
+			server.router().ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+			}
+			var entries []DirectoryEntry
+			if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if len(entries) != 1 || entries[0].Path != "/files/dir" || !entries[0].IsDir {
+				t.Errorf("entries = %+v, want only /files/dir", entries)
+			}
+		})
+	}
+
+	t.Run("HTML", func(t *testing.T) {
+		server := newListingTestServer(t)
+		req := httptest.NewRequest(http.MethodGet, "/files/", nil)
+		req.Header.Set("Accept", "text/html")
+		rr := httptest.NewRecorder()
+		server.router().ServeHTTP(rr, req)
+		body := rr.Body.String()
+		for _, want := range []string{`<title>Index of /</title>`, `<a href="/files/dir/">dir/</a>`} {
+			if !strings.Contains(body, want) {
+				t.Errorf("body does not contain %s\n%s", want, body)
+			}
+		}
+		if strings.Contains(body, "../") {
+			t.Errorf("body should not have the link to the parent\n%s", body)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		server := newListingTestServer(t)
+		server.EnableDirectoryListing = false
+		req := httptest.NewRequest(http.MethodGet, "/files/", nil)
+		rr := httptest.NewRecorder()
+		server.router().ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusNotFound)
+		}
+		if body, want := rr.Body.String(), `{"ok":false,"error":"no file is specified and directory listing is disabled"}`; body != want {
+			t.Errorf("body = %s, want = %s", body, want)
+		}
+	})
+}
+
+// countingFs counts opening the file at the canonical path `name`.
+type countingFs struct {
+	afero.Fs
+	name  string
+	count *int
+}
+
+func (fs countingFs) Open(name string) (afero.File, error) {
+	if canonicalPath(name) == fs.name {
+		*fs.count++
+	}
+	return fs.Fs.Open(name)
+}
+
+func TestServer_ListingCache(t *testing.T) {
+	docRoot := "/opt/app"
+	memFs := afero.NewMemMapFs()
+	if err := afero.WriteFile(memFs, path.Join(docRoot, "sub", "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var reads int
+	config := ServerConfig{
+		DocumentRoot:           docRoot,
+		MaxUploadSize:          16,
+		EnableDirectoryListing: true,
+		ListingCacheTTL:        60000,
+	}
+	server := Server{
+		ServerConfig: config,
+		fs:           countingFs{afero.NewBasePathFs(memFs, docRoot), "/sub", &reads},
+		listingCache: newListingCache(time.Minute),
+	}
+	handler := server.router()
+	list := func() []string {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/files/sub/", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+		}
+		var entries []DirectoryEntry
+		if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil {
+			t.Fatalf("failed to decode response body: %v", err)
+		}
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		return names
+	}
+
+	if got := list(); !slices.Equal(got, []string{"hello.txt"}) {
+		t.Errorf("entries = %v, want = [hello.txt]", got)
+	}
+	list()
+	if reads != 1 {
+		t.Errorf("directory is read %d times, want = 1", reads)
+	}
+
+	// an upload invalidates the cache of the directory
+	req, err := makeFormRequest(&url.URL{Path: "/files/sub/new.txt"}, http.MethodPut, "new.txt", strings.NewReader("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("upload status = %d, want = %d", rr.Code, http.StatusCreated)
+	}
+	reads = 0
+	if got := list(); !slices.Equal(got, []string{"hello.txt", "new.txt"}) {
+		t.Errorf("entries = %v, want = [hello.txt new.txt]", got)
+	}
+	if reads != 1 {
+		t.Errorf("directory is read %d times after the upload, want = 1", reads)
+	}
+}
@@ -0,0 +1,30 @@
+package simpleuploadserver
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// LoadSheddingRetryAfter is the duration which is suggested to clients by Retry-After header when uploads are shed.
+var LoadSheddingRetryAfter = 5 * time.Second
+
+// loadSheddingMiddleware rejects uploads with 503 while MaxInFlightUploads uploads are already being processed.
+func (s *Server) loadSheddingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut:
+			if r.URL.Path == "/maintenance" {
+				break
+			}
+			if s.inFlight.Add(1) > int64(s.MaxInFlightUploads) {
+				s.inFlight.Add(-1)
+				w.Header().Set("Retry-After", strconv.Itoa(int(LoadSheddingRetryAfter.Seconds())))
+				writeError(w, r, http.StatusServiceUnavailable, "the server is busy")
+				return
+			}
+			defer s.inFlight.Add(-1)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
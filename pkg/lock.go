@@ -0,0 +1,53 @@
+package simpleuploadserver
+
+import (
+	"strings"
+	"sync"
+)
+
+// keyedMutex is a set of mutexes identified by the keys. The zero value is ready to use.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	// number of goroutines holding or waiting for the lock
+	refs int
+}
+
+// Lock locks the mutex of `key` and returns the function to unlock it.
+// The mutex is discarded when no one holds or waits for it.
+func (k *keyedMutex) Lock(key string) (unlock func()) {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*keyedLock{}
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+	}
+}
+
+// lockPath locks the file at `path` against the other uploads to the same path.
+func (s *Server) lockPath(path string) (unlock func()) {
+	if s.CaseInsensitiveNames {
+		path = strings.ToLower(path)
+	}
+	return s.pathLocks.Lock(path)
+}
@@ -0,0 +1,74 @@
+package simpleuploadserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MaintenanceRetryAfter is the duration which is suggested to clients by Retry-After header in maintenance mode.
+var MaintenanceRetryAfter = 60 * time.Second
+
+type MaintenanceResult struct {
+	OK          bool `json:"ok"`
+	Maintenance bool `json:"maintenance"`
+}
+
+// InMaintenance reports whether the server is in maintenance mode.
+func (s *Server) InMaintenance() bool {
+	s.maintenanceMu.RLock()
+	defer s.maintenanceMu.RUnlock()
+	return s.maintenance
+}
+
+// SetMaintenance turns maintenance mode on or off.
+func (s *Server) SetMaintenance(enabled bool) {
+	s.maintenanceMu.Lock()
+	defer s.maintenanceMu.Unlock()
+	s.maintenance = enabled
+}
+
+// handleMaintenance toggles maintenance mode.
+// The request body can be `{"enabled": true}` or `{"enabled": false}`; the mode is flipped if the body is empty.
+// An admin token is required if authentication is enabled.
+func (s *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) (int, any) {
+	if s.EnableAuth && !isAdmin(r) {
+		return http.StatusForbidden, fmt.Errorf("admin token is required")
+	}
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid request body")
+		}
+	}
+	s.maintenanceMu.Lock()
+	if req.Enabled != nil {
+		s.maintenance = *req.Enabled
+	} else {
+		s.maintenance = !s.maintenance
+	}
+	enabled := s.maintenance
+	s.maintenanceMu.Unlock()
+	log.Printf("maintenance mode: %v", enabled)
+	return http.StatusOK, MaintenanceResult{true, enabled}
+}
+
+// maintenanceMiddleware rejects write requests with 503 while the server is in maintenance mode.
+func (s *Server) maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodDelete:
+			if r.URL.Path != "/maintenance" && s.InMaintenance() {
+				w.Header().Set("Retry-After", strconv.Itoa(int(MaintenanceRetryAfter.Seconds())))
+				writeError(w, r, http.StatusServiceUnavailable, "the server is under maintenance")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,209 @@
+package simpleuploadserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_Maintenance(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "foo.txt"), []byte("hello, world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+
+	req := httptest.NewRequest(http.MethodPost, "/maintenance", bytes.NewBufferString(`{"enabled":true}`))
+	rr := httptest.NewRecorder()
+	server.maintenanceMiddleware(server.handle(server.handleMaintenance)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("POST /maintenance: status = %d, want = %d", rr.Code, http.StatusOK)
+	}
+	if body, want := rr.Body.String(), `{"ok":true,"maintenance":true}`; body != want {
+		t.Errorf("POST /maintenance: body = %s, want = %s", body, want)
+	}
+	if !server.InMaintenance() {
+		t.Fatalf("InMaintenance() = false, want = true")
+	}
+
+	t.Run("PUT is rejected", func(t *testing.T) {
+		req, err := makeFormRequest(&url.URL{Path: "/files/bar.txt"}, http.MethodPut, "bar.txt", bytes.NewBufferString("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		server.maintenanceMiddleware(server.handle(server.handlePut)).ServeHTTP(rr, req)
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusServiceUnavailable)
+		}
+		if ra := rr.Header().Get("Retry-After"); ra == "" {
+			t.Errorf("Retry-After is empty")
+		}
+		if exists, _ := afero.Exists(fs, path.Join(docRoot, "bar.txt")); exists {
+			t.Errorf("file should not be created in maintenance mode")
+		}
+	})
+
+	t.Run("GET succeeds", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/files/foo.txt", nil)
+		rr := httptest.NewRecorder()
+		server.maintenanceMiddleware(server.handle(server.handleGet)).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("toggle off", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/maintenance", nil)
+		rr := httptest.NewRecorder()
+		server.maintenanceMiddleware(server.handle(server.handleMaintenance)).ServeHTTP(rr, req)
+		if body, want := rr.Body.String(), `{"ok":true,"maintenance":false}`; body != want {
+			t.Errorf("body = %s, want = %s", body, want)
+		}
+	})
+}
+
+func TestServer_Maintenance_RequiresAdmin(t *testing.T) {
+	config := ServerConfig{
+		DocumentRoot:    "/opt/app",
+		MaxUploadSize:   16,
+		EnableAuth:      true,
+		ReadWriteTokens: []string{"rw-token"},
+		AdminTokens:     []string{"admin-token"},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewMemMapFs()}
+	handler := server.router()
+	toggle := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/maintenance", bytes.NewBufferString(`{"enabled":true}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := toggle("rw-token")
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("read-write token: status = %d, want = %d", rr.Code, http.StatusForbidden)
+	}
+	if body, want := rr.Body.String(), `{"ok":false,"error":"admin token is required"}`; body != want {
+		t.Errorf("read-write token: body = %s, want = %s", body, want)
+	}
+	if server.InMaintenance() {
+		t.Fatalf("InMaintenance() = true, want = false")
+	}
+
+	if rr := toggle("admin-token"); rr.Code != http.StatusOK {
+		t.Errorf("admin token: status = %d, want = %d", rr.Code, http.StatusOK)
+	}
+	if !server.InMaintenance() {
+		t.Errorf("InMaintenance() = false, want = true")
+	}
+}
+
+func TestServer_LoadShedding(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:       docRoot,
+		MaxUploadSize:      16,
+		MaxInFlightUploads: 2,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	handler := server.loadSheddingMiddleware(server.handle(server.handlePost))
+	upload := func(name string) *httptest.ResponseRecorder {
+		req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, name, bytes.NewBufferString("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	server.inFlight.Store(2)
+	rr := upload("shed.txt")
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want = %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	if ra := rr.Header().Get("Retry-After"); ra == "" {
+		t.Errorf("Retry-After is empty")
+	}
+	if exists, _ := afero.Exists(fs, path.Join(docRoot, "shed.txt")); exists {
+		t.Errorf("file should not be created while the server is busy")
+	}
+	if n := server.inFlight.Load(); n != 2 {
+		t.Errorf("in-flight uploads = %d, want = 2", n)
+	}
+
+	server.inFlight.Store(1)
+	if rr := upload("accepted.txt"); rr.Code != http.StatusCreated {
+		t.Errorf("status = %d, want = %d", rr.Code, http.StatusCreated)
+	}
+	if n := server.inFlight.Load(); n != 1 {
+		t.Errorf("in-flight uploads = %d, want = 1", n)
+	}
+}
+
+func TestServer_MaxConnectionsPerIP(t *testing.T) {
+	config := ServerConfig{
+		MaxConnectionsPerIP: 2,
+		TrustedProxies:      []string{"192.0.2.1"},
+	}
+	server := Server{ServerConfig: config}
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	handler := server.connLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	}))
+	request := func(clientIP string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/files/hello.txt", nil)
+		req.RemoteAddr = "192.0.2.1:12345"
+		req.Header.Set("X-Forwarded-For", clientIP+", 192.0.2.1")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- request("203.0.113.7").Code
+		}()
+		<-entered
+	}
+
+	rr := request("203.0.113.7")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want = %d", rr.Code, http.StatusTooManyRequests)
+	}
+	if ra := rr.Header().Get("Retry-After"); ra == "" {
+		t.Errorf("Retry-After is empty")
+	}
+
+	// another client behind the same proxy is not affected
+	go func() {
+		done <- request("203.0.113.8").Code
+	}()
+	<-entered
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("status = %d, want = %d", code, http.StatusOK)
+		}
+	}
+	if n := len(server.conns.counts); n != 0 {
+		t.Errorf("%d clients are still counted after all requests finished", n)
+	}
+}
@@ -0,0 +1,137 @@
+package simpleuploadserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// MetaQueryKey is the query parameter to request the metadata of the file instead of its content.
+var MetaQueryKey = "meta"
+
+var (
+	// UserMetadataHeaderPrefix is the prefix of the request headers stored as the user metadata of the uploaded file.
+	UserMetadataHeaderPrefix = "X-Meta-"
+	// UserMetadataFileSuffix is appended to the path of the file to store its user metadata.
+	UserMetadataFileSuffix = ".meta.json"
+)
+
+type FileMetadataResult struct {
+	OK          bool              `json:"ok"`
+	Name        string            `json:"name"`
+	Size        int64             `json:"size"`
+	ModTime     time.Time         `json:"mtime"`
+	ContentType string            `json:"content_type"`
+	SHA256      string            `json:"sha256"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// serveMetadata responds the metadata of the file at `requestPath` including its SHA-256 checksum.
+func (s *Server) serveMetadata(requestPath string) (int, any) {
+	fi, err := s.fs.Stat(requestPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return http.StatusNotFound, fmt.Errorf("file not found")
+		}
+		log.Printf("failed to stat: %v", err)
+		return http.StatusInternalServerError, fmt.Errorf("stat failed")
+	}
+	if fi.IsDir() {
+		return http.StatusNotFound, fmt.Errorf("%s is a directory", requestPath)
+	}
+
+	var m FileMetadata
+	if cached, ok := s.lookupIndex(requestPath, fi); ok {
+		m = cached
+	} else {
+		m, err = computeMetadata(s.fs, requestPath)
+		if errors.Is(err, os.ErrPermission) {
+			log.Printf("permission denied (path=%s): %v", requestPath, err)
+			return http.StatusForbidden, fmt.Errorf("permission denied")
+		}
+		if err != nil {
+			log.Printf("failed to compute the metadata (path=%s): %v", requestPath, err)
+			return http.StatusInternalServerError, fmt.Errorf("failed to read file")
+		}
+	}
+	userMetadata, err := s.loadUserMetadata(requestPath)
+	if err != nil {
+		log.Printf("failed to load the user metadata (path=%s): %v", requestPath, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to read the metadata")
+	}
+	return http.StatusOK, FileMetadataResult{
+		OK:          true,
+		Name:        path.Base(m.Path),
+		Size:        m.Size,
+		ModTime:     m.ModTime,
+		ContentType: m.ContentType,
+		SHA256:      m.SHA256,
+		Metadata:    userMetadata,
+	}
+}
+
+// userMetadataFromHeader returns the values of X-Meta-* headers keyed by the lower-cased names without the prefix.
+func userMetadataFromHeader(h http.Header) map[string]string {
+	m := map[string]string{}
+	for k, vs := range h {
+		name, ok := strings.CutPrefix(http.CanonicalHeaderKey(k), UserMetadataHeaderPrefix)
+		if !ok || name == "" {
+			continue
+		}
+		m[strings.ToLower(name)] = strings.Join(vs, ", ")
+	}
+	return m
+}
+
+// saveUserMetadata stores `m` as the user metadata of the file at `p`.
+// The stale metadata is removed if `m` is empty, since the file is replaced.
+func (s *Server) saveUserMetadata(p string, m map[string]string) error {
+	metaPath := p + UserMetadataFileSuffix
+	if len(m) == 0 {
+		if err := s.fs.Remove(metaPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(s.fs, metaPath, b, 0644)
+}
+
+// loadUserMetadata returns the user metadata of the file at `p`. It is nil if the file has no user metadata.
+func (s *Server) loadUserMetadata(p string) (map[string]string, error) {
+	b, err := afero.ReadFile(s.fs, p+UserMetadataFileSuffix)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// lookupIndex returns the indexed metadata of the file if it's up to date with `fi`.
+func (s *Server) lookupIndex(p string, fi os.FileInfo) (FileMetadata, bool) {
+	if s.index == nil {
+		return FileMetadata{}, false
+	}
+	m, ok := s.index.Get(p)
+	if !ok || m.Size != fi.Size() || !m.ModTime.Equal(fi.ModTime()) {
+		return FileMetadata{}, false
+	}
+	return m, true
+}
@@ -0,0 +1,166 @@
+package simpleuploadserver
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_GetMetadata(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	content := []byte("hello, world")
+	localPath := path.Join(docRoot, "foo", "bar.txt")
+	if err := afero.WriteFile(fs, localPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := fs.Chtimes(localPath, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	roToken := "read-only-token"
+	config := ServerConfig{
+		DocumentRoot:   docRoot,
+		EnableAuth:     true,
+		ReadOnlyTokens: []string{roToken},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	router := server.router()
+
+	req := httptest.NewRequest(http.MethodGet, "/files/foo/bar.txt?meta=true", nil)
+	req.Header.Set("Authorization", "Bearer "+roToken)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %s, want = application/json", ct)
+	}
+	var result FileMetadataResult
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	want := FileMetadataResult{
+		OK:          true,
+		Name:        "bar.txt",
+		Size:        int64(len(content)),
+		ModTime:     mtime,
+		ContentType: "text/plain; charset=utf-8",
+		SHA256:      fmt.Sprintf("%x", sha256.Sum256(content)),
+	}
+	if !result.ModTime.Equal(want.ModTime) {
+		t.Errorf("mtime = %v, want = %v", result.ModTime, want.ModTime)
+	}
+	result.ModTime = want.ModTime
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %+v, want = %+v", result, want)
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/files/missing.txt?meta=true", nil)
+		req.Header.Set("Authorization", "Bearer "+roToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestServer_UserMetadata(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:           docRoot,
+		MaxUploadSize:          16,
+		EnableDirectoryListing: true,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	router := server.router()
+
+	upload := func(t *testing.T, header http.Header) {
+		req, err := makeFormRequest(&url.URL{Path: "/files/report.txt", RawQuery: "overwrite=true"}, http.MethodPut, "report.txt", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+		}
+	}
+	getMeta := func(t *testing.T) FileMetadataResult {
+		req := httptest.NewRequest(http.MethodGet, "/files/report.txt?meta=true", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+		}
+		var result FileMetadataResult
+		if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response body: %v", err)
+		}
+		return result
+	}
+
+	upload(t, http.Header{"X-Meta-Author": {"alice"}, "X-Meta-Project": {"apollo"}, "X-Other": {"ignored"}})
+	want := map[string]string{"author": "alice", "project": "apollo"}
+	if got := getMeta(t).Metadata; !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata = %v, want = %v", got, want)
+	}
+
+	t.Run("sidecar is reserved", func(t *testing.T) {
+		sidecar := "/files/report.txt" + UserMetadataFileSuffix
+		for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodDelete, MethodPropfind} {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(method, sidecar, nil))
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("%s: status = %d, want = %d", method, rr.Code, http.StatusNotFound)
+			}
+		}
+
+		req, err := makeFormRequest(&url.URL{Path: sidecar, RawQuery: "overwrite=1"}, http.MethodPut, "report.txt.meta.json", strings.NewReader(`{"author":"x"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("PUT: status = %d, want = %d", rr.Code, http.StatusBadRequest)
+		}
+		if got := getMeta(t).Metadata; !reflect.DeepEqual(got, want) {
+			t.Errorf("metadata = %v, want = %v", got, want)
+		}
+
+		for _, method := range []string{http.MethodGet, MethodPropfind} {
+			req := httptest.NewRequest(method, "/files/", nil)
+			req.Header.Set("Accept", "application/json")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if body := rr.Body.String(); !strings.Contains(body, "report.txt") || strings.Contains(body, UserMetadataFileSuffix) {
+				t.Errorf("%s: body = %s, want report.txt without the sidecar", method, body)
+			}
+		}
+	})
+
+	t.Run("overwritten without metadata", func(t *testing.T) {
+		upload(t, nil)
+		if got := getMeta(t).Metadata; got != nil {
+			t.Errorf("metadata = %v, want = nil", got)
+		}
+	})
+}
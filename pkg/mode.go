@@ -0,0 +1,55 @@
+package simpleuploadserver
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// DefaultFileMode is the permission of the uploaded files if FileMode is empty. The umask is applied to it.
+const DefaultFileMode os.FileMode = 0666
+
+// DefaultDirMode is the permission of the directories created for the uploaded files if DirMode is empty.
+// The umask is applied to it.
+const DefaultDirMode os.FileMode = 0755
+
+// parseFileMode parses `v` as an octal permission such as `0640`. `def` is returned if `v` is empty.
+func parseFileMode(v string, def os.FileMode) (os.FileMode, error) {
+	if v == "" {
+		return def, nil
+	}
+	m, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || m > uint64(os.ModePerm) {
+		return 0, fmt.Errorf("invalid permission: %s", v)
+	}
+	return os.FileMode(m), nil
+}
+
+// fileMode returns the permission of the uploaded files.
+func (s *Server) fileMode() os.FileMode {
+	m, err := parseFileMode(s.FileMode, DefaultFileMode)
+	if err != nil {
+		return DefaultFileMode
+	}
+	return m
+}
+
+// dirMode returns the permission of the directories created for the uploaded files.
+func (s *Server) dirMode() os.FileMode {
+	m, err := parseFileMode(s.DirMode, DefaultDirMode)
+	if err != nil {
+		return DefaultDirMode
+	}
+	return m
+}
+
+// validateModes returns an error if FileMode or DirMode is not an octal permission.
+func (s *Server) validateModes() error {
+	if _, err := parseFileMode(s.FileMode, DefaultFileMode); err != nil {
+		return fmt.Errorf("file_mode: %v", err)
+	}
+	if _, err := parseFileMode(s.DirMode, DefaultDirMode); err != nil {
+		return fmt.Errorf("dir_mode: %v", err)
+	}
+	return nil
+}
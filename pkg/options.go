@@ -0,0 +1,22 @@
+package simpleuploadserver
+
+import "github.com/spf13/afero"
+
+// Option customizes Server created by NewServer.
+type Option func(*Server)
+
+// WithFileSystem makes the server store and serve the files in `fs` instead of DocumentRoot on the OS file system.
+// The root of `fs` is taken as the document root.
+func WithFileSystem(fs afero.Fs) Option {
+	return func(s *Server) {
+		// BasePathFs resolves the paths relative to the root and rejects the paths going outside of it
+		s.fs = afero.NewBasePathFs(fs, "/")
+	}
+}
+
+// WithNamingStrategy makes the server name the uploaded files with `namer` instead of the one of FileNamingStrategy.
+func WithNamingStrategy(namer FileNamingStrategy) Option {
+	return func(s *Server) {
+		s.namer = namer
+	}
+}
@@ -0,0 +1,168 @@
+package simpleuploadserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+// fileOwners records the name of the token which uploaded each file. It is safe for concurrent use.
+type fileOwners struct {
+	mu          sync.Mutex
+	owners      map[string]string
+	persistPath string
+}
+
+func newFileOwners(persistPath string) *fileOwners {
+	return &fileOwners{
+		owners:      map[string]string{},
+		persistPath: persistPath,
+	}
+}
+
+// Get returns the owner of the file at `p`. It is empty if the file has no owner.
+func (o *fileOwners) Get(p string) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.owners[indexKey(p)]
+}
+
+// Set records `owner` as the owner of the file at `p`. The owner is removed if `owner` is empty.
+func (o *fileOwners) Set(p, owner string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if owner == "" {
+		delete(o.owners, indexKey(p))
+	} else {
+		o.owners[indexKey(p)] = owner
+	}
+	return o.save()
+}
+
+// save writes the owners to persistPath. The caller must hold the lock.
+func (o *fileOwners) save() error {
+	if o.persistPath == "" {
+		return nil
+	}
+	b, err := json.Marshal(o.owners)
+	if err != nil {
+		return err
+	}
+	tmp := o.persistPath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, o.persistPath)
+}
+
+// load reads the owners persisted to persistPath.
+func (o *fileOwners) load() error {
+	if o.persistPath == "" {
+		return nil
+	}
+	b, err := os.ReadFile(o.persistPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	owners := map[string]string{}
+	if err := json.Unmarshal(b, &owners); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.owners = owners
+	return nil
+}
+
+type tokenNameKey struct{}
+
+// tokenName returns the name of the token of the request `r` in NamedTokens. It is empty if the token has no name.
+func tokenName(r *http.Request) string {
+	name, _ := r.Context().Value(tokenNameKey{}).(string)
+	return name
+}
+
+// isAdmin reports whether the request `r` is authenticated with one of AdminTokens.
+func isAdmin(r *http.Request) bool {
+	return tokenScope(r) == ScopeAdmin
+}
+
+// checkOwner checks whether the request `r` may replace or delete the existing file at `p`.
+// Only the owner and admins can do so if the file has an owner.
+func (s *Server) checkOwner(r *http.Request, p string) (int, error) {
+	if s.owners == nil || isAdmin(r) {
+		return 0, nil
+	}
+	if owner := s.owners.Get(p); owner != "" && owner != tokenName(r) {
+		return http.StatusForbidden, fmt.Errorf("the file is owned by another token")
+	}
+	return 0, nil
+}
+
+// recordOwner records the name of the token of the request `r` as the owner of the file uploaded at `p`.
+// The owner is kept if an admin replaces the file.
+func (s *Server) recordOwner(r *http.Request, p string) {
+	if s.owners == nil || isAdmin(r) {
+		return
+	}
+	if err := s.owners.Set(p, tokenName(r)); err != nil {
+		log.Printf("failed to store the owner (path=%s): %v", p, err)
+	}
+}
+
+type ChownResult struct {
+	OK    bool   `json:"ok"`
+	Path  string `json:"path"`
+	Owner string `json:"owner"`
+}
+
+// handleChown reassigns the owner of the file at the path in the request body to the token named `owner`.
+// Only admins can do so.
+func (s *Server) handleChown(w http.ResponseWriter, r *http.Request) (int, any) {
+	if !isAdmin(r) {
+		return http.StatusForbidden, fmt.Errorf("admin token is required")
+	}
+	var req struct {
+		Path  string `json:"path"`
+		Owner string `json:"owner"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid request body")
+	}
+	rel, ok := strings.CutPrefix(req.Path, "/files/")
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("the path must start with /files/")
+	}
+	p := canonicalPath(s.normalizeName(rel))
+	if p == "/" {
+		return http.StatusBadRequest, fmt.Errorf("no file name is specified")
+	}
+	if isReservedPath(p) {
+		return http.StatusNotFound, fmt.Errorf("file not found")
+	}
+	if _, ok := s.NamedTokens[req.Owner]; !ok {
+		return http.StatusBadRequest, fmt.Errorf("unknown owner: %s", req.Owner)
+	}
+
+	unlock := s.lockPath(p)
+	defer unlock()
+	if isDir, err := afero.IsDir(s.fs, p); err != nil || isDir {
+		return http.StatusNotFound, fmt.Errorf("file not found")
+	}
+	if err := s.owners.Set(p, req.Owner); err != nil {
+		log.Printf("failed to store the owner (path=%s): %v", p, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to store the owner")
+	}
+	log.Printf("changed the owner of %s to %s (request_id=%s)", p, req.Owner, requestID(r.Context()))
+	return http.StatusOK, ChownResult{true, filesURLPath(p), req.Owner}
+}
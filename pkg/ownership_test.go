@@ -0,0 +1,153 @@
+package simpleuploadserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_Chown(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:    docRoot,
+		MaxUploadSize:   1024,
+		EnableAuth:      true,
+		ReadWriteTokens: []string{"rw-token"},
+		NamedTokens:     map[string]string{"alice": "alice-token", "bob": "bob-token"},
+		AdminTokens:     []string{"admin-token"},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot), owners: newFileOwners("")}
+	handler := server.router()
+	do := func(method, target, token, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	upload := func(token, body string) int {
+		t.Helper()
+		return do(http.MethodPut, "/files/notes.txt?overwrite=true", token, body).Code
+	}
+
+	if code := upload("alice-token", "by alice"); code != http.StatusCreated {
+		t.Fatalf("upload by the first uploader status = %d, want = %d", code, http.StatusCreated)
+	}
+	if code := upload("bob-token", "by bob"); code != http.StatusForbidden {
+		t.Errorf("overwrite by another token status = %d, want = %d", code, http.StatusForbidden)
+	}
+	if code := do(http.MethodDelete, "/files/notes.txt", "bob-token", "").Code; code != http.StatusForbidden {
+		t.Errorf("delete by another token status = %d, want = %d", code, http.StatusForbidden)
+	}
+	if code := upload("rw-token", "by nobody"); code != http.StatusForbidden {
+		t.Errorf("overwrite by an unnamed token status = %d, want = %d", code, http.StatusForbidden)
+	}
+	verifyLocalFile(t, fs, path.Join(docRoot, "notes.txt"), []byte("by alice"))
+
+	t.Run("only admins can change the owner", func(t *testing.T) {
+		rr := do(http.MethodPost, "/chown", "alice-token", `{"path":"/files/notes.txt","owner":"bob"}`)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusForbidden)
+		}
+		if body, want := rr.Body.String(), `{"ok":false,"error":"admin token is required"}`; body != want {
+			t.Errorf("body = %s, want = %s", body, want)
+		}
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		tests := []struct {
+			name string
+			body string
+			want int
+		}{
+			{"unknown owner", `{"path":"/files/notes.txt","owner":"carol"}`, http.StatusBadRequest},
+			{"no such file", `{"path":"/files/missing.txt","owner":"bob"}`, http.StatusNotFound},
+			{"no file name", `{"path":"/files/","owner":"bob"}`, http.StatusBadRequest},
+			{"outside of /files", `{"path":"/filesX/notes.txt","owner":"bob"}`, http.StatusBadRequest},
+			{"relative path", `{"path":"notes.txt","owner":"bob"}`, http.StatusBadRequest},
+			{"reserved path", `{"path":"/files/notes.txt` + UserMetadataFileSuffix + `","owner":"bob"}`, http.StatusNotFound},
+			{"malformed body", `{`, http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if code := do(http.MethodPost, "/chown", "admin-token", tt.body).Code; code != tt.want {
+					t.Errorf("status = %d, want = %d", code, tt.want)
+				}
+			})
+		}
+	})
+
+	rr := do(http.MethodPost, "/chown", "admin-token", `{"path":"/files/notes.txt","owner":"bob"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("chown status = %d, want = %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if body, want := rr.Body.String(), `{"ok":true,"path":"/files/notes.txt","owner":"bob"}`; body != want {
+		t.Errorf("body = %s, want = %s", body, want)
+	}
+
+	if code := upload("alice-token", "by alice again"); code != http.StatusForbidden {
+		t.Errorf("overwrite by the old owner status = %d, want = %d", code, http.StatusForbidden)
+	}
+	if code := upload("bob-token", "by bob"); code != http.StatusCreated {
+		t.Errorf("overwrite by the new owner status = %d, want = %d", code, http.StatusCreated)
+	}
+	verifyLocalFile(t, fs, path.Join(docRoot, "notes.txt"), []byte("by bob"))
+	if code := upload("admin-token", "by admin"); code != http.StatusCreated {
+		t.Errorf("overwrite by an admin status = %d, want = %d", code, http.StatusCreated)
+	}
+	// the file still belongs to the owner after an admin replaces it
+	if owner := server.owners.Get("/notes.txt"); owner != "bob" {
+		t.Errorf("owner after overwrite by an admin = %q, want = bob", owner)
+	}
+	if code := upload("bob-token", "by bob again"); code != http.StatusCreated {
+		t.Errorf("overwrite by the owner after an admin status = %d, want = %d", code, http.StatusCreated)
+	}
+
+	t.Run("admin scope", func(t *testing.T) {
+		rr := do(http.MethodGet, "/whoami", "admin-token", "")
+		if body, want := rr.Body.String(), `{"ok":true,"scope":"admin"}`; body != want {
+			t.Errorf("body = %s, want = %s", body, want)
+		}
+	})
+}
+
+func TestServer_OwnersFile(t *testing.T) {
+	docRoot := "/opt/app"
+	ownersFile := filepath.Join(t.TempDir(), "owners.json")
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 1024,
+		EnableAuth:    true,
+		NamedTokens:   map[string]string{"alice": "alice-token", "bob": "bob-token"},
+		OwnersFile:    ownersFile,
+	}
+	fs := afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)
+	upload := func(server *Server, token string) int {
+		req := httptest.NewRequest(http.MethodPut, "/files/notes.txt?overwrite=true", strings.NewReader("hello"))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		server.router().ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	server := Server{ServerConfig: config, fs: fs, owners: newFileOwners(ownersFile)}
+	if code := upload(&server, "alice-token"); code != http.StatusCreated {
+		t.Fatalf("status = %d, want = %d", code, http.StatusCreated)
+	}
+
+	// the owners are restored from the file after restart
+	restarted := Server{ServerConfig: config, fs: fs, owners: newFileOwners(ownersFile)}
+	if err := restarted.owners.load(); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if code := upload(&restarted, "bob-token"); code != http.StatusForbidden {
+		t.Errorf("status = %d, want = %d", code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,48 @@
+package simpleuploadserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+)
+
+// proxyUpload streams the uploaded content to the upstream storage specified by ProxyUploadURL.
+// It returns the status code which the upstream responded.
+func (s *Server) proxyUpload(ctx context.Context, src io.Reader, info *multipart.FileHeader, path string) (int, error) {
+	u, err := url.Parse(s.ProxyUploadURL)
+	if err != nil {
+		log.Printf("invalid proxy upload URL (url=%s): %v", s.ProxyUploadURL, err)
+		return http.StatusInternalServerError, fmt.Errorf("invalid upstream configuration")
+	}
+	u = u.JoinPath(path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), src)
+	if err != nil {
+		log.Printf("failed to create upstream request (url=%s): %v", u, err)
+		return http.StatusInternalServerError, fmt.Errorf("cannot create upstream request")
+	}
+	if ct := info.Header.Get("Content-Type"); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if isMaxBytesError(err) {
+			return http.StatusRequestEntityTooLarge, ErrFileSizeLimitExceeded
+		}
+		log.Printf("failed to send the content to the upstream (url=%s): %v", u, err)
+		return http.StatusBadGateway, fmt.Errorf("failed to send the content to the upstream")
+	}
+	defer resp.Body.Close()
+	// drain the body so that the connection can be reused
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		log.Printf("failed to read the upstream response: %v", err)
+	}
+	log.Printf("proxied upload to %s (status=%d)", u, resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("upstream responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,85 @@
+package simpleuploadserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// sizeLimitError is ErrFileSizeLimitExceeded carrying the limit applied to the upload.
+type sizeLimitError struct {
+	limit int64
+}
+
+func (e sizeLimitError) Error() string {
+	return ErrFileSizeLimitExceeded.Error()
+}
+
+func (e sizeLimitError) Unwrap() error {
+	return ErrFileSizeLimitExceeded
+}
+
+// uploadSizeLimit returns the maximum upload size for the content of `contentType`.
+// The longest prefix in MaxUploadSizeByType matching `contentType` wins. MaxUploadSize is used if nothing matches.
+func (s *Server) uploadSizeLimit(contentType string) int64 {
+	limit := s.MaxUploadSize
+	matched := ""
+	for prefix, size := range s.MaxUploadSizeByType {
+		if strings.HasPrefix(contentType, prefix) && len(prefix) > len(matched) {
+			limit = size
+			matched = prefix
+		}
+	}
+	return limit
+}
+
+// maxUploadSizeLimit returns the largest upload size among all content types. It is 0 if any of them is unlimited.
+func (s *Server) maxUploadSizeLimit() int64 {
+	limit := s.MaxUploadSize
+	if limit == 0 {
+		return 0
+	}
+	for _, size := range s.MaxUploadSizeByType {
+		if size == 0 {
+			return 0
+		}
+		limit = max(limit, size)
+	}
+	return limit
+}
+
+// limitUploadSize returns the reader of `r` failing after `limit` bytes like http.MaxBytesReader.
+// `r` is returned as is if `limit` is 0, which means unlimited.
+func limitUploadSize(w http.ResponseWriter, r io.ReadCloser, limit int64) io.ReadCloser {
+	if limit == 0 {
+		return r
+	}
+	return http.MaxBytesReader(w, r, limit)
+}
+
+// validateUploadSizes returns an error if MaxUploadSize or MaxUploadSizeByType has a negative value.
+func (s *Server) validateUploadSizes() error {
+	if s.MaxUploadSize < 0 {
+		return fmt.Errorf("max_upload_size must not be negative: %d", s.MaxUploadSize)
+	}
+	for prefix, size := range s.MaxUploadSizeByType {
+		if size < 0 {
+			return fmt.Errorf("max_upload_size_by_type for %s must not be negative: %d", prefix, size)
+		}
+	}
+	return nil
+}
+
+// sniffContentType detects the content type of `f` from its first 512 bytes and rewinds it.
+func sniffContentType(f io.ReadSeeker) (string, error) {
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
@@ -0,0 +1,70 @@
+package simpleuploadserver
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_RequestID(t *testing.T) {
+	docRoot := "/opt/app"
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+	router := server.router()
+
+	t.Run("echo the given ID", func(t *testing.T) {
+		var buf bytes.Buffer
+		orig := log.Writer()
+		log.SetOutput(&buf)
+		defer log.SetOutput(orig)
+
+		req, err := makeFormRequest(&url.URL{Path: "/files/test.txt"}, http.MethodPut, "test.txt", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Request-ID", "req-12345")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+		}
+		if id := rr.Header().Get("X-Request-ID"); id != "req-12345" {
+			t.Errorf("X-Request-ID = %s, want = req-12345", id)
+		}
+		if logged := buf.String(); !strings.Contains(logged, "request_id=req-12345") {
+			t.Errorf("log does not contain the request ID: %s", logged)
+		}
+	})
+
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"generate if absent", ""},
+		{"replace invalid ID", "bad id\n"},
+		{"replace too long ID", strings.Repeat("a", maxRequestIDLength+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/files/test.txt", nil)
+			if tt.id != "" {
+				req.Header.Set("X-Request-ID", tt.id)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			id := rr.Header().Get("X-Request-ID")
+			if id == "" || id == tt.id {
+				t.Errorf("X-Request-ID = %q, want a generated ID", id)
+			}
+		})
+	}
+}
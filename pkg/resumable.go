@@ -0,0 +1,224 @@
+package simpleuploadserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// StatusResumeIncomplete is the status code telling that the chunk is stored but the upload is not completed yet.
+// This is the same code as 308 Permanent Redirect, following the convention of the resumable media uploads.
+const StatusResumeIncomplete = http.StatusPermanentRedirect
+
+// PartialFileSuffix is appended to the path of the file being uploaded in chunks until the last chunk arrives.
+var PartialFileSuffix = ".partial"
+
+var contentRangePattern = regexp.MustCompile(`^bytes (?:(\d+)-(\d+)|\*)/(\d+|\*)$`)
+
+// contentRange represents the value of Content-Range header of a chunk.
+type contentRange struct {
+	// first and last byte positions of the chunk (inclusive). Both are -1 if the request asks the upload status.
+	start, end int64
+	// complete length of the file. -1 if unknown.
+	total int64
+}
+
+func parseContentRange(v string) (contentRange, error) {
+	m := contentRangePattern.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return contentRange{}, fmt.Errorf("invalid Content-Range: %s", v)
+	}
+	cr := contentRange{start: -1, end: -1, total: -1}
+	var err error
+	if m[1] != "" {
+		if cr.start, err = strconv.ParseInt(m[1], 10, 64); err != nil {
+			return contentRange{}, fmt.Errorf("invalid Content-Range: %s", v)
+		}
+		if cr.end, err = strconv.ParseInt(m[2], 10, 64); err != nil {
+			return contentRange{}, fmt.Errorf("invalid Content-Range: %s", v)
+		}
+		if cr.end < cr.start {
+			return contentRange{}, fmt.Errorf("invalid Content-Range: %s", v)
+		}
+	}
+	if m[3] != "*" {
+		if cr.total, err = strconv.ParseInt(m[3], 10, 64); err != nil {
+			return contentRange{}, fmt.Errorf("invalid Content-Range: %s", v)
+		}
+		if cr.end >= cr.total {
+			return contentRange{}, fmt.Errorf("invalid Content-Range: %s", v)
+		}
+	}
+	return cr, nil
+}
+
+// processChunk stores a chunk of the file sent by PUT with Content-Range header.
+// The chunks must be sent in order as the raw request body. It returns StatusResumeIncomplete with Range header
+// reporting the received bytes until the last chunk arrives, and then stores the file at `path` in the same way as
+// processUpload with the last request, and removes the partial file whether it is stored or not.
+// `Content-Range: bytes */*` (or with the total) asks the received bytes without sending a chunk.
+func (s *Server) processChunk(w http.ResponseWriter, r *http.Request, path string, sums checksums) (int, string, error) {
+	cr, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		return http.StatusBadRequest, "", err
+	}
+	ifNewer, err := parseIfNewerQuery(r.URL.Query())
+	if err != nil {
+		return http.StatusBadRequest, "", err
+	}
+	path = canonicalPath(s.normalizeName(path))
+	if status, err := s.checkUploadName(path); err != nil {
+		return status, "", err
+	}
+	// reject the declared total beyond the limit before storing anything, not to leave a partial file which can never complete
+	if s.MaxUploadSize > 0 && cr.total > s.MaxUploadSize {
+		return http.StatusRequestEntityTooLarge, "", sizeLimitError{s.MaxUploadSize}
+	}
+	allowOverwrite := parseBoolishValue(r.URL.Query().Get(OverwriteQueryKey))
+	// chunks and uploads to the same path are serialized so that the existence is checked against the settled state
+	unlock := s.lockPath(path)
+	defer unlock()
+
+	partialPath := path + PartialFileSuffix
+	// the owner of the partial file is the token which sent the first chunk, and the file may be owned by another
+	// token since then, so both are checked on every chunk
+	if status, err := s.checkOwner(r, partialPath); err != nil {
+		return status, "", err
+	}
+	if status, err := s.checkOwner(r, path); err != nil {
+		return status, "", err
+	}
+	var received int64
+	if fi, err := s.fs.Stat(partialPath); err == nil {
+		received = fi.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Printf("failed to stat the partial file (path=%s): %v", partialPath, err)
+		return http.StatusInternalServerError, "", fmt.Errorf("cannot check the upload status")
+	}
+	if cr.start < 0 {
+		setReceivedRange(w, received)
+		return StatusResumeIncomplete, "", nil
+	}
+	if cr.start != received {
+		setReceivedRange(w, received)
+		return http.StatusRequestedRangeNotSatisfiable, "", fmt.Errorf("the chunk must start at %d", received)
+	}
+	// the chunk always starts at the end of the partial file, so it never makes a sparse file beyond the limit
+	if s.MaxUploadSize > 0 && cr.end >= s.MaxUploadSize {
+		return http.StatusRequestEntityTooLarge, "", sizeLimitError{s.MaxUploadSize}
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE
+	if cr.start == 0 {
+		if status, err := s.checkDestinationType(path); err != nil {
+			return status, "", err
+		}
+		// the existing file is checked here not to receive the chunks in vain, and checked again on the last chunk
+		skip, overwrite := s.checkNewer(path, ifNewer)
+		if skip {
+			log.Printf("skipped the upload to %s since it is not newer (request_id=%s)", path, requestID(r.Context()))
+			return http.StatusNotModified, "", nil
+		}
+		if status, err := s.checkWritable(r, path, allowOverwrite || overwrite); err != nil {
+			return status, "", err
+		}
+		dirsPath := filepath.Dir(path)
+		if err := s.withRetry(func() error { return s.fs.MkdirAll(dirsPath, s.dirMode()) }); err != nil {
+			log.Printf("failed to create directories (path=%s): %v", dirsPath, err)
+			return http.StatusInternalServerError, "", fmt.Errorf("cannot create directories")
+		}
+		flag |= os.O_TRUNC
+	}
+	var dstFile afero.File
+	err = s.withRetry(func() error {
+		f, err := s.fs.OpenFile(partialPath, flag, s.fileMode())
+		dstFile = f
+		return err
+	})
+	if err != nil {
+		log.Printf("failed to open the partial file (path=%s): %v", partialPath, err)
+		return http.StatusInternalServerError, "", fmt.Errorf("cannot open file")
+	}
+	defer func() {
+		if dstFile != nil {
+			dstFile.Close()
+		}
+	}()
+	if cr.start == 0 {
+		s.recordOwner(r, partialPath)
+	}
+	if _, err := dstFile.Seek(cr.start, io.SeekStart); err != nil {
+		log.Printf("failed to seek the partial file (path=%s): %v", partialPath, err)
+		return http.StatusInternalServerError, "", fmt.Errorf("failed to write the content")
+	}
+	length := cr.end - cr.start + 1
+	cancel := s.limitUploadDuration(r)
+	defer cancel()
+	written, err := io.Copy(dstFile, io.LimitReader(r.Body, length))
+	if errors.Is(err, errUploadTimeout) {
+		// the received bytes are kept to resume the upload
+		setReceivedRange(w, cr.start+written)
+		return http.StatusRequestTimeout, "", errUploadTimeout
+	}
+	if err != nil {
+		log.Printf("failed to write the chunk (path=%s): %v", partialPath, err)
+		return http.StatusInternalServerError, "", fmt.Errorf("failed to write the content")
+	}
+	if written != length {
+		setReceivedRange(w, cr.start+written)
+		return http.StatusBadRequest, "", fmt.Errorf("the chunk is shorter than Content-Range")
+	}
+	if s.SyncOnUpload {
+		if err := s.syncFile(dstFile, partialPath); err != nil {
+			log.Printf("failed to sync the partial file (path=%s): %v", partialPath, err)
+			return http.StatusInternalServerError, "", fmt.Errorf("failed to write the content")
+		}
+	}
+	log.Printf("received a chunk of %s (bytes %d-%d/%d)", path, cr.start, cr.end, cr.total)
+	if cr.total < 0 || cr.end+1 < cr.total {
+		setReceivedRange(w, cr.end+1)
+		return StatusResumeIncomplete, "", nil
+	}
+
+	// the last chunk
+	err = dstFile.Close()
+	dstFile = nil
+	if err != nil {
+		log.Printf("failed to close the partial file (path=%s): %v", partialPath, err)
+		return http.StatusInternalServerError, "", fmt.Errorf("failed to write the content")
+	}
+	// the partial file cannot be resumed any more since all bytes are received
+	defer func() {
+		if err := s.fs.Remove(partialPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("failed to remove the partial file (path=%s): %v", partialPath, err)
+		}
+		if s.owners != nil {
+			if err := s.owners.Set(partialPath, ""); err != nil {
+				log.Printf("failed to remove the owner (path=%s): %v", partialPath, err)
+			}
+		}
+	}()
+	srcFile, err := s.fs.Open(partialPath)
+	if err != nil {
+		log.Printf("failed to open the partial file (path=%s): %v", partialPath, err)
+		return http.StatusInternalServerError, "", fmt.Errorf("failed to read the content")
+	}
+	defer srcFile.Close()
+	return s.writeUploadedFile(r, srcFile, path, sums, s.MaxUploadSize, ifNewer, allowOverwrite)
+}
+
+// setReceivedRange sets Range header reporting that the first `received` bytes are stored.
+func setReceivedRange(w http.ResponseWriter, received int64) {
+	if received > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", received-1))
+	}
+}
@@ -0,0 +1,371 @@
+package simpleuploadserver
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    contentRange
+		wantErr bool
+	}{
+		{"bytes 0-4/10", contentRange{0, 4, 10}, false},
+		{"bytes 5-9/*", contentRange{5, 9, -1}, false},
+		{"bytes */10", contentRange{-1, -1, 10}, false},
+		{"bytes */*", contentRange{-1, -1, -1}, false},
+		{"bytes 5-4/10", contentRange{}, true},
+		{"bytes 0-10/10", contentRange{}, true},
+		{"bytes=0-4/10", contentRange{}, true},
+		{"items 0-4/10", contentRange{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseContentRange(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseContentRange() error = %v, wantErr = %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseContentRange() = %+v, want = %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_ResumableUpload(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	handler := server.handle(server.handlePut)
+
+	put := func(contentRange, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/files/foo/chunked.txt", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Range", contentRange)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("status before upload", func(t *testing.T) {
+		rr := put("bytes */12", "")
+		if rr.Code != StatusResumeIncomplete {
+			t.Errorf("status = %d, want = %d", rr.Code, StatusResumeIncomplete)
+		}
+		if rng := rr.Header().Get("Range"); rng != "" {
+			t.Errorf("Range = %s, want empty", rng)
+		}
+	})
+
+	t.Run("first chunk", func(t *testing.T) {
+		rr := put("bytes 0-4/12", "hello")
+		if rr.Code != StatusResumeIncomplete {
+			t.Errorf("status = %d, want = %d", rr.Code, StatusResumeIncomplete)
+		}
+		if rng, want := rr.Header().Get("Range"), "bytes=0-4"; rng != want {
+			t.Errorf("Range = %s, want = %s", rng, want)
+		}
+		if exists, _ := afero.Exists(fs, path.Join(docRoot, "foo/chunked.txt")); exists {
+			t.Errorf("file should not be visible until the last chunk arrives")
+		}
+	})
+
+	t.Run("unexpected offset", func(t *testing.T) {
+		rr := put("bytes 7-11/12", "world")
+		if rr.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusRequestedRangeNotSatisfiable)
+		}
+		if rng, want := rr.Header().Get("Range"), "bytes=0-4"; rng != want {
+			t.Errorf("Range = %s, want = %s", rng, want)
+		}
+	})
+
+	t.Run("status query", func(t *testing.T) {
+		rr := put("bytes */12", "")
+		if rr.Code != StatusResumeIncomplete {
+			t.Errorf("status = %d, want = %d", rr.Code, StatusResumeIncomplete)
+		}
+		if rng, want := rr.Header().Get("Range"), "bytes=0-4"; rng != want {
+			t.Errorf("Range = %s, want = %s", rng, want)
+		}
+	})
+
+	t.Run("second chunk", func(t *testing.T) {
+		rr := put("bytes 5-6/12", ", ")
+		if rr.Code != StatusResumeIncomplete {
+			t.Errorf("status = %d, want = %d", rr.Code, StatusResumeIncomplete)
+		}
+		if rng, want := rr.Header().Get("Range"), "bytes=0-6"; rng != want {
+			t.Errorf("Range = %s, want = %s", rng, want)
+		}
+	})
+
+	t.Run("last chunk", func(t *testing.T) {
+		rr := put("bytes 7-11/12", "world")
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+		}
+		if body, want := rr.Body.String(), uploadedBody("/files/foo/chunked.txt", []byte("hello, world")); body != want {
+			t.Errorf("body = %s, want = %s", body, want)
+		}
+		verifyLocalFile(t, fs, path.Join(docRoot, "foo/chunked.txt"), []byte("hello, world"))
+		if exists, _ := afero.Exists(fs, path.Join(docRoot, "foo/chunked.txt"+PartialFileSuffix)); exists {
+			t.Errorf("partial file should be removed")
+		}
+	})
+
+	t.Run("existing file without overwrite", func(t *testing.T) {
+		rr := put("bytes 0-4/12", "hello")
+		if rr.Code != http.StatusConflict {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusConflict)
+		}
+	})
+
+	t.Run("total beyond max upload size", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/files/sparse.txt", strings.NewReader("a"))
+		req.Header.Set("Content-Range", "bytes 0-0/1099511627776")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusRequestEntityTooLarge)
+		}
+		if body, want := rr.Body.String(), `{"ok":false,"error":"file size limit exceeded","max_bytes":16}`; body != want {
+			t.Errorf("body = %s, want = %s", body, want)
+		}
+		if exists, _ := afero.Exists(fs, path.Join(docRoot, "sparse.txt"+PartialFileSuffix)); exists {
+			t.Errorf("partial file should not be created")
+		}
+	})
+
+	t.Run("offset beyond the received bytes", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/files/sparse.txt", strings.NewReader("a"))
+		req.Header.Set("Content-Range", "bytes 15-15/*")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusRequestedRangeNotSatisfiable)
+		}
+		if exists, _ := afero.Exists(fs, path.Join(docRoot, "sparse.txt"+PartialFileSuffix)); exists {
+			t.Errorf("partial file should not be created")
+		}
+	})
+
+	t.Run("beyond max upload size", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/files/large.txt", strings.NewReader(strings.Repeat("a", 17)))
+		req.Header.Set("Content-Range", "bytes 0-16/17")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusRequestEntityTooLarge)
+		}
+	})
+}
+
+func TestServer_ResumableUploadLastChunk(t *testing.T) {
+	docRoot := "/opt/app"
+	newServer := func() (*Server, afero.Fs) {
+		fs := afero.NewMemMapFs()
+		config := ServerConfig{
+			DocumentRoot:  docRoot,
+			MaxUploadSize: 16,
+		}
+		return &Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}, fs
+	}
+	newRequest := func(target, contentRange, body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPut, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Range", contentRange)
+		return req
+	}
+	sendFirstChunk := func(t *testing.T, server *Server, target string) {
+		rr := httptest.NewRecorder()
+		server.handle(server.handlePut).ServeHTTP(rr, newRequest(target, "bytes 0-4/12", "hello"))
+		if rr.Code != StatusResumeIncomplete {
+			t.Fatalf("status = %d, want = %d", rr.Code, StatusResumeIncomplete)
+		}
+	}
+	content := []byte("hello, world")
+
+	t.Run("trailer checksum", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			sum        string
+			wantStatus int
+		}{
+			{"valid", fmt.Sprintf("%x", sha256.Sum256(content)), http.StatusCreated},
+			{"invalid", fmt.Sprintf("%x", sha256.Sum256([]byte("something else"))), http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				server, fs := newServer()
+				sendFirstChunk(t, server, "/files/chunked.txt")
+				req := newRequest("/files/chunked.txt", "bytes 5-11/12", ", world")
+				req.Trailer = http.Header{http.CanonicalHeaderKey(ChecksumTrailer): {tt.sum}}
+				rr := httptest.NewRecorder()
+				server.handle(server.handlePut).ServeHTTP(rr, req)
+				if rr.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want = %d: %s", rr.Code, tt.wantStatus, rr.Body.String())
+				}
+				exists, _ := afero.Exists(fs, path.Join(docRoot, "chunked.txt"))
+				if want := tt.wantStatus == http.StatusCreated; exists != want {
+					t.Errorf("file exists = %v, want = %v", exists, want)
+				}
+				if exists, _ := afero.Exists(fs, path.Join(docRoot, "chunked.txt"+PartialFileSuffix)); exists {
+					t.Errorf("partial file should be removed")
+				}
+			})
+		}
+	})
+
+	t.Run("user metadata", func(t *testing.T) {
+		server, _ := newServer()
+		sendFirstChunk(t, server, "/files/chunked.txt")
+		req := newRequest("/files/chunked.txt", "bytes 5-11/12", ", world")
+		req.Header.Set("X-Meta-Author", "alice")
+		rr := httptest.NewRecorder()
+		server.handle(server.handlePut).ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+		}
+		m, err := server.loadUserMetadata("/chunked.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m["author"] != "alice" {
+			t.Errorf("metadata = %v, want author=alice", m)
+		}
+	})
+
+	t.Run("if_newer", func(t *testing.T) {
+		server, fs := newServer()
+		existingTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		if err := afero.WriteFile(fs, path.Join(docRoot, "chunked.txt"), []byte("existing"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.Chtimes(path.Join(docRoot, "chunked.txt"), existingTime, existingTime); err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		server.handle(server.handlePut).ServeHTTP(rr, newRequest("/files/chunked.txt?if_newer=2023-01-01T00:00:00Z", "bytes 0-4/12", "hello"))
+		if rr.Code != http.StatusNotModified {
+			t.Fatalf("older: status = %d, want = %d", rr.Code, http.StatusNotModified)
+		}
+
+		newer := existingTime.Add(time.Hour)
+		target := "/files/chunked.txt?if_newer=" + newer.Format(time.RFC3339)
+		sendFirstChunk(t, server, target)
+		rr = httptest.NewRecorder()
+		server.handle(server.handlePut).ServeHTTP(rr, newRequest(target, "bytes 5-11/12", ", world"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("newer: status = %d, want = %d", rr.Code, http.StatusCreated)
+		}
+		verifyLocalFile(t, fs, path.Join(docRoot, "chunked.txt"), content)
+		if fi, err := fs.Stat(path.Join(docRoot, "chunked.txt")); err != nil || !fi.ModTime().Equal(newer) {
+			t.Errorf("modification time = %v (err = %v), want = %v", fi.ModTime(), err, newer)
+		}
+	})
+
+	t.Run("partial files are reserved", func(t *testing.T) {
+		server, fs := newServer()
+		server.EnableDirectoryListing = true
+		sendFirstChunk(t, server, "/files/chunked.txt")
+		partial := "/files/chunked.txt" + PartialFileSuffix
+
+		rr := httptest.NewRecorder()
+		server.handle(server.handleGet).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, partial, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("GET: status = %d, want = %d", rr.Code, http.StatusNotFound)
+		}
+
+		req, err := makeFormRequest(&url.URL{Path: partial, RawQuery: "overwrite=1"}, http.MethodPut, "chunked.txt.partial", strings.NewReader("forged"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		server.handle(server.handlePut).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("PUT: status = %d, want = %d", rr.Code, http.StatusBadRequest)
+		}
+		verifyLocalFile(t, fs, path.Join(docRoot, "chunked.txt"+PartialFileSuffix), []byte("hello"))
+
+		req = httptest.NewRequest(http.MethodGet, "/files/", nil)
+		req.Header.Set("Accept", "application/json")
+		rr = httptest.NewRecorder()
+		server.handle(server.handleGet).ServeHTTP(rr, req)
+		if body := rr.Body.String(); strings.Contains(body, PartialFileSuffix) {
+			t.Errorf("listing = %s, should not have the partial file", body)
+		}
+	})
+
+	t.Run("proxy mode", func(t *testing.T) {
+		server, fs := newServer()
+		server.ProxyUploadURL = "http://upstream.invalid/"
+		rr := httptest.NewRecorder()
+		server.handle(server.handlePut).ServeHTTP(rr, newRequest("/files/chunked.txt", "bytes 0-4/12", "hello"))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusBadRequest)
+		}
+		if exists, _ := afero.Exists(fs, path.Join(docRoot, "chunked.txt"+PartialFileSuffix)); exists {
+			t.Errorf("partial file should not be created in proxy mode")
+		}
+	})
+}
+
+func TestServer_ResumableUploadOwner(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+		EnableAuth:    true,
+		NamedTokens:   map[string]string{"alice": "alice-token", "bob": "bob-token"},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot), owners: newFileOwners("")}
+	handler := server.router()
+	put := func(token, contentRange, body string) int {
+		req := httptest.NewRequest(http.MethodPut, "/files/chunked.txt", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Range", contentRange)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := put("alice-token", "bytes 0-4/12", "hello"); code != StatusResumeIncomplete {
+		t.Fatalf("first chunk: status = %d, want = %d", code, StatusResumeIncomplete)
+	}
+	if code := put("bob-token", "bytes 5-11/12", ", bob!!"); code != http.StatusForbidden {
+		t.Errorf("chunk by another token: status = %d, want = %d", code, http.StatusForbidden)
+	}
+	if code := put("alice-token", "bytes 5-11/12", ", world"); code != http.StatusCreated {
+		t.Fatalf("last chunk: status = %d, want = %d", code, http.StatusCreated)
+	}
+	verifyLocalFile(t, fs, path.Join(docRoot, "chunked.txt"), []byte("hello, world"))
+	if owner := server.owners.Get("/chunked.txt"); owner != "alice" {
+		t.Errorf("owner = %q, want = alice", owner)
+	}
+	if owner := server.owners.Get("/chunked.txt" + PartialFileSuffix); owner != "" {
+		t.Errorf("owner of the partial file = %q, want empty", owner)
+	}
+
+	// the chunks to replace the file are also checked against its owner, not only the first one
+	if code := put("bob-token", "bytes 0-4/12", "howdy"); code != http.StatusForbidden {
+		t.Errorf("first chunk to the file owned by another token: status = %d, want = %d", code, http.StatusForbidden)
+	}
+}
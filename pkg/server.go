@@ -1,12 +1,14 @@
 package simpleuploadserver
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/url"
@@ -15,6 +17,9 @@ import (
 	"regexp"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -24,11 +29,45 @@ import (
 type Server struct {
 	ServerConfig
 	fs afero.Fs
+
+	maintenanceMu sync.RWMutex
+	maintenance   bool
+
+	index *Index
+	stats *accessStats
+	// cached directory listings, nil if ListingCacheTTL is 0
+	listingCache *listingCache
+	jobs         *jobQueue
+	// overrides FileNamingStrategy if set
+	namer FileNamingStrategy
+	// uploads are stored here until promoted if set
+	staging afero.Fs
+	// owners of the files if NamedTokens is set
+	owners *fileOwners
+
+	// built from the token lists on the first authenticated request
+	tokensOnce sync.Once
+	tokenTable *tokenTable
+
+	// serializes the uploads to the same path
+	pathLocks keyedMutex
+
+	// set by Start to be stopped by Shutdown
+	httpServerMu sync.Mutex
+	httpServer   *http.Server
+
+	// number of uploads being processed
+	inFlight atomic.Int64
+	// number of requests being processed per client IP
+	conns connCounter
 }
 
 var (
 	FormFileKey       = "file"
 	OverwriteQueryKey = "overwrite"
+	// IfNewerQueryKey is the query parameter giving the modification time of the uploading file in RFC 3339.
+	// The existing file is overwritten only if it is older, and 304 is returned otherwise.
+	IfNewerQueryKey = "if_newer"
 )
 
 var (
@@ -37,17 +76,26 @@ var (
 
 var (
 	DefaultAddr = "127.0.0.1:8080"
+	// DefaultMultipartMaxMemory is the maximum bytes of multipart contents kept in memory if MultipartMaxMemory is not set.
+	// This is the same as the default of net/http.
+	DefaultMultipartMaxMemory int64 = 32 << 20
+	// WriteRetryBackoff is the initial wait before retrying a failed write operation.
+	WriteRetryBackoff = 100 * time.Millisecond
 )
 
 // ServerConfig is a configuration for Server.
 type ServerConfig struct {
 	// Address where the server listens on.
 	Addr string `json:"addr"`
+	// Path to the certificate file for HTTPS. The server serves HTTPS if both TLSCertFile and TLSKeyFile are set.
+	TLSCertFile string `json:"tls_cert"`
+	// Path to the private key file for HTTPS.
+	TLSKeyFile string `json:"tls_key"`
 	// Path to the document root.
 	DocumentRoot string `json:"document_root"`
 	// Determines whether to enable CORS header.
 	EnableCORS bool `json:"enable_cors"`
-	// Maximum upload size in bytes.
+	// Maximum upload size in bytes. 0 means unlimited and negative values are rejected by Start.
 	MaxUploadSize int64 `json:"max_upload_size"`
 	// File naming strategy.
 	FileNamingStrategy string `json:"file_naming_strategy"`
@@ -59,32 +107,177 @@ type ServerConfig struct {
 	ReadOnlyTokens []string `json:"read_only_tokens"`
 	// Authentication tokens for read-write access.
 	ReadWriteTokens []string `json:"read_write_tokens"`
+	// Authentication tokens for read-write access keyed by their names. The name of the token uploading a file is
+	// recorded as its owner, and only the owner and AdminTokens can overwrite or delete the file.
+	NamedTokens map[string]string `json:"named_tokens"`
+	// Authentication tokens for admin access. They can overwrite or delete any file and reassign the owner of a file.
+	AdminTokens []string `json:"admin_tokens"`
+	// Path to the file to persist the owners of the files. The owners are kept only in memory if empty.
+	OwnersFile string `json:"owners_file"`
+	// URL of the upstream storage. If set, uploads are streamed to this URL instead of being stored locally.
+	ProxyUploadURL string `json:"proxy_upload_url"`
+	// Determines whether to treat file names case-insensitively on checking the existence.
+	CaseInsensitiveNames bool `json:"case_insensitive_names"`
+	// Determines whether to start in maintenance mode. In maintenance mode, write requests are rejected.
+	MaintenanceMode bool `json:"maintenance_mode"`
+	// Directory to store large multipart contents temporarily. Defaults to the OS temp directory.
+	MultipartTempDir string `json:"multipart_temp_dir"`
+	// Name of the multipart form field carrying the uploaded file. Defaults to FormFileKey.
+	FormFieldName string `json:"form_field_name"`
+	// Path to the file to serve at `/`. If set, the server serves only this file and all other endpoints are disabled.
+	SingleFileMode string `json:"single_file_mode"`
+	// Path or URL to redirect `GET /` to with 302 (e.g. `/files/`). `/` is not found if empty. Ignored if SingleFileMode
+	// is set.
+	RootRedirect string `json:"root_redirect"`
+	// Determines whether to maintain the metadata index of the files.
+	EnableIndex bool `json:"enable_index"`
+	// Path to the file to persist the metadata index. The index is kept in memory only if empty.
+	IndexFile string `json:"index_file"`
+	// Determines whether to fail on startup if the index cannot be built. Otherwise the server runs without the index.
+	RequireIndex bool `json:"require_index"`
+	// Number of retries on failing to create directories or to open the destination file.
+	WriteRetries int `json:"write_retries"`
+	// Determines whether to count downloads per file and to enable /stats/popular.
+	EnableStats bool `json:"enable_stats"`
+	// Determines whether to emit CORS headers only when the request has Origin header.
+	CORSOnlyWithOrigin bool `json:"cors_only_with_origin"`
+	// Origins allowed by CORS (e.g. `https://example.com`). Origin of the request is echoed back only if it is one of
+	// them. Any origin is allowed with `*` if empty.
+	AllowedOrigins []string `json:"allowed_origins"`
+	// Maximum bytes of multipart contents kept in memory. The rest is stored in temporary files.
+	MultipartMaxMemory int64 `json:"multipart_max_memory"`
+	// IP addresses or CIDRs of the reverse proxies whose X-Forwarded-* headers are trusted.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// Determines whether to log headers and bodies of requests and responses. Credentials are redacted, but use with care.
+	DebugLogBodies bool `json:"debug_log_bodies"`
+	// Directory to keep uploads until they are promoted by POST /promote/:id. Uploads are published immediately if empty.
+	StagingDir string `json:"staging_dir"`
+	// Determines whether to re-read the stored file and report its size and checksum in the upload response.
+	DebugVerifyUploads bool `json:"debug_verify_uploads"`
+	// Determines whether to ignore Range requests and to respond `Accept-Ranges: none` on GET.
+	DisableRanges bool `json:"disable_ranges"`
+	// Determines whether to include the size, the modification time and the SHA-256 checksum of the existing file in 409 responses.
+	ConflictDetails bool `json:"conflict_details"`
+	// File extensions accepted on upload (e.g. `.jpg`). Any extension is accepted if empty.
+	AllowedExtensions []string `json:"allowed_extensions"`
+	// File extensions rejected on upload (e.g. `.exe`). They take precedence over AllowedExtensions.
+	BlockedExtensions []string `json:"blocked_extensions"`
+	// Maximum upload sizes in bytes by the prefix of the sniffed content type (e.g. `image/`). Overrides MaxUploadSize.
+	MaxUploadSizeByType map[string]int64 `json:"max_upload_size_by_type"`
+	// Determines whether to run PostProcess in background and to respond 202 Accepted with the job ID.
+	EnableAsyncProcessing bool `json:"enable_async_processing"`
+	// Function called with the path of each uploaded file. The upload fails if it returns an error.
+	PostProcess func(fs afero.Fs, path string) error `json:"-"`
+	// Determines whether to name all files uploaded by POST with the file naming strategy, ignoring the original names.
+	GenerateFileNames bool `json:"generate_file_names"`
+	// Additional path prefixes to download files in the same way as `/files` (e.g. `/download`).
+	FileAliases []string `json:"file_aliases"`
+	// Determines whether to fsync the uploaded file and its directory before responding.
+	SyncOnUpload bool `json:"sync_on_upload"`
+	// Checksums of the uploaded content returned in the upload response. `md5` and `sha256` are supported.
+	Checksums []string `json:"checksums"`
+	// Maximum number of uploads processed at once. Excess uploads are rejected with 503. 0 means unlimited.
+	MaxInFlightUploads int `json:"max_in_flight_uploads"`
+	// Maximum number of requests processed concurrently per client IP. Excess requests are rejected with 429. 0 means unlimited.
+	MaxConnectionsPerIP int `json:"max_connections_per_ip"`
+	// Determines whether to reject uploads resulting in files without an extension.
+	RequireExtension bool `json:"require_extension"`
+	// Determines whether to reject file names which are invalid on Windows (e.g. `foo.`, `bar ` and `CON.txt`).
+	WindowsCompatibleNames bool `json:"windows_compatible_names"`
+	// Function building the response body of the errors returned by the handlers instead of ErrorResult.
+	// `code` is the snake-cased status text (e.g. `not_found`) and `msg` is the error message.
+	ErrorFormatter func(status int, code, msg string) any `json:"-"`
+	// Determines whether to list the entries on GET of a directory. It is 404 otherwise.
+	EnableDirectoryListing bool `json:"enable_directory_listing"`
+	// Time in milliseconds to cache directory listings. Uploads and deletes invalidate the cache. 0 disables the cache.
+	ListingCacheTTL int `json:"listing_cache_ttl"`
+	// Determines whether to normalize file names in the uploads and the request URLs to Unicode NFC.
+	NormalizeUnicode bool `json:"normalize_unicode"`
+	// Maximum time in milliseconds to receive the content of an upload. It is rejected with 408 after that. 0 means unlimited.
+	MaxUploadDuration int `json:"max_upload_duration"`
+	// Permission of the uploaded files in octal (e.g. `0640`). DefaultFileMode is used if empty.
+	FileMode string `json:"file_mode"`
+	// Permission of the directories created for the uploaded files in octal (e.g. `0750`). DefaultDirMode is used if empty.
+	DirMode string `json:"dir_mode"`
+	// Determines whether to move deleted files to TrashDir and to enable POST /trash/restore to recover them.
+	SoftDelete bool `json:"soft_delete"`
+	// Time in milliseconds to keep deleted files in TrashDir while SoftDelete is enabled. 0 keeps them forever.
+	TrashRetention int `json:"trash_retention"`
 }
 
-// NewServer creates a new Server.
-func NewServer(config ServerConfig) *Server {
-	return &Server{
-		config,
-		afero.NewBasePathFs(afero.NewOsFs(), config.DocumentRoot),
+// NewServer creates a new Server. `opts` are applied in order.
+func NewServer(config ServerConfig, opts ...Option) *Server {
+	s := &Server{
+		ServerConfig: config,
+		fs:           afero.NewBasePathFs(afero.NewOsFs(), config.DocumentRoot),
+		maintenance:  config.MaintenanceMode,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if config.EnableIndex {
+		s.index = NewIndex(config.IndexFile)
+	}
+	if config.EnableStats {
+		s.stats = newAccessStats()
+	}
+	if config.ListingCacheTTL > 0 {
+		s.listingCache = newListingCache(time.Duration(config.ListingCacheTTL) * time.Millisecond)
+	}
+	if config.EnableAsyncProcessing {
+		s.jobs = newJobQueue()
+	}
+	if config.StagingDir != "" {
+		s.staging = afero.NewBasePathFs(afero.NewOsFs(), config.StagingDir)
+	}
+	if config.EnableAuth && len(config.NamedTokens) > 0 {
+		s.owners = newFileOwners(config.OwnersFile)
 	}
+	return s
 }
 
 // Start starts listening on `addr`. This function blocks until the server is stopped.
 // Optionally you can pass a channel to `ready` to be notified when the server is ready to accept connections. You can pass nil if you don't need it.
 func (s *Server) Start(ctx context.Context, ready chan struct{}) error {
-	r := mux.NewRouter()
-	r.HandleFunc("/upload", s.handle(s.handlePost)).Methods(http.MethodPost)
-	r.HandleFunc("/upload", s.handle(s.handleOptions)).Methods(http.MethodOptions)
-	// GET handler can handle HEAD request. The difference is that the response body should be empty on HEAD request.
-	r.PathPrefix("/files").Methods(http.MethodGet, http.MethodHead).HandlerFunc(s.handle(s.handleGet))
-	r.PathPrefix("/files").Methods(http.MethodPut).HandlerFunc(s.handle(s.handlePut))
-	r.PathPrefix("/files").Methods(http.MethodOptions).HandlerFunc(s.handle(s.handleOptions))
-	r.NotFoundHandler = http.HandlerFunc(handleNotFound)
-	r.MethodNotAllowedHandler = http.HandlerFunc(handleMethodNotAllowed)
-	if s.EnableAuth {
-		r.Use(s.authenticationMiddleware)
+	r := s.Handler()
+
+	if err := s.validateUploadSizes(); err != nil {
+		return err
+	}
+	if err := s.validateChecksums(); err != nil {
+		return err
+	}
+	if err := s.validateNamingStrategy(); err != nil {
+		return err
+	}
+	if err := s.validateModes(); err != nil {
+		return err
+	}
+	if (s.TLSCertFile == "") != (s.TLSKeyFile == "") {
+		return fmt.Errorf("both tls_cert and tls_key must be set to serve HTTPS")
+	}
+	if s.staging != nil {
+		if err := s.staging.MkdirAll("/", 0755); err != nil {
+			return fmt.Errorf("failed to create the staging directory %s: %v", s.StagingDir, err)
+		}
+	}
+	if err := s.prepareTempDir(); err != nil {
+		return err
+	}
+	if err := s.checkDocumentRoot(); err != nil {
+		return err
+	}
+	if err := s.buildIndex(); err != nil {
+		return err
+	}
+	if s.owners != nil {
+		if err := s.owners.load(); err != nil {
+			return fmt.Errorf("failed to load the owners from %s: %v", s.OwnersFile, err)
+		}
+	}
+	if s.SoftDelete && s.TrashRetention > 0 {
+		go s.runTrashPurger(ctx)
 	}
-	r.Use(logAccess)
 
 	addr := s.Addr
 	if addr == "" {
@@ -95,9 +288,6 @@ func (s *Server) Start(ctx context.Context, ready chan struct{}) error {
 	if err != nil {
 		return fmt.Errorf("unable to listen on %s: %v", addr, err)
 	}
-	if ready != nil {
-		close(ready)
-	}
 
 	srv := &http.Server{
 		Addr:         addr,
@@ -106,50 +296,306 @@ func (s *Server) Start(ctx context.Context, ready chan struct{}) error {
 		IdleTimeout:  60 * time.Second,
 		Handler:      r,
 	}
+	s.httpServerMu.Lock()
+	s.httpServer = srv
+	s.httpServerMu.Unlock()
+	if ready != nil {
+		close(ready)
+	}
 
 	ret := make(chan error, 1)
 	go func() {
+		if s.TLSCertFile != "" {
+			log.Printf("Start serving HTTPS on %s", addr)
+			ret <- srv.ServeTLS(l, s.TLSCertFile, s.TLSKeyFile)
+			return
+		}
 		log.Printf("Start serving on %s", addr)
 		ret <- srv.Serve(l)
 	}()
 
-	<-ctx.Done()
-	log.Printf("Shutting down... wait up to %d ms", s.ShutdownTimeout)
-	sctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.ShutdownTimeout)*time.Millisecond)
-	defer cancel()
-	if err := srv.Shutdown(sctx); err != nil {
-		log.Printf("failed to shutdown gracefully: %v", err)
+	select {
+	case <-ctx.Done():
+		log.Printf("Shutting down... wait up to %d ms", s.ShutdownTimeout)
+		sctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.ShutdownTimeout)*time.Millisecond)
+		defer cancel()
+		if err := srv.Shutdown(sctx); err != nil {
+			log.Printf("failed to shutdown gracefully: %v", err)
+		}
+		err = <-ret
+	case err = <-ret:
+	}
+	if s.index != nil {
+		if err := s.index.Flush(); err != nil {
+			log.Printf("failed to save the index: %v", err)
+		}
 	}
-	err = <-ret
 	return err
 }
 
+// Shutdown gracefully stops the server started by Start, which then returns http.ErrServerClosed.
+// It waits for the active requests until `ctx` is done. It does nothing if the server is not started.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.httpServerMu.Lock()
+	srv := s.httpServer
+	s.httpServerMu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	log.Printf("Shutting down...")
+	return srv.Shutdown(ctx)
+}
+
+// checkDocumentRoot logs the effective document root and confirms it's usable by writing and reading a file.
+// The write test is skipped if the server is read-only, that is, authentication is enabled without read-write tokens.
+func (s *Server) checkDocumentRoot() error {
+	root, err := filepath.Abs(s.DocumentRoot)
+	if err != nil {
+		return fmt.Errorf("unable to resolve the document root %s: %v", s.DocumentRoot, err)
+	}
+	log.Printf("document root: %s (resolved to %s)", s.DocumentRoot, root)
+
+	fi, err := s.fs.Stat("/")
+	if err != nil {
+		return fmt.Errorf("document root %s is not accessible: %v", root, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("document root %s is not a directory", root)
+	}
+	if s.isReadOnly() {
+		return nil
+	}
+
+	f, err := afero.TempFile(s.fs, "/", ".simple-upload-server-selftest-")
+	if err != nil {
+		return fmt.Errorf("document root %s is not writable: %v", root, err)
+	}
+	name := f.Name()
+	defer func() {
+		if err := s.fs.Remove(name); err != nil {
+			log.Printf("failed to remove the self-test file (path=%s): %v", name, err)
+		}
+	}()
+	content := []byte("simple-upload-server self-test")
+	_, err = f.Write(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("document root %s is not writable: %v", root, err)
+	}
+	got, err := afero.ReadFile(s.fs, name)
+	if err != nil {
+		return fmt.Errorf("document root %s is not readable: %v", root, err)
+	}
+	if !bytes.Equal(got, content) {
+		return fmt.Errorf("document root %s returned unexpected content on self-test", root)
+	}
+	return nil
+}
+
+// Handler returns the handler serving all endpoints with the middlewares, to mount it on another server.
+// Unlike Start, it does not check the document root nor build the index.
+func (s *Server) Handler() http.Handler {
+	return s.router()
+}
+
+// router builds the router with all routes and middlewares.
+func (s *Server) router() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc(HealthzPath, s.handle(s.handleHealthz)).Methods(http.MethodGet)
+	if s.SingleFileMode != "" {
+		r.Path("/").Methods(http.MethodGet, http.MethodHead).HandlerFunc(s.handle(s.handleSingleFile))
+	} else {
+		if s.RootRedirect != "" {
+			r.Path("/").Methods(http.MethodGet, http.MethodHead).Handler(http.RedirectHandler(s.RootRedirect, http.StatusFound))
+		}
+		r.HandleFunc("/upload", s.handle(s.handlePost)).Methods(http.MethodPost)
+		r.HandleFunc("/upload", s.handle(s.handleOptions(r))).Methods(http.MethodOptions)
+		// GET handler can handle HEAD request. The difference is that the response body should be empty on HEAD request.
+		r.PathPrefix("/files").Methods(http.MethodGet, http.MethodHead).HandlerFunc(s.handle(s.handleGet))
+		for _, alias := range s.FileAliases {
+			alias = "/" + strings.Trim(alias, "/")
+			r.PathPrefix(alias+"/").Methods(http.MethodGet, http.MethodHead).Handler(rewritePrefix(alias, "/files", s.handle(s.handleGet)))
+		}
+		r.PathPrefix("/files").Methods(http.MethodPut).HandlerFunc(s.handle(s.handlePut))
+		r.PathPrefix("/files").Methods(http.MethodDelete).HandlerFunc(s.handle(s.handleDelete))
+		r.PathPrefix("/files").Methods(MethodPropfind).HandlerFunc(s.handle(s.handlePropfind))
+		r.PathPrefix("/files").Methods(http.MethodOptions).HandlerFunc(s.handle(s.handleOptions(r)))
+		r.HandleFunc("/maintenance", s.handle(s.handleMaintenance)).Methods(http.MethodPost)
+		r.HandleFunc("/whoami", s.handle(s.handleWhoAmI)).Methods(http.MethodGet)
+		r.HandleFunc("/strategies", s.handle(s.handleStrategies)).Methods(http.MethodGet)
+		r.HandleFunc(UploadConfigPath, s.handle(s.handleUploadConfig)).Methods(http.MethodGet)
+		if s.stats != nil {
+			r.HandleFunc("/stats/popular", s.handle(s.handlePopular)).Methods(http.MethodGet)
+		}
+		if s.jobs != nil {
+			r.HandleFunc("/jobs/{id}", s.handle(s.handleJob)).Methods(http.MethodGet)
+		}
+		if s.staging != nil {
+			r.HandleFunc("/staged", s.handle(s.handleStaged)).Methods(http.MethodGet)
+			r.HandleFunc("/promote/{id}", s.handle(s.handlePromote)).Methods(http.MethodPost)
+		}
+		if s.SoftDelete {
+			r.HandleFunc("/trash/restore", s.handle(s.handleTrashRestore)).Methods(http.MethodPost)
+		}
+		if s.owners != nil {
+			r.HandleFunc("/chown", s.handle(s.handleChown)).Methods(http.MethodPost)
+		}
+	}
+	// middlewares are not applied to NotFoundHandler and MethodNotAllowedHandler
+	r.NotFoundHandler = s.corsMiddleware(http.HandlerFunc(handleNotFound))
+	r.MethodNotAllowedHandler = s.corsMiddleware(s.handleMethodNotAllowed(r))
+	// logAccess runs before authenticationMiddleware so that rejected requests are also logged.
+	// The token is redacted by logAccess itself.
+	r.Use(requestIDMiddleware)
+	r.Use(logAccess)
+	if s.DebugLogBodies {
+		r.Use(s.debugLogMiddleware)
+	}
+	r.Use(s.corsMiddleware)
+	if s.MaxConnectionsPerIP > 0 {
+		r.Use(s.connLimitMiddleware)
+	}
+	if s.EnableAuth {
+		r.Use(s.authenticationMiddleware)
+	}
+	r.Use(s.maintenanceMiddleware)
+	if s.MaxInFlightUploads > 0 {
+		r.Use(s.loadSheddingMiddleware)
+	}
+	return r
+}
+
+// prepareTempDir checks that the directory where large uploaded contents are stored temporarily is writable.
+func (s *Server) prepareTempDir() error {
+	f, err := os.CreateTemp(s.MultipartTempDir, "simple-upload-server-")
+	if err != nil {
+		if s.MultipartTempDir != "" {
+			return fmt.Errorf("multipart temp directory %s is not writable: %v", s.MultipartTempDir, err)
+		}
+		log.Printf("[WARN] temp directory %s is not writable; large uploads will fail: %v", os.TempDir(), err)
+		return nil
+	}
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		log.Printf("failed to remove the temp file (path=%s): %v", f.Name(), err)
+	}
+	return nil
+}
+
 func logAccess(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startedAt := time.Now()
+		// the request line is taken before the handlers, which may modify the URL
+		requestLine := fmt.Sprintf("\"%s %s %s\"", r.Method, redactTokenQuery(r.URL).RequestURI(), r.Proto)
+		rw := &accessLogResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
 		vs := []string{
 			r.RemoteAddr,
 			"-",
 			"-",
-			time.Now().Format("[02/Jan/2006:15:04:05 -0700]"),
-			fmt.Sprintf("\"%s %s %s\"", r.Method, r.URL.Path, r.Proto),
-			fmt.Sprintf("%d", http.StatusOK), // TODO: actual status
-			"0",                              // TODO: actual size
-			fmt.Sprintf("\"%s\"", r.Referer()),
+			startedAt.Format("[02/Jan/2006:15:04:05 -0700]"),
+			requestLine,
+			fmt.Sprintf("%d", rw.status),
+			fmt.Sprintf("%d", rw.size),
+			fmt.Sprintf("\"%s\"", redactReferer(r.Referer())),
 			fmt.Sprintf("\"%s\"", r.UserAgent()),
 		}
 		log.Println(strings.Join(vs, " "))
-		next.ServeHTTP(w, r)
+	})
+}
+
+// accessLogResponseWriter records the status and the size of the response for the access log.
+// The status is 200 unless WriteHeader is called before writing the body.
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	wroteHeader bool
+}
+
+func (w *accessLogResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying writer for http.ResponseController to flush the response.
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// redactTokenQuery returns a copy of `u` with the token parameter redacted.
+func redactTokenQuery(u *url.URL) *url.URL {
+	redacted := *u
+	q := u.Query()
+	if q.Has("token") {
+		q.Set("token", redactedValue)
+		redacted.RawQuery = q.Encode()
+	}
+	return &redacted
+}
+
+// redactReferer returns `referer` with the token parameter redacted.
+func redactReferer(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil || !u.Query().Has("token") {
+		return referer
+	}
+	return redactTokenQuery(u).String()
+}
+
+// rewritePrefix replaces the prefix `from` of the request path with `to` and passes the request to `next`.
+func rewritePrefix(from, to string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = to + strings.TrimPrefix(r.URL.Path, from)
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
 	})
 }
 
 var fileRe = regexp.MustCompile(`^/files/(.+)$`)
 
-func getPathFromURL(u *url.URL) string {
+// errInvalidPath is returned for the request paths trying to escape the document root.
+var errInvalidPath = errors.New("invalid path")
+
+// getPathFromURL extracts the path to the file from the request URL.
+// Trailing slashes are removed so that `/files/foo/` and `/files/foo` are treated equivalently.
+// The path is rejected with errInvalidPath if it contains `..` segments, including percent-encoded ones.
+func getPathFromURL(u *url.URL) (string, error) {
 	matches := fileRe.FindStringSubmatch(u.Path)
 	if matches == nil {
-		return ""
+		return "", nil
 	}
-	return matches[1]
+	for _, seg := range strings.Split(matches[1], "/") {
+		if seg == ".." {
+			return "", errInvalidPath
+		}
+	}
+	return strings.TrimRight(matches[1], "/"), nil
+}
+
+// pathFromURL is getPathFromURL normalizing the path by normalizeName.
+func (s *Server) pathFromURL(u *url.URL) (string, error) {
+	p, err := getPathFromURL(u)
+	if err != nil {
+		return "", err
+	}
+	return s.normalizeName(p), nil
 }
 
 type ErrorResult struct {
@@ -157,9 +603,48 @@ type ErrorResult struct {
 	Error string `json:"error"`
 }
 
+// errorCode returns the snake-cased status text of `status`, such as `not_found` for 404.
+func errorCode(status int) string {
+	text := strings.ToLower(http.StatusText(status))
+	text = strings.ReplaceAll(text, "'", "")
+	text = strings.ReplaceAll(text, "-", "_")
+	return strings.ReplaceAll(text, " ", "_")
+}
+
+type FileSizeLimitExceededResult struct {
+	OK       bool   `json:"ok"`
+	Error    string `json:"error"`
+	MaxBytes int64  `json:"max_bytes"`
+}
+
 type SuccessfullyUploadedResult struct {
-	OK   bool   `json:"ok"`
-	Path string `json:"path"`
+	OK     bool   `json:"ok"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	MD5    string `json:"md5,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// set only if DebugVerifyUploads is enabled
+	Stored *StoredFileResult `json:"stored,omitempty"`
+}
+
+// StoredFileResult describes the file read back from the storage after the upload.
+type StoredFileResult struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ConflictResult is the 409 response including the existing file. It is used only if ConflictDetails is enabled.
+type ConflictResult struct {
+	OK       bool               `json:"ok"`
+	Error    string             `json:"error"`
+	Existing ExistingFileResult `json:"existing"`
+}
+
+// ExistingFileResult describes the file conflicting with the upload.
+type ExistingFileResult struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
 }
 
 func justOK() (int, any) {
@@ -171,9 +656,8 @@ func (s *Server) handle(f func(w http.ResponseWriter, r *http.Request) (int, any
 		status, result := f(w, r)
 		var responseBody []byte
 		if result != nil {
-			switch v := result.(type) {
-			case error:
-				result = ErrorResult{false, v.Error()}
+			if v, ok := result.(error); ok {
+				result = s.errorResult(status, v)
 			}
 			respBytes, err := json.Marshal(result)
 			if err != nil {
@@ -199,121 +683,755 @@ func (s *Server) handle(f func(w http.ResponseWriter, r *http.Request) (int, any
 	}
 }
 
+// errorResult returns the response body for `err` responded with `status`.
+func (s *Server) errorResult(status int, err error) any {
+	if s.ErrorFormatter != nil {
+		return s.ErrorFormatter(status, errorCode(status), err.Error())
+	}
+	if errors.Is(err, ErrFileSizeLimitExceeded) {
+		maxBytes := s.MaxUploadSize
+		var limitErr sizeLimitError
+		if errors.As(err, &limitErr) {
+			maxBytes = limitErr.limit
+		}
+		return FileSizeLimitExceededResult{false, err.Error(), maxBytes}
+	}
+	if conflict := (conflictError{}); errors.As(err, &conflict) {
+		return ConflictResult{false, err.Error(), conflict.existing}
+	}
+	return ErrorResult{false, err.Error()}
+}
+
 func (s *Server) handlePost(w http.ResponseWriter, r *http.Request) (int, any) {
-	status, destPath, err := s.processUpload(w, r, "")
+	if isBatchUpload(r) {
+		return s.handleBatchUpload(w, r)
+	}
+	sums := s.newChecksums()
+	status, destPath, err := s.processUpload(w, r, "", sums)
 	if err != nil {
 		return status, err
 	}
-	if s.EnableCORS {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+	if status == http.StatusNotModified {
+		return status, nil
 	}
-	return http.StatusCreated, SuccessfullyUploadedResult{true, destPath}
+	return s.respondUploaded(w, r, status, destPath, sums)
 }
 
 func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) (int, any) {
-	path := getPathFromURL(r.URL)
+	path, err := s.pathFromURL(r.URL)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if path == "" {
+		log.Printf("URL not matched: (url=%s)", r.URL.String())
+		return http.StatusBadRequest, fmt.Errorf("no file name is specified; PUT is accepted on /files/:name")
+	}
+
+	var status int
+	var destPath string
+	sums := s.newChecksums()
+	if r.Header.Get("Content-Range") != "" {
+		// the chunks are written to the document root directly
+		if s.staging != nil {
+			return http.StatusBadRequest, fmt.Errorf("chunked uploads are not supported with staging")
+		}
+		if s.ProxyUploadURL != "" {
+			return http.StatusBadRequest, fmt.Errorf("chunked uploads are not supported in proxy mode")
+		}
+		status, destPath, err = s.processChunk(w, r, path, sums)
+	} else {
+		status, destPath, err = s.processUpload(w, r, path, sums)
+	}
+	if err != nil {
+		return status, err
+	}
+	if status == StatusResumeIncomplete || status == http.StatusNotModified {
+		return status, nil
+	}
+	return s.respondUploaded(w, r, status, destPath, sums)
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) (int, any) {
+	path, err := s.pathFromURL(r.URL)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
 	if path == "" {
 		log.Printf("URL not matched: (url=%s)", r.URL.String())
-		return http.StatusMethodNotAllowed, fmt.Errorf("PUT is accepted on /files/:name")
+		return http.StatusBadRequest, fmt.Errorf("no file name is specified; DELETE is accepted on /files/:name")
 	}
+	path = canonicalPath(path)
+	if isReservedPath(path) {
+		return http.StatusNotFound, fmt.Errorf("file not found")
+	}
+	unlock := s.lockPath(path)
+	defer unlock()
 
-	status, destPath, err := s.processUpload(w, r, path)
+	exists, err := afero.Exists(s.fs, path)
 	if err != nil {
+		log.Printf("failed to check the existence (path=%s): %v", path, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to check the file")
+	}
+	if !exists {
+		return http.StatusNotFound, fmt.Errorf("file not found")
+	}
+	if isDir, err := afero.IsDir(s.fs, path); err == nil && isDir {
+		return http.StatusConflict, fmt.Errorf("%s is a directory", path)
+	}
+	if status, err := s.checkOwner(r, path); err != nil {
 		return status, err
 	}
+	remove := s.fs.Remove
+	if s.SoftDelete {
+		remove = s.moveToTrash
+	}
+	if err := remove(path); err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			log.Printf("permission denied (path=%s): %v", path, err)
+			return http.StatusForbidden, fmt.Errorf("permission denied")
+		}
+		log.Printf("failed to remove the file (path=%s): %v", path, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to remove the file")
+	}
+	log.Printf("deleted %s", path)
+	if s.SoftDelete {
+		if s.listingCache != nil {
+			s.listingCache.Invalidate(trashPath(path))
+		}
+	} else {
+		if err := s.saveUserMetadata(path, nil); err != nil {
+			log.Printf("failed to remove the user metadata (path=%s): %v", path, err)
+		}
+		// the owner is kept for the soft-deleted file so that it is restored with the file
+		if s.owners != nil {
+			if err := s.owners.Set(path, ""); err != nil {
+				log.Printf("failed to remove the owner (path=%s): %v", path, err)
+			}
+		}
+	}
+	if s.index != nil {
+		if err := s.index.Remove(s.fs, path); err != nil {
+			log.Printf("failed to update the index (path=%s): %v", path, err)
+		}
+	}
+	if s.stats != nil {
+		s.stats.Remove(filesURLPath(path))
+	}
+	if s.listingCache != nil {
+		s.listingCache.Invalidate(path)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return justOK()
+}
 
-	if s.EnableCORS {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+// respondUploaded runs the post-processing of the uploaded file and builds the response.
+// If EnableAsyncProcessing is set, the post-processing runs in background and 202 Accepted is returned.
+func (s *Server) respondUploaded(w http.ResponseWriter, r *http.Request, status int, destPath string, sums checksums) (int, any) {
+	status, result, location := s.uploadResult(status, destPath, sums)
+	if location != "" {
+		w.Header().Set("Location", s.absoluteURL(r, location))
+	}
+	if _, ok := result.(SuccessfullyUploadedResult); !ok {
+		return status, result
 	}
-	return http.StatusCreated, SuccessfullyUploadedResult{true, destPath}
+	switch preferredReturn(r) {
+	case "minimal":
+		w.Header().Set("Preference-Applied", "return=minimal")
+		w.WriteHeader(status)
+		return justOK()
+	case "representation":
+		w.Header().Set("Preference-Applied", "return=representation")
+	}
+	return status, result
+}
+
+// uploadResult runs the post-processing of the file uploaded to `destPath` and returns the result with the path to be
+// told in Location header. The path is empty if the result has nothing to locate.
+func (s *Server) uploadResult(status int, destPath string, sums checksums) (int, any, string) {
+	// staged uploads are processed on promotion
+	if id, ok := strings.CutPrefix(destPath, stagedPathPrefix); ok && s.staging != nil {
+		upload, err := s.loadStagedUpload(id)
+		if err != nil {
+			log.Printf("failed to load the staged upload (id=%s): %v", id, err)
+			return http.StatusInternalServerError, fmt.Errorf("failed to load the staged upload"), ""
+		}
+		return status, UploadStagedResult{true, id, upload.Path}, ""
+	}
+	var stored *StoredFileResult
+	// the file is not stored locally in proxy mode
+	if s.ProxyUploadURL == "" {
+		path := strings.TrimPrefix(destPath, "/files")
+		if s.DebugVerifyUploads {
+			var err error
+			if stored, err = s.readStoredFile(path); err != nil {
+				log.Printf("failed to read back the uploaded file (path=%s): %v", path, err)
+				return http.StatusInternalServerError, fmt.Errorf("failed to read back the uploaded file"), ""
+			}
+		}
+		if s.jobs != nil {
+			job := s.jobs.Enqueue(destPath, func() error { return s.postProcess(path) })
+			return http.StatusAccepted, UploadAcceptedResult{true, destPath, job.ID}, "/jobs/" + job.ID
+		}
+		if err := s.postProcess(path); err != nil {
+			log.Printf("failed to process the uploaded file (path=%s): %v", path, err)
+			return http.StatusInternalServerError, fmt.Errorf("failed to process the uploaded file"), ""
+		}
+	}
+	result := SuccessfullyUploadedResult{OK: true, Path: destPath, Stored: stored}
+	sums.Apply(&result)
+	return status, result, destPath
+}
+
+// processUpload stores the uploaded file and returns the path to access it. `sums` are computed over the content.
+func (s *Server) processUpload(w http.ResponseWriter, r *http.Request, path string, sums checksums) (int, string, error) {
+	ifNewer, err := parseIfNewerQuery(r.URL.Query())
+	if err != nil {
+		return http.StatusBadRequest, "", err
+	}
+
+	cancel := s.limitUploadDuration(r)
+	defer cancel()
+	srcFile, info, status, err := s.openUploadedFile(w, r, path)
+	if err != nil {
+		return status, "", err
+	}
+	return s.storeUploadedFile(w, r, srcFile, info, path, sums, ifNewer)
 }
 
-func (s *Server) processUpload(w http.ResponseWriter, r *http.Request, path string) (int, string, error) {
+// storeUploadedFile stores `srcFile` read from the request `r` and returns the path to access it. `srcFile` is closed
+// when it returns. The file is named after `info` if `path` is empty.
+func (s *Server) storeUploadedFile(w http.ResponseWriter, r *http.Request, srcFile multipart.File, info *multipart.FileHeader, path string, sums checksums, ifNewer time.Time) (int, string, error) {
 	allowOverwrite := parseBoolishValue(r.URL.Query().Get(OverwriteQueryKey))
 	if allowOverwrite {
 		log.Printf("allowOverwrite")
 	}
-
-	srcFile, info, err := r.FormFile(FormFileKey)
-	if err != nil {
-		log.Printf("failed to obtain form file: %v", err)
-		return http.StatusInternalServerError, "", fmt.Errorf("cannot obtain the uploaded content")
+	limit := s.MaxUploadSize
+	if len(s.MaxUploadSizeByType) > 0 {
+		contentType, err := sniffContentType(srcFile)
+		if err != nil {
+			srcFile.Close()
+			log.Printf("failed to detect the content type: %v", err)
+			return http.StatusInternalServerError, "", fmt.Errorf("cannot read the uploaded content")
+		}
+		limit = s.uploadSizeLimit(contentType)
 	}
-	src := http.MaxBytesReader(w, srcFile, s.MaxUploadSize)
-	// MaxBytesReader closes the underlying io.Reader on its Close() is called
+	src := limitUploadSize(w, srcFile, limit)
+	// closing src also closes the underlying srcFile
 	defer src.Close()
 
+	// the name is derived from the content, so the existing file of the name has the same content
+	contentAddressed := false
 	// on POST method request
-	if path == "" {
+	if path == "" && r.Header.Get(UploadPathHeader) != "" {
+		p, err := sanitizeUploadPath(r.Header.Get(UploadPathHeader))
+		if err != nil {
+			return http.StatusBadRequest, "", err
+		}
+		path = p
+	} else if path == "" {
 		filename := info.Filename
-		if filename == "" {
-			namer := ResolveFileNamingStrategy(s.FileNamingStrategy)
-			s, err := namer(srcFile, info)
+		if filename == "" || s.GenerateFileNames {
+			namer := s.namer
+			if namer == nil {
+				strategy := resolveNamingStrategy(s.FileNamingStrategy)
+				namer, contentAddressed = strategy.fn, strategy.contentAddressed
+			}
+			// Start rejects unknown strategies, but the handler may be used without Start
+			if namer == nil {
+				log.Printf("unknown file naming strategy: %s", s.FileNamingStrategy)
+				return http.StatusInternalServerError, "", fmt.Errorf("cannot generate filename")
+			}
+			generated, err := namer(srcFile, info)
 			if err != nil {
 				log.Printf("cannot generate filename: %v", err)
 				return http.StatusInternalServerError, "", fmt.Errorf("cannot generate filename")
 			}
-			filename = s
+			filename = generated
+			// the naming strategy may read the content
+			if _, err := srcFile.Seek(0, io.SeekStart); err != nil {
+				log.Printf("failed to rewind the uploaded content: %v", err)
+				return http.StatusInternalServerError, "", fmt.Errorf("cannot read the uploaded content")
+			}
+			if filepath.Ext(filename) == "" {
+				contentType, err := sniffContentType(srcFile)
+				if err != nil {
+					log.Printf("failed to detect the content type: %v", err)
+					return http.StatusInternalServerError, "", fmt.Errorf("cannot read the uploaded content")
+				}
+				filename += extensionByType(contentType)
+			}
 		}
 		path = "/" + filename
 	}
 
-	if exists, err := afero.Exists(s.fs, path); err != nil {
-		log.Printf("failed to check the existence of the file (path=%s): %v", path, err)
-		return http.StatusInternalServerError, "", fmt.Errorf("cannot check the existence of the file")
-	} else if exists && !allowOverwrite {
-		return http.StatusConflict, "", fmt.Errorf("the file already exists")
+	// PUT gives the path from the URL and POST from the file name. The same path is used from here on
+	// regardless of the method.
+	path = canonicalPath(s.normalizeName(path))
+	destPath := filesURLPath(path)
+
+	if status, err := s.checkUploadName(path); err != nil {
+		return status, "", err
+	}
+
+	if s.ProxyUploadURL != "" {
+		// the content is hashed while it is streamed to the upstream
+		status, err := s.proxyUpload(r.Context(), io.TeeReader(src, sums.Writer(io.Discard)), info, path)
+		if err != nil {
+			return status, "", err
+		}
+		return status, destPath, nil
+	}
+	if s.staging != nil {
+		return s.stageUpload(r, src, path, sums, limit)
+	}
+
+	unlock := s.lockPath(path)
+	defer unlock()
+	if s.CaseInsensitiveNames {
+		// the existing file whose name differs only in case is overwritten instead of creating another one
+		if existing, exists, err := s.resolveName(path); err == nil && exists {
+			path, destPath = existing, filesURLPath(existing)
+		}
+	}
+	if contentAddressed {
+		if exists, err := s.exists(path); err == nil && exists {
+			// the content is the same, but the preconditions and the owner are checked as overwriting the file
+			if status, err := s.checkWritable(r, path, true); err != nil {
+				return status, "", err
+			}
+			// drain the content to compute the checksums
+			trailerSum := trailerChecksum(r)
+			var dst io.Writer = io.Discard
+			if trailerSum != nil {
+				dst = trailerSum
+			}
+			if _, err := io.Copy(sums.Writer(dst), src); err != nil {
+				if isMaxBytesError(err) {
+					return http.StatusRequestEntityTooLarge, "", sizeLimitError{limit}
+				}
+				log.Printf("failed to read the uploaded content: %v", err)
+				return http.StatusInternalServerError, "", fmt.Errorf("cannot read the uploaded content")
+			}
+			if err := verifyTrailerChecksum(r, trailerSum); err != nil {
+				return http.StatusBadRequest, "", err
+			}
+			if status, err := s.completeUpload(r, path); err != nil {
+				return status, "", err
+			}
+			log.Printf("deduplicated the upload to %s (request_id=%s)", path, requestID(r.Context()))
+			return http.StatusOK, destPath, nil
+		}
+	}
+	return s.writeUploadedFile(r, src, path, sums, limit, ifNewer, allowOverwrite)
+}
+
+// writeUploadedFile writes `src` to the canonical path `path` and returns the path to access it. `sums` are computed
+// over the content, and the checksum in the trailer of `r` is verified. The caller must hold the lock of `path`.
+func (s *Server) writeUploadedFile(r *http.Request, src io.Reader, path string, sums checksums, limit int64, ifNewer time.Time, allowOverwrite bool) (int, string, error) {
+	destPath := filesURLPath(path)
+	if status, err := s.checkDestinationType(path); err != nil {
+		return status, "", err
+	}
+	skip, overwrite := s.checkNewer(path, ifNewer)
+	if skip {
+		log.Printf("skipped the upload to %s since it is not newer (request_id=%s)", path, requestID(r.Context()))
+		return http.StatusNotModified, "", nil
+	}
+	allowOverwrite = allowOverwrite || overwrite
+	if status, err := s.checkWritable(r, path, allowOverwrite); err != nil {
+		return status, "", err
 	}
 
 	// ensure the directories exist
 	dirsPath := filepath.Dir(path)
-	if err := s.fs.MkdirAll(dirsPath, 0755); err != nil {
+	if err := s.withRetry(func() error { return s.fs.MkdirAll(dirsPath, s.dirMode()) }); err != nil {
 		log.Printf("failed to create directories (path=%s): %v", dirsPath, err)
 		return http.StatusInternalServerError, "", fmt.Errorf("cannot create directories")
 	}
 
-	dstFile, err := s.fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	// the content is written to the temporary file and renamed to `path` on success, so that the existing file is
+	// replaced only by the complete content
+	tmpPath := path + UploadingFileSuffix
+	var dstFile afero.File
+	err := s.withRetry(func() error {
+		f, err := s.fs.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, s.fileMode())
+		dstFile = f
+		return err
+	})
 	if err != nil {
-		log.Printf("failed to open the destination file (path=%s): %v", path, err)
+		log.Printf("failed to open the destination file (path=%s): %v", tmpPath, err)
 		return http.StatusInternalServerError, "", fmt.Errorf("cannot open file")
 	}
-	defer dstFile.Close()
-	written, err := io.Copy(dstFile, src)
+	defer func() {
+		if dstFile != nil {
+			dstFile.Close()
+		}
+		// removed unless it is renamed to `path`
+		if err := s.fs.Remove(tmpPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("failed to remove the temporary file (path=%s): %v", tmpPath, err)
+		}
+	}()
+	trailerSum := trailerChecksum(r)
+	var dst io.Writer = dstFile
+	if trailerSum != nil {
+		dst = io.MultiWriter(dstFile, trailerSum)
+	}
+	written, err := io.Copy(sums.Writer(dst), src)
 	if err != nil {
-		var maxBytesError *http.MaxBytesError
-		if errors.As(err, &maxBytesError) {
-			return http.StatusRequestEntityTooLarge, "", ErrFileSizeLimitExceeded
+		// the content may be streamed from the request, so the file is incomplete if reading fails halfway
+		if isMaxBytesError(err) {
+			return http.StatusRequestEntityTooLarge, "", sizeLimitError{limit}
+		}
+		if errors.Is(err, errUploadTimeout) {
+			return http.StatusRequestTimeout, "", errUploadTimeout
+		}
+		if errors.Is(err, syscall.ENOSPC) {
+			log.Printf("no space left on the device (path=%s, written=%d)", path, written)
+			return http.StatusInsufficientStorage, "", fmt.Errorf("insufficient storage")
 		}
 		log.Printf("failed to write the uploaded content: %v", err)
 		return http.StatusInternalServerError, "", fmt.Errorf("failed to write the content")
 	}
-	log.Printf("uploaded to %s (%d bytes)", path, written)
+	if err := verifyTrailerChecksum(r, trailerSum); err != nil {
+		log.Printf("failed to verify the uploaded content (path=%s): %v", path, err)
+		return http.StatusBadRequest, "", err
+	}
+	if s.SyncOnUpload {
+		if err := dstFile.Sync(); err != nil {
+			log.Printf("failed to sync the uploaded file (path=%s): %v", tmpPath, err)
+			return http.StatusInternalServerError, "", fmt.Errorf("failed to write the content")
+		}
+	}
+	err = dstFile.Close()
+	dstFile = nil
+	if err != nil {
+		log.Printf("failed to close the uploaded file (path=%s): %v", tmpPath, err)
+		return http.StatusInternalServerError, "", fmt.Errorf("failed to write the content")
+	}
+	if !ifNewer.IsZero() {
+		// the next upload is compared with the time of this content, not the time of the upload.
+		// The time is set after closing since closing may update it, and kept by renaming.
+		if err := s.fs.Chtimes(tmpPath, ifNewer, ifNewer); err != nil {
+			log.Printf("failed to set the modification time (path=%s): %v", tmpPath, err)
+			return http.StatusInternalServerError, "", fmt.Errorf("failed to write the content")
+		}
+	}
+	if err := s.withRetry(func() error { return s.fs.Rename(tmpPath, path) }); err != nil {
+		log.Printf("failed to rename the uploaded file (from=%s, to=%s): %v", tmpPath, path, err)
+		return http.StatusInternalServerError, "", fmt.Errorf("failed to write the content")
+	}
+	if s.SyncOnUpload {
+		s.syncDir(filepath.Dir(path))
+	}
+	if status, err := s.completeUpload(r, path); err != nil {
+		return status, "", err
+	}
+	log.Printf("uploaded to %s (%d bytes, request_id=%s)", path, written, requestID(r.Context()))
+	return http.StatusCreated, destPath, nil
+}
 
-	destPath := path
-	if !strings.HasPrefix(destPath, "/") {
-		destPath = "/" + destPath
+// completeUpload stores the user metadata and the owner of the file uploaded at `path` by the request `r`, and updates
+// the index and the listing cache.
+func (s *Server) completeUpload(r *http.Request, path string) (int, error) {
+	if err := s.saveUserMetadata(path, userMetadataFromHeader(r.Header)); err != nil {
+		log.Printf("failed to store the user metadata (path=%s): %v", path, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to store the metadata")
+	}
+	s.recordOwner(r, path)
+	if s.index != nil {
+		if err := s.index.Update(s.fs, path); err != nil {
+			log.Printf("failed to update the index (path=%s): %v", path, err)
+		}
+	}
+	if s.listingCache != nil {
+		s.listingCache.Invalidate(path)
 	}
-	destPath = "/files" + destPath
+	return 0, nil
+}
 
-	log.Printf("uploaded by PUT to %s (%d bytes)", path, written)
-	if s.EnableCORS {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+// checkUploadName checks whether a file may be uploaded at the canonical path `p` regardless of the way of the upload.
+func (s *Server) checkUploadName(p string) (int, error) {
+	if isReservedPath(p) {
+		return http.StatusBadRequest, errReservedPath
 	}
-	return http.StatusCreated, destPath, nil
+	if s.RequireExtension && !hasExtension(p) {
+		return http.StatusBadRequest, fmt.Errorf("the file name must have an extension")
+	}
+	if s.WindowsCompatibleNames {
+		if err := checkWindowsCompatible(p); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+	if err := s.checkExtension(p); err != nil {
+		return http.StatusUnsupportedMediaType, err
+	}
+	return 0, nil
+}
+
+// checkNewer reports whether the upload of the content modified at `ifNewer` is skipped since the file at `path` is
+// not older, or whether the upload may overwrite the file otherwise. Both are false if `ifNewer` is zero.
+func (s *Server) checkNewer(path string, ifNewer time.Time) (skip, overwrite bool) {
+	if ifNewer.IsZero() {
+		return false, false
+	}
+	fi, err := s.fs.Stat(path)
+	if err != nil {
+		return false, false
+	}
+	if !ifNewer.After(fi.ModTime()) {
+		return true, false
+	}
+	return false, true
+}
+
+// checkWritable checks whether the request `r` may write the file at `path`.
+func (s *Server) checkWritable(r *http.Request, path string, allowOverwrite bool) (int, error) {
+	// `If-None-Match: *` means create-only and `If-Match: *` means update-only (RFC 9110 section 13.1)
+	createOnly := r.Header.Get("If-None-Match") == "*"
+	updateOnly := r.Header.Get("If-Match") == "*"
+	exists, err := s.exists(path)
+	if err != nil {
+		log.Printf("failed to check the existence of the file (path=%s): %v", path, err)
+		return http.StatusInternalServerError, fmt.Errorf("cannot check the existence of the file")
+	}
+	if exists {
+		if status, err := s.checkOwner(r, path); err != nil {
+			return status, err
+		}
+	}
+	switch {
+	case exists && createOnly:
+		return http.StatusPreconditionFailed, fmt.Errorf("the file already exists")
+	case !exists && updateOnly:
+		return http.StatusPreconditionFailed, fmt.Errorf("the file does not exist")
+	case exists && !allowOverwrite && !updateOnly:
+		if s.ConflictDetails {
+			existing, err := s.describeExistingFile(path)
+			if err != nil {
+				log.Printf("failed to read the existing file (path=%s): %v", path, err)
+				return http.StatusConflict, fmt.Errorf("the file already exists")
+			}
+			return http.StatusConflict, conflictError{existing}
+		}
+		return http.StatusConflict, fmt.Errorf("the file already exists")
+	}
+	return 0, nil
+}
+
+// checkDestinationType returns 409 if a file cannot be created at `path` since `path` is a directory or one of its
+// parents is a file. Otherwise creating the directories or the file fails with an obscure error.
+func (s *Server) checkDestinationType(path string) (int, error) {
+	if fi, err := s.fs.Stat(path); err == nil && fi.IsDir() {
+		return http.StatusConflict, fmt.Errorf("%s is a directory", path)
+	}
+	for dir := filepath.Dir(path); dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		fi, err := s.fs.Stat(dir)
+		if err != nil {
+			// not created yet, or one of its parents is a file
+			continue
+		}
+		if !fi.IsDir() {
+			return http.StatusConflict, fmt.Errorf("cannot create %s: %s is a file", path, dir)
+		}
+		// the parents of an existing directory are directories
+		break
+	}
+	return 0, nil
+}
+
+// conflictError is the error of the existing file carrying its details.
+type conflictError struct {
+	existing ExistingFileResult
+}
+
+func (e conflictError) Error() string {
+	return "the file already exists"
+}
+
+// describeExistingFile returns the size, the modification time and the SHA-256 checksum of the file at `path`.
+func (s *Server) describeExistingFile(path string) (ExistingFileResult, error) {
+	fi, err := s.fs.Stat(path)
+	if err != nil {
+		return ExistingFileResult{}, err
+	}
+	stored, err := s.readStoredFile(path)
+	if err != nil {
+		return ExistingFileResult{}, err
+	}
+	return ExistingFileResult{Size: stored.Size, ModTime: fi.ModTime(), SHA256: stored.SHA256}, nil
+}
+
+// checkExtension checks whether the extension of `path` is not one of BlockedExtensions and is one of AllowedExtensions.
+// It is case-insensitive.
+func (s *Server) checkExtension(path string) error {
+	name := strings.ToLower(filepath.Base(path))
+	for _, ext := range s.BlockedExtensions {
+		if hasSuffixExtension(name, ext) {
+			return fmt.Errorf("file type not allowed")
+		}
+	}
+	if len(s.AllowedExtensions) == 0 {
+		return nil
+	}
+	allowed := make([]string, 0, len(s.AllowedExtensions))
+	for _, ext := range s.AllowedExtensions {
+		if hasSuffixExtension(name, ext) {
+			return nil
+		}
+		allowed = append(allowed, normalizeExtension(ext))
+	}
+	return fmt.Errorf("only %s allowed", strings.Join(allowed, ", "))
+}
+
+// normalizeExtension returns `ext` in lower case with the leading dot (e.g. `.jpg` for `JPG`).
+func normalizeExtension(ext string) string {
+	return "." + strings.TrimPrefix(strings.ToLower(ext), ".")
+}
+
+// hasSuffixExtension reports whether the lower-cased file name `name` ends with the extension `ext`.
+// Multi-part extensions such as `.tar.gz` are supported. A name consisting only of the extension, as in `.exe`, is not
+// regarded as having it.
+func hasSuffixExtension(name, ext string) bool {
+	ext = normalizeExtension(ext)
+	return strings.HasSuffix(name, ext) && name != ext
+}
+
+// hasExtension reports whether the file name of `path` has an extension. A leading dot, as in `.bashrc`, is not an extension.
+func hasExtension(path string) bool {
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	return ext != "" && ext != name
+}
+
+// syncFile flushes `f` at `path` and its parent directory to the storage.
+// Failing to sync the directory is only logged since some platforms do not support it.
+func (s *Server) syncFile(f afero.File, path string) error {
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	s.syncDir(filepath.Dir(path))
+	return nil
+}
+
+// syncDir flushes the directory entries of `dir` to the storage so that created or renamed files persist.
+func (s *Server) syncDir(dir string) {
+	d, err := s.fs.Open(dir)
+	if err != nil {
+		log.Printf("failed to open the directory to sync (path=%s): %v", dir, err)
+		return
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		log.Printf("failed to sync the directory (path=%s): %v", dir, err)
+	}
+}
+
+// withRetry calls `f` and retries up to WriteRetries times with exponential backoff while it fails.
+func (s *Server) withRetry(f func() error) error {
+	backoff := WriteRetryBackoff
+	for i := 0; ; i++ {
+		err := f()
+		if err == nil || i >= s.WriteRetries {
+			return err
+		}
+		log.Printf("retrying in %v (%d/%d): %v", backoff, i+1, s.WriteRetries, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// exists reports whether the file at `path` exists.
+// If CaseInsensitiveNames is enabled, the file is looked up with ignoring case.
+func (s *Server) exists(path string) (bool, error) {
+	_, exists, err := s.resolveName(path)
+	return exists, err
+}
+
+// resolveName returns the path of the existing file at `path`. If CaseInsensitiveNames is enabled, it may differ from
+// `path` in case.
+func (s *Server) resolveName(path string) (string, bool, error) {
+	if !s.CaseInsensitiveNames {
+		exists, err := afero.Exists(s.fs, path)
+		return path, exists, err
+	}
+	dir := "/"
+	for _, name := range strings.Split(strings.Trim(filepath.ToSlash(path), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		entries, err := afero.ReadDir(s.fs, dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return "", false, nil
+			}
+			return "", false, err
+		}
+		i := slices.IndexFunc(entries, func(fi os.FileInfo) bool {
+			return strings.EqualFold(fi.Name(), name)
+		})
+		if i < 0 {
+			return "", false, nil
+		}
+		dir = filepath.Join(dir, entries[i].Name())
+	}
+	return dir, true, nil
 }
 
 func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) (int, any) {
-	requestPath := getPathFromURL(r.URL)
+	requestPath, err := s.pathFromURL(r.URL)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
 	if requestPath == "" {
-		return http.StatusNotFound, fmt.Errorf("file not found")
+		if r.URL.Path != "/files" && r.URL.Path != "/files/" {
+			return http.StatusNotFound, fmt.Errorf("file not found")
+		}
+		if parseBoolishValue(r.URL.Query().Get(SizeQueryKey)) {
+			return s.serveDirectorySize(r, "/")
+		}
+		if !s.EnableDirectoryListing {
+			return http.StatusNotFound, fmt.Errorf("no file is specified and directory listing is disabled")
+		}
+		return s.serveDirectory(w, r, "/")
 	}
 	log.Printf("GET %s -> %s", r.URL.Path, requestPath)
+	if isReservedPath(requestPath) {
+		return http.StatusNotFound, fmt.Errorf("file not found")
+	}
+	if parseBoolishValue(r.URL.Query().Get(MetaQueryKey)) {
+		return s.serveMetadata(requestPath)
+	}
+	if parseBoolishValue(r.URL.Query().Get(SizeQueryKey)) {
+		return s.serveDirectorySize(r, requestPath)
+	}
+	if s.EnableDirectoryListing {
+		if fi, err := s.fs.Stat(requestPath); err == nil && fi.IsDir() {
+			return s.serveDirectory(w, r, requestPath)
+		}
+	}
+	status, result := s.serveFile(w, r, requestPath)
+	if s.stats != nil && status == 0 && r.Method == http.MethodGet {
+		s.stats.Increment("/files/" + requestPath)
+	}
+	return status, result
+}
+
+// handleSingleFile serves the file specified by SingleFileMode.
+func (s *Server) handleSingleFile(w http.ResponseWriter, r *http.Request) (int, any) {
+	return s.serveFile(w, r, s.SingleFileMode)
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, requestPath string) (int, any) {
 	f, err := s.fs.Open(requestPath)
 	if err != nil {
 		// ErrNotExist is a common case so don't log it
 		if errors.Is(err, os.ErrNotExist) {
 			return http.StatusNotFound, fmt.Errorf("file not found")
 		}
+		if errors.Is(err, os.ErrPermission) {
+			log.Printf("permission denied (path=%s): %v", requestPath, err)
+			return http.StatusForbidden, fmt.Errorf("permission denied")
+		}
 		log.Printf("Error: %+v", err)
 		return http.StatusInternalServerError, fmt.Errorf("failed to open file")
 	}
@@ -329,55 +1447,124 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) (int, any) {
 		return http.StatusNotFound, fmt.Errorf("%s is a directory", requestPath)
 	}
 	name := fi.Name()
-	modtime := fi.ModTime()
+	// HTTP dates have 1-second granularity. Truncate sub-second precision so that
+	// Last-Modified and If-Modified-Since/If-Unmodified-Since are compared consistently.
+	modtime := fi.ModTime().Truncate(time.Second)
+	if parseBoolishValue(r.URL.Query().Get(DownloadQueryKey)) {
+		w.Header().Set("Content-Disposition", contentDisposition("attachment", name))
+	}
+	if s.DisableRanges {
+		// the whole content is served regardless of Range
+		r.Header.Del("Range")
+		r.Header.Del("If-Range")
+		w = noRangesResponseWriter{w}
+	}
 	http.ServeContent(w, r, name, modtime, f)
 	return justOK()
 }
 
-func (s *Server) handleOptions(w http.ResponseWriter, r *http.Request) (int, any) {
-	var allowedMethods []string
-	if r.URL.Path == "/upload" {
-		allowedMethods = []string{http.MethodPost}
-	} else if strings.HasPrefix(r.URL.Path, "/files") {
-		allowedMethods = []string{http.MethodGet, http.MethodPut, http.MethodHead}
+// noRangesResponseWriter responds `Accept-Ranges: none` instead of `bytes` set by http.ServeContent.
+type noRangesResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w noRangesResponseWriter) WriteHeader(status int) {
+	w.Header().Set("Accept-Ranges", "none")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// routedMethods are the methods reported by allowedMethods if they are routed.
+var routedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, MethodPropfind}
+
+// allowedMethods returns the methods routed by `router` for the path of `r`. Write methods are omitted if the server is read-only.
+func (s *Server) allowedMethods(router *mux.Router, r *http.Request) []string {
+	var methods []string
+	for _, method := range routedMethods {
+		if s.isReadOnly() && isWriteMethod(method) {
+			continue
+		}
+		req := r.Clone(r.Context())
+		req.Method = method
+		var match mux.RouteMatch
+		if router.Match(req, &match) && match.MatchErr == nil {
+			methods = append(methods, method)
+		}
 	}
-	if s.EnableCORS {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+	return methods
+}
+
+// isReadOnly reports whether no requests can write files, that is, authentication is enabled without read-write tokens.
+func (s *Server) isReadOnly() bool {
+	return s.EnableAuth && len(s.ReadWriteTokens) == 0 && len(s.NamedTokens) == 0 && len(s.AdminTokens) == 0
+}
+
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// handleOptions returns the handler of OPTIONS request reporting the methods routed by `router`.
+func (s *Server) handleOptions(router *mux.Router) func(w http.ResponseWriter, r *http.Request) (int, any) {
+	return func(w http.ResponseWriter, r *http.Request) (int, any) {
+		allowedMethods := s.allowedMethods(router, r)
+		if slices.Contains(allowedMethods, MethodPropfind) {
+			// tell WebDAV clients that PROPFIND is supported
+			w.Header().Set("DAV", "1")
+		}
+		if (!s.CORSOnlyWithOrigin || r.Header.Get("Origin") != "") && s.allowedOrigin(r) != "" {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ", "))
+		}
+		return http.StatusNoContent, nil
 	}
-	w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ", "))
-	return http.StatusNoContent, nil
 }
 
 func (s *Server) authenticationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// OPTIONS request is always allowed without authentication
-		if r.Method == http.MethodOptions {
+		// OPTIONS request, the upload config and the health check are always allowed without authentication
+		if r.Method == http.MethodOptions || (r.Method == http.MethodGet && (r.URL.Path == UploadConfigPath || r.URL.Path == HealthzPath)) {
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		var token string
 		if auth := r.Header.Get("Authorization"); auth != "" {
-			token = strings.TrimPrefix(auth, "Bearer ")
+			scheme, credentials, _ := strings.Cut(auth, " ")
+			if !strings.EqualFold(scheme, "Bearer") {
+				log.Printf("unsupported authorization scheme: %s", scheme)
+				writeUnauthorized(w, r, "unsupported authorization scheme; expected Bearer")
+				return
+			}
+			token = strings.TrimSpace(credentials)
+			if token == "" {
+				log.Printf("empty bearer token")
+				writeUnauthorized(w, r, "empty bearer token")
+				return
+			}
 		} else if t := r.URL.Query().Get("token"); t != "" {
 			token = t
 		}
 		if token == "" {
 			log.Printf("no token")
-			writeUnauthorized(w, r)
+			writeUnauthorized(w, r, "unauthorized")
 			return
 		}
-		var allowedTokens []string
-		allowedTokens = append(allowedTokens, s.ReadWriteTokens...)
-		if r.Method == http.MethodGet || r.Method == http.MethodHead {
-			allowedTokens = append(allowedTokens, s.ReadOnlyTokens...)
-		}
-		if !slices.Contains(allowedTokens, token) {
+		grant := s.tokens().Resolve(token)
+		readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == MethodPropfind
+		if !grant.valid() || (grant.scope == ScopeReadOnly && !readOnly) {
 			log.Printf("invalid token")
-			writeUnauthorized(w, r)
+			writeUnauthorized(w, r, "unauthorized")
 			return
 		}
 		log.Print("successfully authenticated")
+		scope, name := grant.scope, grant.name
+		ctx := context.WithValue(r.Context(), tokenScopeKey{}, scope)
+		if name != "" {
+			ctx = context.WithValue(ctx, tokenNameKey{}, name)
+		}
+		r = r.WithContext(ctx)
 		r.Header.Del("Authorization")
 		u := r.URL
 		q := u.Query()
@@ -388,7 +1575,7 @@ func (s *Server) authenticationMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func writeUnauthorized(w http.ResponseWriter, r *http.Request) {
+func writeUnauthorized(w http.ResponseWriter, r *http.Request, message string) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 	if r.Method != http.MethodHead {
 		w.Header().Set("Content-Type", "application/json")
@@ -397,7 +1584,7 @@ func writeUnauthorized(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodHead {
 		return
 	}
-	resp := ErrorResult{false, "unauthorized"}
+	resp := ErrorResult{false, message}
 	respBytes, err := json.Marshal(resp)
 	if err != nil {
 		log.Printf("failed to encode response: %v", err)
@@ -408,45 +1595,66 @@ func writeUnauthorized(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func handleNotFound(w http.ResponseWriter, r *http.Request) {
-	resp := ErrorResult{false, "not found"}
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if r.Method != http.MethodHead {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	resp := ErrorResult{false, message}
 	respBytes, err := json.Marshal(resp)
 	if err != nil {
 		log.Printf("failed to encode response: %v", err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusNotFound)
 	if _, err := w.Write(respBytes); err != nil {
 		log.Printf("failed to write response: %v", err)
 	}
 }
 
-func handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
-	var endpoint string
-	var allowedMethods []string
-	if r.URL.Path == "/upload" {
-		endpoint = "/upload"
-		allowedMethods = []string{http.MethodPost}
-	}
-	if strings.HasPrefix(r.URL.Path, "/files") {
-		endpoint = "/files"
-		allowedMethods = []string{http.MethodGet, http.MethodPut}
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	// browsers request /favicon.ico on their own; answer it quietly rather than with an error.
+	if r.URL.Path == "/favicon.ico" && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		w.WriteHeader(http.StatusNoContent)
+		return
 	}
-	w.Header().Set("Allow", strings.Join(allowedMethods, ", "))
-	resp := ErrorResult{false, fmt.Sprintf("%s is not allowed on %s", r.Method, endpoint)}
+	resp := ErrorResult{false, "not found"}
 	respBytes, err := json.Marshal(resp)
 	if err != nil {
 		log.Printf("failed to encode response: %v", err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusMethodNotAllowed)
+	w.WriteHeader(http.StatusNotFound)
 	if _, err := w.Write(respBytes); err != nil {
 		log.Printf("failed to write response: %v", err)
 	}
 }
 
+// handleMethodNotAllowed returns the handler responding 405 with Allow header reporting the methods routed by `router`.
+func (s *Server) handleMethodNotAllowed(router *mux.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoint := r.URL.Path
+		if strings.HasPrefix(endpoint, "/files") {
+			endpoint = "/files"
+		}
+		w.Header().Set("Allow", strings.Join(s.allowedMethods(router, r), ", "))
+		resp := ErrorResult{false, fmt.Sprintf("%s is not allowed on %s", r.Method, endpoint)}
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("failed to encode response: %v", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		if _, err := w.Write(respBytes); err != nil {
+			log.Printf("failed to write response: %v", err)
+		}
+	}
+}
+
 func getFileSize(r io.Seeker) (int64, error) {
 	cur, err := r.Seek(0, io.SeekCurrent)
 	if err != nil {
@@ -462,6 +1670,11 @@ func getFileSize(r io.Seeker) (int64, error) {
 	return size, nil
 }
 
+func isMaxBytesError(err error) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError)
+}
+
 func parseBoolishValue(s string) bool {
 	truthyValues := []string{"yes", "true", "1"}
 	return slices.Contains(truthyValues, strings.ToLower(s))
@@ -3,12 +3,23 @@ package simpleuploadserver
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"mime/multipart"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path"
@@ -18,6 +29,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 )
@@ -66,7 +78,7 @@ func TestServer(t *testing.T) {
 			ShutdownTimeout: 5000,
 		}
 		ready := make(chan struct{})
-		server := Server{config, afero.NewBasePathFs(fs, docRoot)}
+		server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
 		go func() {
 			t.Logf("starting server at %s", target)
 			server.Start(ctx, ready) // nolint:errcheck
@@ -115,7 +127,7 @@ func TestServer(t *testing.T) {
 		if err := json.Unmarshal(body, &result); err != nil {
 			t.Fatalf("failed to decode response body: %v", err)
 		}
-		expected := SuccessfullyUploadedResult{true, "/files/hello.txt"}
+		expected := uploadedResult("/files/hello.txt", []byte("hello, world"))
 		if !reflect.DeepEqual(result, expected) {
 			t.Errorf("result = %+v, want = %+v", result, expected)
 		}
@@ -224,7 +236,7 @@ func TestServer(t *testing.T) {
 			if err := json.Unmarshal(body, &result); err != nil {
 				t.Fatalf("failed to decode response body: %v", err)
 			}
-			expected := SuccessfullyUploadedResult{true, "/files/test.txt"}
+			expected := uploadedResult("/files/test.txt", []byte(newContent))
 			if !reflect.DeepEqual(result, expected) {
 				t.Errorf("result = %+v, want = %+v", result, expected)
 			}
@@ -258,7 +270,7 @@ func TestServer(t *testing.T) {
 		if err := json.Unmarshal(body, &result); err != nil {
 			t.Fatalf("failed to decode response body: %v", err)
 		}
-		expected := SuccessfullyUploadedResult{true, "/files/hello_put.txt"}
+		expected := uploadedResult("/files/hello_put.txt", []byte("hello, world"))
 		if !reflect.DeepEqual(result, expected) {
 			t.Errorf("result = %+v, want = %+v", result, expected)
 		}
@@ -397,7 +409,7 @@ func TestServer(t *testing.T) {
 			if err := json.Unmarshal(body, &result); err != nil {
 				t.Fatalf("failed to decode response body: %v", err)
 			}
-			expected := SuccessfullyUploadedResult{true, "/files/foo/bar.txt"}
+			expected := uploadedResult("/files/foo/bar.txt", []byte("new world"))
 			if !reflect.DeepEqual(result, expected) {
 				t.Errorf("result = %+v, want = %+v", result, expected)
 			}
@@ -517,7 +529,7 @@ func TestServer(t *testing.T) {
 		for i := range allowedMethods {
 			allowedMethods[i] = strings.TrimSpace(allowedMethods[i])
 		}
-		expectedAllowedMethods := []string{"GET", "HEAD", "PUT"}
+		expectedAllowedMethods := []string{"GET", "HEAD", "PUT", "DELETE"}
 		if !containsAll(allowedMethods, expectedAllowedMethods) {
 			t.Errorf("Access-Control-Allow-Methods = %v, want = %v", allowedMethods, expectedAllowedMethods)
 		}
@@ -592,7 +604,7 @@ func TestServerWithAuth(t *testing.T) {
 		ReadWriteTokens: []string{rwToken},
 	}
 	ready := make(chan struct{})
-	server := Server{config, afero.NewBasePathFs(fs, docRoot)}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
 	go func() {
 		t.Logf("starting server at %s", addr)
 		server.Start(ctx, ready) // nolint:errcheck
@@ -630,7 +642,7 @@ func TestServerWithAuth(t *testing.T) {
 		if err := json.Unmarshal(body, &result); err != nil {
 			t.Fatalf("failed to decode response body: %v", err)
 		}
-		expected := SuccessfullyUploadedResult{true, "/files/hello.txt"}
+		expected := uploadedResult("/files/hello.txt", []byte("hello, world"))
 		if !reflect.DeepEqual(result, expected) {
 			t.Errorf("result = %+v, want = %+v", result, expected)
 		}
@@ -666,7 +678,7 @@ func TestServerWithAuth(t *testing.T) {
 		if err := json.Unmarshal(body, &result); err != nil {
 			t.Fatalf("failed to decode response body: %v", err)
 		}
-		expected := SuccessfullyUploadedResult{true, "/files/hello_query.txt"}
+		expected := uploadedResult("/files/hello_query.txt", []byte("hello, world"))
 		if !reflect.DeepEqual(result, expected) {
 			t.Errorf("result = %+v, want = %+v", result, expected)
 		}
@@ -736,6 +748,67 @@ func TestServerWithAuth(t *testing.T) {
 		}
 	})
 
+	t.Run("POST /upload with Basic authorization", func(t *testing.T) {
+		u := base.JoinPath("/upload")
+		req, err := makeFormRequest(u, http.MethodPost, "hello.txt", bytes.NewBufferString("hello, world"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Basic "+rwToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to POST: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("status = %d, want = %d", resp.StatusCode, http.StatusUnauthorized)
+		}
+		if wa := resp.Header.Get("WWW-Authenticate"); wa != "Bearer" {
+			t.Errorf("WWW-Authenticate = %s, want = \"Bearer\"", wa)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read response body: %v", err)
+		}
+		var result ErrorResult
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Fatalf("failed to decode response body: %v", err)
+		}
+		expected := ErrorResult{false, "unsupported authorization scheme; expected Bearer"}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("result = %+v, want = %+v", result, expected)
+		}
+	})
+
+	t.Run("POST /upload with malformed Bearer authorization", func(t *testing.T) {
+		u := base.JoinPath("/upload")
+		req, err := makeFormRequest(u, http.MethodPost, "hello.txt", bytes.NewBufferString("hello, world"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer ")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to POST: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("status = %d, want = %d", resp.StatusCode, http.StatusUnauthorized)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read response body: %v", err)
+		}
+		var result ErrorResult
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Fatalf("failed to decode response body: %v", err)
+		}
+		expected := ErrorResult{false, "empty bearer token"}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("result = %+v, want = %+v", result, expected)
+		}
+	})
+
 	t.Run("PUT /files/hello_put.txt with read-write token", func(t *testing.T) {
 		u := base.JoinPath("/files/hello_put.txt")
 		content := bytes.NewBufferString("hello, world")
@@ -763,7 +836,7 @@ func TestServerWithAuth(t *testing.T) {
 		if err := json.Unmarshal(body, &result); err != nil {
 			t.Fatalf("failed to decode response body: %v", err)
 		}
-		expected := SuccessfullyUploadedResult{true, "/files/hello_put.txt"}
+		expected := uploadedResult("/files/hello_put.txt", []byte("hello, world"))
 		if !reflect.DeepEqual(result, expected) {
 			t.Errorf("result = %+v, want = %+v", result, expected)
 		}
@@ -835,6 +908,44 @@ func TestServerWithAuth(t *testing.T) {
 		}
 	})
 
+	t.Run("DELETE /files/test.txt with read-only token", func(t *testing.T) {
+		u := base.JoinPath("/files/test.txt")
+		req, err := http.NewRequest(http.MethodDelete, u.String(), nil)
+		if err != nil {
+			t.Fatalf("failed to create DELETE request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+roToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to DELETE: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("status = %d, want = %d", resp.StatusCode, http.StatusUnauthorized)
+		}
+		verifyLocalFile(t, fs, filepath.Join(docRoot, "test.txt"), []byte("lorem ipsum"))
+	})
+
+	t.Run("DELETE /files/test.txt with read-write token", func(t *testing.T) {
+		u := base.JoinPath("/files/test.txt")
+		req, err := http.NewRequest(http.MethodDelete, u.String(), nil)
+		if err != nil {
+			t.Fatalf("failed to create DELETE request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+rwToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to DELETE: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("status = %d, want = %d", resp.StatusCode, http.StatusNoContent)
+		}
+		if exists, _ := afero.Exists(fs, filepath.Join(docRoot, "test.txt")); exists {
+			t.Errorf("file should be removed")
+		}
+	})
+
 	t.Run("GET /files/foo/bar.txt using rw token with Authorization header", func(t *testing.T) {
 		u := base.JoinPath("/files/foo/bar.txt")
 		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
@@ -1031,7 +1142,7 @@ func TestServerWithAuth(t *testing.T) {
 		for i := range allowedMethods {
 			allowedMethods[i] = strings.TrimSpace(allowedMethods[i])
 		}
-		expectedAllowedMethods := []string{"GET", "HEAD", "PUT"}
+		expectedAllowedMethods := []string{"GET", "HEAD", "PUT", "DELETE"}
 		if !containsAll(allowedMethods, expectedAllowedMethods) {
 			t.Errorf("Access-Control-Allow-Methods = %v, want = %v", allowedMethods, expectedAllowedMethods)
 		}
@@ -1064,7 +1175,7 @@ func TestServerWithAuth(t *testing.T) {
 		for i := range allowedMethods {
 			allowedMethods[i] = strings.TrimSpace(allowedMethods[i])
 		}
-		expectedAllowedMethods := []string{"GET", "HEAD", "PUT"}
+		expectedAllowedMethods := []string{"GET", "HEAD", "PUT", "DELETE"}
 		if !containsAll(allowedMethods, expectedAllowedMethods) {
 			t.Errorf("Access-Control-Allow-Methods = %v, want = %v", allowedMethods, expectedAllowedMethods)
 		}
@@ -1100,7 +1211,7 @@ func TestServerWithAuth(t *testing.T) {
 		for i := range allowedMethods {
 			allowedMethods[i] = strings.TrimSpace(allowedMethods[i])
 		}
-		expectedAllowedMethods := []string{"GET", "HEAD", "PUT"}
+		expectedAllowedMethods := []string{"GET", "HEAD", "PUT", "DELETE"}
 		if !containsAll(allowedMethods, expectedAllowedMethods) {
 			t.Errorf("Access-Control-Allow-Methods = %v, want = %v", allowedMethods, expectedAllowedMethods)
 		}
@@ -1131,7 +1242,7 @@ func TestServerWithAuth(t *testing.T) {
 		for i := range allowedMethods {
 			allowedMethods[i] = strings.TrimSpace(allowedMethods[i])
 		}
-		expectedAllowedMethods := []string{"GET", "HEAD", "PUT"}
+		expectedAllowedMethods := []string{"GET", "HEAD", "PUT", "DELETE"}
 		if !containsAll(allowedMethods, expectedAllowedMethods) {
 			t.Errorf("Access-Control-Allow-Methods = %v, want = %v", allowedMethods, expectedAllowedMethods)
 		}
@@ -1139,6 +1250,65 @@ func TestServerWithAuth(t *testing.T) {
 			t.Errorf("Access-Control-Allow-Origin = %s, want = \"*\"", acao)
 		}
 	})
+
+	t.Run("OPTIONS /upload using rw token with Authorization header", func(t *testing.T) {
+		u := base.JoinPath("/upload")
+		req, err := http.NewRequest(http.MethodOptions, u.String(), nil)
+		if err != nil {
+			t.Fatalf("failed to create OPTIONS request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+rwToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to OPTIONS: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("status = %d, want = %d", resp.StatusCode, http.StatusNoContent)
+		}
+		if acam := resp.Header.Get("Access-Control-Allow-Methods"); acam != "POST" {
+			t.Errorf("Access-Control-Allow-Methods = %s, want = POST", acam)
+		}
+		if acao := resp.Header.Get("Access-Control-Allow-Origin"); acao != "*" {
+			t.Errorf("Access-Control-Allow-Origin = %s, want = \"*\"", acao)
+		}
+	})
+
+	t.Run("OPTIONS /upload without tokens", func(t *testing.T) {
+		u := base.JoinPath("/upload")
+		req, err := http.NewRequest(http.MethodOptions, u.String(), nil)
+		if err != nil {
+			t.Fatalf("failed to create OPTIONS request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to OPTIONS: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("status = %d, want = %d", resp.StatusCode, http.StatusNoContent)
+		}
+		if acam := resp.Header.Get("Access-Control-Allow-Methods"); acam != "POST" {
+			t.Errorf("Access-Control-Allow-Methods = %s, want = POST", acam)
+		}
+		if acao := resp.Header.Get("Access-Control-Allow-Origin"); acao != "*" {
+			t.Errorf("Access-Control-Allow-Origin = %s, want = \"*\"", acao)
+		}
+	})
+}
+
+// uploadedResult returns the result of uploading `content` to `path` with the default checksums.
+func uploadedResult(path string, content []byte) SuccessfullyUploadedResult {
+	return SuccessfullyUploadedResult{OK: true, Path: path, Size: int64(len(content)), SHA256: fmt.Sprintf("%x", sha256.Sum256(content))}
+}
+
+// uploadedBody returns the JSON body of uploadedResult.
+func uploadedBody(path string, content []byte) string {
+	b, err := json.Marshal(uploadedResult(path, content))
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
 }
 
 func verifyLocalFile(t *testing.T, fs afero.Fs, path string, content []byte) {
@@ -1199,3 +1369,193 @@ func getAvailablePort() (int, error) {
 	defer listener.Close()
 	return listener.Addr().(*net.TCPAddr).Port, nil
 }
+
+func TestServer_Shutdown(t *testing.T) {
+	port, err := getAvailablePort()
+	if err != nil {
+		t.Fatalf("unable to find an available port: %v", err)
+	}
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	server := NewServer(ServerConfig{Addr: addr, MaxUploadSize: 16, ShutdownTimeout: 5000}, WithFileSystem(afero.NewMemMapFs()))
+	ready := make(chan struct{})
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Start(context.Background(), ready)
+	}()
+	<-ready
+
+	resp, err := http.Get("http://" + addr + "/files/missing.txt")
+	if err != nil {
+		t.Fatalf("server is not serving: %v", err)
+	}
+	resp.Body.Close()
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("Start() error = %v, want = %v", err, http.ErrServerClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start() did not return after Shutdown()")
+	}
+	if resp, err := http.Get("http://" + addr + "/files/missing.txt"); err == nil {
+		resp.Body.Close()
+		t.Errorf("server is still serving after Shutdown()")
+	}
+}
+
+func TestServer_TLS(t *testing.T) {
+	certFile, keyFile, certPool := writeSelfSignedCert(t)
+
+	t.Run("HTTPS GET", func(t *testing.T) {
+		port, err := getAvailablePort()
+		if err != nil {
+			t.Fatalf("unable to find an available port: %v", err)
+		}
+		addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+		fs := afero.NewMemMapFs()
+		if err := afero.WriteFile(fs, "/hello.txt", []byte("hello, tls"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config := ServerConfig{Addr: addr, MaxUploadSize: 16, ShutdownTimeout: 5000, TLSCertFile: certFile, TLSKeyFile: keyFile}
+		server := NewServer(config, WithFileSystem(fs))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ready := make(chan struct{})
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- server.Start(ctx, ready)
+		}()
+		<-ready
+
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: certPool}}}
+		resp, err := client.Get("https://" + addr + "/files/hello.txt")
+		if err != nil {
+			t.Fatalf("failed to GET: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read response body: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || string(body) != "hello, tls" {
+			t.Errorf("response = %d %q, want = 200 \"hello, tls\"", resp.StatusCode, body)
+		}
+		if resp.TLS == nil {
+			t.Errorf("response is not sent over TLS")
+		}
+
+		cancel()
+		select {
+		case err := <-stopped:
+			if !errors.Is(err, http.ErrServerClosed) {
+				t.Errorf("Start() error = %v, want = %v", err, http.ErrServerClosed)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Start() did not return after the context is canceled")
+		}
+	})
+
+	t.Run("key without certificate", func(t *testing.T) {
+		server := NewServer(ServerConfig{MaxUploadSize: 16, TLSKeyFile: keyFile}, WithFileSystem(afero.NewMemMapFs()))
+		if err := server.Start(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "tls_cert") {
+			t.Errorf("Start() error = %v, want tls_cert error", err)
+		}
+	})
+}
+
+// writeSelfSignedCert writes a self-signed certificate for 127.0.0.1 and its key to a temporary directory.
+func writeSelfSignedCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestServer_ChecksumTrailer(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	server := NewServer(ServerConfig{MaxUploadSize: 1024}, WithFileSystem(fs))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	content := "hello, trailer"
+	tests := []struct {
+		name       string
+		path       string
+		sum        string
+		wantStatus int
+	}{
+		{"valid", "/valid.txt", fmt.Sprintf("%x", sha256.Sum256([]byte(content))), http.StatusCreated},
+		{"uppercase", "/upper.txt", strings.ToUpper(fmt.Sprintf("%x", sha256.Sum256([]byte(content)))), http.StatusCreated},
+		{"invalid", "/invalid.txt", fmt.Sprintf("%x", sha256.Sum256([]byte("something else"))), http.StatusBadRequest},
+		{"missing", "/missing.txt", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// hide the length of the body so that it is sent in chunks with the trailer
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/upload", io.MultiReader(strings.NewReader(content)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.ContentLength = -1
+			req.Header.Set(UploadPathHeader, tt.path)
+			req.Trailer = http.Header{http.CanonicalHeaderKey(ChecksumTrailer): nil}
+			if tt.sum != "" {
+				req.Trailer.Set(ChecksumTrailer, tt.sum)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				body, _ := io.ReadAll(resp.Body)
+				t.Fatalf("status = %d, want = %d: %s", resp.StatusCode, tt.wantStatus, body)
+			}
+			exists, err := afero.Exists(fs, tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.wantStatus == http.StatusCreated; exists != want {
+				t.Errorf("file exists = %v, want = %v", exists, want)
+			}
+		})
+	}
+}
@@ -2,17 +2,32 @@ package simpleuploadserver
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 	"path"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestGetHandler(t *testing.T) {
@@ -62,6 +77,24 @@ func TestGetHandler(t *testing.T) {
 			want: http.StatusNotFound,
 			body: `{"ok":false,"error":"foo is a directory"}`,
 		},
+		{
+			name: "get existing file with trailing slash",
+			args: args{
+				Method: http.MethodGet,
+				Url:    "/files/foo/bar.txt/",
+			},
+			want: http.StatusOK,
+			body: "hello, world",
+		},
+		{
+			name: "get directory with trailing slash",
+			args: args{
+				Method: http.MethodGet,
+				Url:    "/files/foo/",
+			},
+			want: http.StatusNotFound,
+			body: `{"ok":false,"error":"foo is a directory"}`,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -77,7 +110,7 @@ func TestGetHandler(t *testing.T) {
 				DocumentRoot: "/opt/app",
 				EnableCORS:   true,
 			}
-			server := Server{config, afero.NewBasePathFs(fs, docRoot)}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
 			req, err := http.NewRequest(tt.args.Method, tt.args.Url, nil)
 			if err != nil {
 				t.Fatal(err)
@@ -97,6 +130,108 @@ func TestGetHandler(t *testing.T) {
 	}
 }
 
+func TestServer_GetWithIfModifiedSince(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	localPath := path.Join(docRoot, "foo.txt")
+	if err := afero.WriteFile(fs, localPath, []byte("hello, world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 678000000, time.UTC)
+	if err := fs.Chtimes(localPath, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	server := Server{ServerConfig: ServerConfig{DocumentRoot: docRoot}, fs: afero.NewBasePathFs(fs, docRoot)}
+
+	tests := []struct {
+		name            string
+		ifModifiedSince time.Time
+		want            int
+	}{
+		{"same second as mtime", mtime, http.StatusNotModified},
+		{"after mtime", mtime.Add(time.Second), http.StatusNotModified},
+		{"before mtime", mtime.Add(-time.Second), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/files/foo.txt", nil)
+			req.Header.Set("If-Modified-Since", tt.ifModifiedSince.Format(http.TimeFormat))
+			rr := httptest.NewRecorder()
+			server.handle(server.handleGet).ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+		})
+	}
+
+	t.Run("Last-Modified is truncated to seconds", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/files/foo.txt", nil)
+		rr := httptest.NewRecorder()
+		server.handle(server.handleGet).ServeHTTP(rr, req)
+		if lm, want := rr.Header().Get("Last-Modified"), mtime.Format(http.TimeFormat); lm != want {
+			t.Errorf("Last-Modified = %s, want = %s", lm, want)
+		}
+	})
+}
+
+func TestServer_GetWithMultipleRanges(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "alphabet.txt"), []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := Server{ServerConfig: ServerConfig{DocumentRoot: docRoot}, fs: afero.NewBasePathFs(fs, docRoot)}
+
+	req := httptest.NewRequest(http.MethodGet, "/files/alphabet.txt", nil)
+	req.Header.Set("Range", "bytes=0-2,10-12")
+	rr := httptest.NewRecorder()
+	server.handle(server.handleGet).ServeHTTP(rr, req)
+	if rr.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusPartialContent)
+	}
+	mediaType, params, err := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("failed to parse Content-Type: %v", err)
+	}
+	if mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type = %s, want = multipart/byteranges", mediaType)
+	}
+
+	want := []struct {
+		contentRange string
+		body         string
+	}{
+		{"bytes 0-2/26", "ABC"},
+		{"bytes 10-12/26", "KLM"},
+	}
+	mr := multipart.NewReader(rr.Body, params["boundary"])
+	for i := 0; ; i++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			if i != len(want) {
+				t.Errorf("number of parts = %d, want = %d", i, len(want))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read part: %v", err)
+		}
+		if i >= len(want) {
+			t.Fatalf("too many parts")
+		}
+		if cr := part.Header.Get("Content-Range"); cr != want[i].contentRange {
+			t.Errorf("part %d: Content-Range = %s, want = %s", i, cr, want[i].contentRange)
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("failed to read part body: %v", err)
+		}
+		if string(body) != want[i].body {
+			t.Errorf("part %d: body = %s, want = %s", i, body, want[i].body)
+		}
+	}
+}
+
 func TestServer_PostHandler(t *testing.T) {
 	docRoot := "/opt/app"
 	type args struct {
@@ -120,7 +255,7 @@ func TestServer_PostHandler(t *testing.T) {
 				Name:    "hello.txt",
 			},
 			want: http.StatusCreated,
-			body: `{"ok":true,"path":"/files/hello.txt"}`,
+			body: uploadedBody("/files/hello.txt", []byte("hello, world")),
 		},
 		{
 			name: "Post nothing",
@@ -131,7 +266,7 @@ func TestServer_PostHandler(t *testing.T) {
 				Name:    "empty",
 			},
 			want: http.StatusCreated,
-			body: `{"ok":true,"path":"/files/empty"}`,
+			body: uploadedBody("/files/empty", []byte{}),
 		},
 		{
 			name: "Post the existing file should be rejected",
@@ -153,7 +288,7 @@ func TestServer_PostHandler(t *testing.T) {
 				Name:    "ow.txt",
 			},
 			want: http.StatusCreated,
-			body: `{"ok":true,"path":"/files/ow.txt"}`,
+			body: uploadedBody("/files/ow.txt", []byte("overwritten!")),
 		},
 		{
 			name: "POST large file should fail",
@@ -164,7 +299,7 @@ func TestServer_PostHandler(t *testing.T) {
 				Name:    "toolarge",
 			},
 			want: http.StatusRequestEntityTooLarge,
-			body: `{"ok":false,"error":"file size limit exceeded"}`,
+			body: `{"ok":false,"error":"file size limit exceeded","max_bytes":16}`,
 		},
 		// TODO: add text without name
 	}
@@ -182,7 +317,7 @@ func TestServer_PostHandler(t *testing.T) {
 				EnableCORS:    true,
 				MaxUploadSize: 16,
 			}
-			server := Server{config, afero.NewBasePathFs(fs, docRoot)}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
 
 			b := new(bytes.Buffer)
 			w := multipart.NewWriter(b)
@@ -245,6 +380,211 @@ func TestServer_PostHandler(t *testing.T) {
 	}
 }
 
+func TestServer_PostRawBody(t *testing.T) {
+	docRoot := "/opt/app"
+	content := []byte("hello, world")
+	tests := []struct {
+		name     string
+		strategy string
+		wantName *regexp.Regexp
+	}{
+		{"uuid", "uuid", regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\.txt$`)},
+		{"sha256", "sha256", regexp.MustCompile(fmt.Sprintf(`^%x\.txt$`, sha256.Sum256(content)))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			config := ServerConfig{
+				DocumentRoot:       docRoot,
+				MaxUploadSize:      16,
+				FileNamingStrategy: tt.strategy,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+			req := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(content))
+			req.Header.Set("Content-Type", "application/octet-stream")
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePost).ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+			}
+			var result SuccessfullyUploadedResult
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			name := strings.TrimPrefix(result.Path, "/files/")
+			if !tt.wantName.MatchString(name) {
+				t.Errorf("name = %s, want to match %s", name, tt.wantName)
+			}
+			verifyLocalFile(t, fs, path.Join(docRoot, name), content)
+		})
+	}
+
+	t.Run("too large", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		server := Server{ServerConfig: ServerConfig{DocumentRoot: docRoot, MaxUploadSize: 4}, fs: afero.NewBasePathFs(fs, docRoot)}
+		req := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(content))
+		rr := httptest.NewRecorder()
+		server.handle(server.handlePost).ServeHTTP(rr, req)
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusRequestEntityTooLarge)
+		}
+	})
+}
+
+func TestServer_PutRawBody(t *testing.T) {
+	docRoot := "/opt/app"
+	content := []byte{0x00, 0x01, 0x02, 0xfe, 0xff, 'r', 'a', 'w'}
+	tests := []struct {
+		name        string
+		contentType string
+	}{
+		{"octet-stream", "application/octet-stream"},
+		{"no content type", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			server := Server{ServerConfig: ServerConfig{DocumentRoot: docRoot, MaxUploadSize: 16}, fs: afero.NewBasePathFs(fs, docRoot)}
+			req := httptest.NewRequest(http.MethodPut, "/files/raw.bin", bytes.NewReader(content))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rr := httptest.NewRecorder()
+			server.router().ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want = %d: %s", rr.Code, http.StatusCreated, rr.Body.String())
+			}
+			if body := rr.Body.String(); body != uploadedBody("/files/raw.bin", content) {
+				t.Errorf("body = %s, want = %s", body, uploadedBody("/files/raw.bin", content))
+			}
+			verifyLocalFile(t, fs, path.Join(docRoot, "raw.bin"), content)
+		})
+	}
+
+	t.Run("too large", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		server := Server{ServerConfig: ServerConfig{DocumentRoot: docRoot, MaxUploadSize: 4}, fs: afero.NewBasePathFs(fs, docRoot)}
+		req := httptest.NewRequest(http.MethodPut, "/files/raw.bin", bytes.NewReader(content))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		rr := httptest.NewRecorder()
+		server.router().ServeHTTP(rr, req)
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusRequestEntityTooLarge)
+		}
+		if exists, _ := afero.Exists(fs, path.Join(docRoot, "raw.bin")); exists {
+			t.Errorf("the file should not be stored")
+		}
+	})
+
+	t.Run("existing file", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		if err := afero.WriteFile(fs, path.Join(docRoot, "raw.bin"), []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		server := Server{ServerConfig: ServerConfig{DocumentRoot: docRoot, MaxUploadSize: 16}, fs: afero.NewBasePathFs(fs, docRoot)}
+		req := httptest.NewRequest(http.MethodPut, "/files/raw.bin", bytes.NewReader(content))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		rr := httptest.NewRecorder()
+		server.router().ServeHTTP(rr, req)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusConflict)
+		}
+		verifyLocalFile(t, fs, path.Join(docRoot, "raw.bin"), []byte("old"))
+	})
+
+	t.Run("the body is written while it is received", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		server := Server{ServerConfig: ServerConfig{DocumentRoot: docRoot, MaxUploadSize: 16}, fs: afero.NewBasePathFs(fs, docRoot)}
+		pr, pw := io.Pipe()
+		req := httptest.NewRequest(http.MethodPut, "/files/raw.bin", pr)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		rr := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			server.router().ServeHTTP(rr, req)
+		}()
+		pw.Write([]byte("hello, "))
+		// the rest is sent after the first half is written, which never happens if the body is spooled
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			b, _ := afero.ReadFile(fs, path.Join(docRoot, "raw.bin"+UploadingFileSuffix))
+			if string(b) == "hello, " {
+				break
+			}
+			if time.Now().After(deadline) {
+				pw.CloseWithError(errors.New("timed out"))
+				<-done
+				t.Fatalf("the destination is not written before the end of the body")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		pw.Write([]byte("world"))
+		pw.Close()
+		<-done
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want = %d: %s", rr.Code, http.StatusCreated, rr.Body.String())
+		}
+		verifyLocalFile(t, fs, path.Join(docRoot, "raw.bin"), []byte("hello, world"))
+	})
+}
+
+func TestServer_IfNewer(t *testing.T) {
+	docRoot := "/opt/app"
+	stored := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		existing bool
+		ifNewer  string
+		want     int
+		content  string
+		modTime  time.Time
+	}{
+		{"older", true, "2024-01-01T11:00:00Z", http.StatusNotModified, "old", stored},
+		{"same time", true, "2024-01-01T12:00:00Z", http.StatusNotModified, "old", stored},
+		{"newer", true, "2024-01-01T13:00:00Z", http.StatusCreated, "new", time.Date(2024, 1, 1, 13, 0, 0, 0, time.UTC)},
+		{"no existing file", false, "2024-01-01T11:00:00Z", http.StatusCreated, "new", time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"invalid time", true, "yesterday", http.StatusBadRequest, "old", stored},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			localPath := path.Join(docRoot, "sync.txt")
+			if tt.existing {
+				if err := afero.WriteFile(fs, localPath, []byte("old"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := fs.Chtimes(localPath, stored, stored); err != nil {
+					t.Fatal(err)
+				}
+			}
+			server := Server{ServerConfig: ServerConfig{DocumentRoot: docRoot, MaxUploadSize: 16}, fs: afero.NewBasePathFs(fs, docRoot)}
+			q := url.Values{}
+			q.Set(IfNewerQueryKey, tt.ifNewer)
+			req, err := makeFormRequest(&url.URL{Path: "/files/sync.txt", RawQuery: q.Encode()}, http.MethodPut, "sync.txt", strings.NewReader("new"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePut).ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Fatalf("status = %d, want = %d: %s", rr.Code, tt.want, rr.Body.String())
+			}
+			if tt.want == http.StatusNotModified && rr.Body.Len() != 0 {
+				t.Errorf("body = %s, want empty", rr.Body.String())
+			}
+			verifyLocalFile(t, fs, localPath, []byte(tt.content))
+			fi, err := fs.Stat(localPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !fi.ModTime().Equal(tt.modTime) {
+				t.Errorf("mod time = %v, want = %v", fi.ModTime(), tt.modTime)
+			}
+		})
+	}
+}
+
 func TestServer_PutHandler(t *testing.T) {
 	docRoot := "/opt/app"
 	type args struct {
@@ -268,7 +608,7 @@ func TestServer_PutHandler(t *testing.T) {
 				Name:    "hello.txt",
 			},
 			want: http.StatusCreated,
-			body: `{"ok":true,"path":"/files/hello.txt"}`,
+			body: uploadedBody("/files/hello.txt", []byte("hello, world")),
 		},
 		{
 			name: "PUT /files/empty with an empty content",
@@ -279,7 +619,7 @@ func TestServer_PutHandler(t *testing.T) {
 				Name:    "empty",
 			},
 			want: http.StatusCreated,
-			body: `{"ok":true,"path":"/files/empty"}`,
+			body: uploadedBody("/files/empty", []byte{}),
 		},
 		{
 			name: "PUT /files/hello/world.txt will create directory and file",
@@ -290,7 +630,7 @@ func TestServer_PutHandler(t *testing.T) {
 				Name:    "world.txt",
 			},
 			want: http.StatusCreated,
-			body: `{"ok":true,"path":"/files/hello/world.txt"}`,
+			body: uploadedBody("/files/hello/world.txt", []byte("hello, world")),
 		},
 		{
 			name: "PUT /files/ should fail",
@@ -300,8 +640,8 @@ func TestServer_PutHandler(t *testing.T) {
 				Content: []byte("hello"),
 				Name:    "hello",
 			},
-			want: http.StatusMethodNotAllowed,
-			body: `{"ok":false,"error":"PUT is accepted on /files/:name"}`,
+			want: http.StatusBadRequest,
+			body: `{"ok":false,"error":"no file name is specified; PUT is accepted on /files/:name"}`,
 		},
 		{
 			name: "PUT large file should fail",
@@ -312,7 +652,7 @@ func TestServer_PutHandler(t *testing.T) {
 				Name:    "toolarge",
 			},
 			want: http.StatusRequestEntityTooLarge,
-			body: `{"ok":false,"error":"file size limit exceeded"}`,
+			body: `{"ok":false,"error":"file size limit exceeded","max_bytes":16}`,
 		},
 		// TODO: add text without name
 	}
@@ -327,7 +667,7 @@ func TestServer_PutHandler(t *testing.T) {
 				EnableCORS:    true,
 				MaxUploadSize: 16,
 			}
-			server := Server{config, afero.NewBasePathFs(fs, docRoot)}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
 
 			b := new(bytes.Buffer)
 			w := multipart.NewWriter(b)
@@ -390,6 +730,526 @@ func TestServer_PutHandler(t *testing.T) {
 	}
 }
 
+func TestServer_ProxyUpload(t *testing.T) {
+	var gotMethod, gotPath string
+	var gotBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotPath = r.URL.Path
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("upstream failed to read body: %v", err)
+		}
+		gotBody = b
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:   docRoot,
+		MaxUploadSize:  16,
+		ProxyUploadURL: upstream.URL + "/store",
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+
+	b := new(bytes.Buffer)
+	w := multipart.NewWriter(b)
+	fw, err := w.CreateFormFile("file", "hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("hello, world")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	req, err := http.NewRequest(http.MethodPost, "/upload", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(server.handle(server.handlePost))
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("status = %d, want = %d", status, http.StatusCreated)
+	}
+	if body, want := rr.Body.String(), uploadedBody("/files/hello.txt", []byte("hello, world")); body != want {
+		t.Errorf("body = \"%s\", want = \"%s\"", body, want)
+	}
+	if gotMethod != http.MethodPut {
+		t.Errorf("upstream method = %s, want = %s", gotMethod, http.MethodPut)
+	}
+	if gotPath != "/store/hello.txt" {
+		t.Errorf("upstream path = %s, want = /store/hello.txt", gotPath)
+	}
+	if string(gotBody) != "hello, world" {
+		t.Errorf("upstream body = %s, want = hello, world", gotBody)
+	}
+	if exists, _ := afero.Exists(fs, path.Join(docRoot, "hello.txt")); exists {
+		t.Errorf("file should not be stored locally in proxy mode")
+	}
+}
+
+func TestServer_CaseInsensitiveNames(t *testing.T) {
+	tests := []struct {
+		name                 string
+		caseInsensitiveNames bool
+		want                 int
+	}{
+		{"enabled", true, http.StatusConflict},
+		{"disabled", false, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRoot := "/opt/app"
+			fs := afero.NewMemMapFs()
+			config := ServerConfig{
+				DocumentRoot:         docRoot,
+				MaxUploadSize:        16,
+				CaseInsensitiveNames: tt.caseInsensitiveNames,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+			handler := http.HandlerFunc(server.handle(server.handlePost))
+
+			for i, name := range []string{"Foo.txt", "foo.txt"} {
+				req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, name, strings.NewReader("hello"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				want := http.StatusCreated
+				if i > 0 {
+					want = tt.want
+				}
+				if rr.Code != want {
+					t.Errorf("POST %s: status = %d, want = %d", name, rr.Code, want)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_CaseInsensitiveNames_Overwrite(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:         docRoot,
+		MaxUploadSize:        1024,
+		CaseInsensitiveNames: true,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	handler := server.router()
+
+	for _, target := range []string{"/files/Foo.txt", "/files/foo.txt?overwrite=true"} {
+		req := httptest.NewRequest(http.MethodPut, target, strings.NewReader(target))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("PUT %s: status = %d, want = %d", target, rr.Code, http.StatusCreated)
+		}
+	}
+
+	// the existing file is overwritten instead of creating another one differing only in case
+	verifyLocalFile(t, fs, path.Join(docRoot, "Foo.txt"), []byte("/files/foo.txt?overwrite=true"))
+	if exists, _ := afero.Exists(fs, path.Join(docRoot, "foo.txt")); exists {
+		t.Errorf("foo.txt should not be created")
+	}
+}
+
+func TestServer_UnwritableTempDir(t *testing.T) {
+	if v, ok := os.LookupEnv("TEST_WITH_REAL_FS"); !ok || v == "" {
+		t.Skip("TEST_WITH_REAL_FS is not set")
+	}
+	// a regular file cannot be used as a directory even by root
+	notDir := filepath.Join(t.TempDir(), "not-a-directory")
+	if err := os.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TMPDIR", "")
+
+	t.Run("Start fails fast", func(t *testing.T) {
+		server := Server{ServerConfig: ServerConfig{MultipartTempDir: notDir}, fs: afero.NewMemMapFs()}
+		if err := server.Start(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "is not writable") {
+			t.Errorf("Start() error = %v, want not writable error", err)
+		}
+	})
+
+	t.Run("upload reports a clear error", func(t *testing.T) {
+		t.Setenv("TMPDIR", notDir)
+		docRoot := "/opt/app"
+		config := ServerConfig{
+			DocumentRoot:  docRoot,
+			MaxUploadSize: 64 << 20,
+			// the content type is sniffed for the limit, so the content is spooled instead of streamed
+			MaxUploadSizeByType: map[string]int64{"image/": 64 << 20},
+		}
+		server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+		// larger than the default in-memory threshold of the multipart parser (32 MB)
+		content := bytes.Repeat([]byte("a"), 33<<20)
+		req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "large.bin", bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		server.handle(server.handlePost).ServeHTTP(rr, req)
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusInternalServerError)
+		}
+		if body, want := rr.Body.String(), `{"ok":false,"error":"cannot store the uploaded content in the temporary directory"}`; body != want {
+			t.Errorf("body = %s, want = %s", body, want)
+		}
+	})
+}
+
+func TestServer_UnusableDocumentRoot(t *testing.T) {
+	if v, ok := os.LookupEnv("TEST_WITH_REAL_FS"); !ok || v == "" {
+		t.Skip("TEST_WITH_REAL_FS is not set")
+	}
+	notDir := filepath.Join(t.TempDir(), "not-a-directory")
+	if err := os.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		docRoot string
+	}{
+		{"not exist", filepath.Join(t.TempDir(), "missing")},
+		{"not a directory", notDir},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer(ServerConfig{DocumentRoot: tt.docRoot})
+			if err := server.Start(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "document root") {
+				t.Errorf("Start() error = %v, want document root error", err)
+			}
+		})
+	}
+}
+
+func TestServer_MultipartMaxMemory(t *testing.T) {
+	docRoot := "/opt/app"
+	newServer := func() (*Server, afero.Fs) {
+		fs := afero.NewMemMapFs()
+		config := ServerConfig{
+			DocumentRoot:       docRoot,
+			MaxUploadSize:      1024,
+			MultipartMaxMemory: 1,
+			// the content type is sniffed for the limit, so the content is spooled instead of streamed
+			MaxUploadSizeByType: map[string]int64{"image/": 1024},
+		}
+		return &Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}, fs
+	}
+	content := []byte("hello, world")
+
+	t.Run("upload succeeds with spilling to a temp file", func(t *testing.T) {
+		server, fs := newServer()
+		req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "hello.txt", bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		server.handle(server.handlePost).ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+		}
+		verifyLocalFile(t, fs, path.Join(docRoot, "hello.txt"), content)
+	})
+
+	t.Run("small content is not kept in memory", func(t *testing.T) {
+		// a regular file cannot be used as a directory, so spilling to the temp directory fails
+		notDir := filepath.Join(t.TempDir(), "not-a-directory")
+		if err := os.WriteFile(notDir, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("TMPDIR", notDir)
+		server, _ := newServer()
+		req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "hello.txt", bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		server.handle(server.handlePost).ServeHTTP(rr, req)
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusInternalServerError)
+		}
+	})
+}
+
+func TestServer_MultipartTempDir(t *testing.T) {
+	docRoot := "/opt/app"
+	newServer := func(tempDir string) (*Server, afero.Fs) {
+		fs := afero.NewMemMapFs()
+		config := ServerConfig{
+			DocumentRoot:       docRoot,
+			MaxUploadSize:      1024,
+			MultipartMaxMemory: 1,
+			MultipartTempDir:   tempDir,
+			// the content type is sniffed for the limit, so the content is spooled instead of streamed
+			MaxUploadSizeByType: map[string]int64{"image/": 1024},
+		}
+		return &Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}, fs
+	}
+	content := []byte("hello, world")
+
+	t.Run("contents spill to the directory", func(t *testing.T) {
+		tempDir := t.TempDir()
+		server, fs := newServer(tempDir)
+		req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "hello.txt", bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+		}
+		verifyLocalFile(t, fs, path.Join(docRoot, "hello.txt"), content)
+		// the temp file is removed after the upload
+		if entries, err := os.ReadDir(tempDir); err != nil || len(entries) != 0 {
+			t.Errorf("temp dir entries = %v (err = %v), want empty", entries, err)
+		}
+	})
+
+	t.Run("the handler uses the directory without Start", func(t *testing.T) {
+		// a regular file cannot be used as a directory, so spilling to the temp directory fails
+		notDir := filepath.Join(t.TempDir(), "not-a-directory")
+		if err := os.WriteFile(notDir, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		server, _ := newServer(notDir)
+		req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "hello.txt", bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rr, req)
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusInternalServerError)
+		}
+		if body, want := rr.Body.String(), `{"ok":false,"error":"cannot store the uploaded content in the temporary directory"}`; body != want {
+			t.Errorf("body = %s, want = %s", body, want)
+		}
+		if v := os.Getenv("TMPDIR"); v == notDir {
+			t.Errorf("TMPDIR = %s, should not be changed", v)
+		}
+	})
+}
+
+func TestServer_MultipartStreaming(t *testing.T) {
+	docRoot := "/opt/app"
+	newServer := func() (*Server, afero.Fs) {
+		fs := afero.NewMemMapFs()
+		config := ServerConfig{
+			DocumentRoot:  docRoot,
+			MaxUploadSize: 16,
+		}
+		return &Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}, fs
+	}
+
+	t.Run("other parts are skipped", func(t *testing.T) {
+		server, fs := newServer()
+		b := new(bytes.Buffer)
+		mw := multipart.NewWriter(b)
+		if err := mw.WriteField("comment", "not a file"); err != nil {
+			t.Fatal(err)
+		}
+		fw, err := mw.CreateFormFile(FormFileKey, "hello.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte("hello"))
+		mw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/upload", b)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rr := httptest.NewRecorder()
+		server.handle(server.handlePost).ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+		}
+		verifyLocalFile(t, fs, path.Join(docRoot, "hello.txt"), []byte("hello"))
+	})
+
+	t.Run("the part is written while it is received", func(t *testing.T) {
+		server, fs := newServer()
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		req := httptest.NewRequest(http.MethodPost, "/upload", pr)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rr := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			server.handle(server.handlePost).ServeHTTP(rr, req)
+		}()
+		fw, err := mw.CreateFormFile(FormFileKey, "hello.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte("hello, "))
+		// the rest is sent after the first half is written to the destination
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			b, _ := afero.ReadFile(fs, path.Join(docRoot, "hello.txt"+UploadingFileSuffix))
+			if string(b) == "hello, " {
+				break
+			}
+			if time.Now().After(deadline) {
+				pw.CloseWithError(errors.New("timed out"))
+				<-done
+				t.Fatalf("the destination is not written before the end of the part")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		// the incomplete content is not seen at the destination
+		if exists, _ := afero.Exists(fs, path.Join(docRoot, "hello.txt")); exists {
+			t.Errorf("the destination exists before the end of the part")
+		}
+		fw.Write([]byte("world"))
+		mw.Close()
+		pw.Close()
+		<-done
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+		}
+		verifyLocalFile(t, fs, path.Join(docRoot, "hello.txt"), []byte("hello, world"))
+		if exists, _ := afero.Exists(fs, path.Join(docRoot, "hello.txt"+UploadingFileSuffix)); exists {
+			t.Errorf("the temporary file is left")
+		}
+	})
+
+	t.Run("reading stops at the size limit", func(t *testing.T) {
+		server, fs := newServer()
+		// the content is much larger than the limit, so the request body must not be read to the end
+		content := &countingReader{r: io.LimitReader(zeroReader{}, 64<<20)}
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		go func() {
+			fw, err := mw.CreateFormFile(FormFileKey, "large.bin")
+			if err == nil {
+				_, err = io.Copy(fw, content)
+			}
+			if err == nil {
+				err = mw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		req := httptest.NewRequest(http.MethodPost, "/upload", pr)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rr := httptest.NewRecorder()
+		server.handle(server.handlePost).ServeHTTP(rr, req)
+		pr.Close()
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusRequestEntityTooLarge)
+		}
+		if body, want := rr.Body.String(), `{"ok":false,"error":"file size limit exceeded","max_bytes":16}`; body != want {
+			t.Errorf("body = %s, want = %s", body, want)
+		}
+		if n := content.Count(); n >= 64<<20 {
+			t.Errorf("read %d bytes, want less than the whole content", n)
+		}
+		if exists, err := afero.Exists(fs, path.Join(docRoot, "large.bin")); err != nil || exists {
+			t.Errorf("the partially written file should be removed (exists = %v, err = %v)", exists, err)
+		}
+	})
+
+	t.Run("the existing file is kept if the upload fails", func(t *testing.T) {
+		server, fs := newServer()
+		if err := afero.WriteFile(fs, path.Join(docRoot, "keep.txt"), []byte("existing"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		req, err := makeFormRequest(&url.URL{Path: "/files/keep.txt", RawQuery: "overwrite=true"}, http.MethodPut, "keep.txt", bytes.NewReader(bytes.Repeat([]byte("a"), 32)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		server.handle(server.handlePut).ServeHTTP(rr, req)
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusRequestEntityTooLarge)
+		}
+		verifyLocalFile(t, fs, path.Join(docRoot, "keep.txt"), []byte("existing"))
+		if exists, _ := afero.Exists(fs, path.Join(docRoot, "keep.txt"+UploadingFileSuffix)); exists {
+			t.Errorf("the temporary file is left")
+		}
+	})
+}
+
+func TestServer_FormFieldName(t *testing.T) {
+	docRoot := "/opt/app"
+	tests := []struct {
+		name      string
+		fieldName string
+		formKey   string
+		want      int
+	}{
+		{"default", "", "file", http.StatusCreated},
+		{"custom", "upload", "upload", http.StatusCreated},
+		{"default key with custom name", "upload", "file", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			config := ServerConfig{
+				DocumentRoot:  docRoot,
+				MaxUploadSize: 16,
+				FormFieldName: tt.fieldName,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+			b := new(bytes.Buffer)
+			mw := multipart.NewWriter(b)
+			fw, err := mw.CreateFormFile(tt.formKey, "hello.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fw.Write([]byte("hello"))
+			mw.Close()
+			req := httptest.NewRequest(http.MethodPost, "/upload", b)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePost).ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Fatalf("status = %d, want = %d", rr.Code, tt.want)
+			}
+			if tt.want == http.StatusCreated {
+				verifyLocalFile(t, fs, path.Join(docRoot, "hello.txt"), []byte("hello"))
+			}
+		})
+	}
+}
+
+// zeroReader is an endless source of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r  io.Reader
+	mu sync.Mutex
+	n  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.mu.Lock()
+	c.n += int64(n)
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *countingReader) Count() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
 func Test_getFileSize(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -446,3 +1306,2054 @@ func Test_parseBoolishValue(t *testing.T) {
 		})
 	}
 }
+
+func Test_tokenTable(t *testing.T) {
+	tokens := newTokenTable(ServerConfig{ReadWriteTokens: []string{"read-write-token", "another-token"}})
+	tests := []struct {
+		token string
+		want  bool
+	}{
+		{"read-write-token", true},
+		{"another-token", true},
+		{"read-write", false},
+		{"read-write-token2", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			if got := tokens.Resolve(tt.token).valid(); got != tt.want {
+				t.Errorf("Resolve(%q).valid() = %v, want = %v", tt.token, got, tt.want)
+			}
+		})
+	}
+	if newTokenTable(ServerConfig{}).Resolve("read-write-token").valid() {
+		t.Errorf("Resolve() of no tokens is valid, want = invalid")
+	}
+}
+
+func Test_tokenTable_Hashed(t *testing.T) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte("hashed-token"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// $2b$ hash of "hashed-token-2b"
+	hashed2b := "$2b$04$abcdefghijklmnopqrstuu1SiqC8rcJ0s1QE9TRcxv9OngP3IP/Qa"
+	tokens := newTokenTable(ServerConfig{ReadWriteTokens: []string{string(hashed), "plain-token", hashed2b}})
+	tests := []struct {
+		token string
+		want  bool
+	}{
+		{"hashed-token", true},
+		{"hashed-token-2b", true},
+		{"plain-token", true},
+		{"hashed-token2", false},
+		// the hash itself is not a token
+		{string(hashed), false},
+		{hashed2b, false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			if got := tokens.Resolve(tt.token).valid(); got != tt.want {
+				t.Errorf("Resolve(%q).valid() = %v, want = %v", tt.token, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_HashedTokens_ComparedOnce(t *testing.T) {
+	counts := map[string]int{}
+	origCompareHash := compareHash
+	compareHash = func(hashed, password []byte) error {
+		counts[string(hashed)]++
+		return origCompareHash(hashed, password)
+	}
+	defer func() { compareHash = origCompareHash }()
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte("hashed-token"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// $2b$ hash of "hashed-token-2b"
+	hashed2b := "$2b$04$abcdefghijklmnopqrstuu1SiqC8rcJ0s1QE9TRcxv9OngP3IP/Qa"
+	docRoot := "/opt/app"
+	config := ServerConfig{
+		DocumentRoot:    docRoot,
+		EnableAuth:      true,
+		ReadOnlyTokens:  []string{hashed2b},
+		ReadWriteTokens: []string{string(hashed), hashed2b},
+		NamedTokens:     map[string]string{"alice": string(hashed)},
+		AdminTokens:     []string{string(hashed)},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+	router := server.router()
+
+	tests := []struct {
+		token string
+		body  string
+	}{
+		{"hashed-token", `{"ok":true,"scope":"admin","name":"alice"}`},
+		{"hashed-token-2b", `{"ok":true,"scope":"read-write"}`},
+		{"garbage", `{"ok":false,"error":"unauthorized"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				clear(counts)
+				req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+				rr := httptest.NewRecorder()
+				router.ServeHTTP(rr, req)
+				if body := rr.Body.String(); body != tt.body {
+					t.Errorf("whoami body = %s, want = %s", body, tt.body)
+				}
+				for hash, n := range counts {
+					if n > 1 {
+						t.Errorf("request %d compared %s %d times, want at most once", i, hash, n)
+					}
+					if i > 0 {
+						t.Errorf("request %d compared %s again, want to be cached", i, hash)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestServer_TokenCacheSize(t *testing.T) {
+	origSize := TokenCacheSize
+	TokenCacheSize = 2
+	defer func() { TokenCacheSize = origSize }()
+
+	tokens := newTokenTable(ServerConfig{ReadWriteTokens: []string{"read-write-token"}})
+	for _, token := range []string{"a", "b", "c", "read-write-token", "d"} {
+		tokens.Resolve(token)
+		if n := len(tokens.cache); n > TokenCacheSize {
+			t.Errorf("cache has %d entries after %q, want at most %d", n, token, TokenCacheSize)
+		}
+	}
+	if !tokens.Resolve("read-write-token").valid() {
+		t.Errorf("read-write-token is invalid after the cache is full")
+	}
+}
+
+func TestServer_HashedTokens(t *testing.T) {
+	hash := func(token string) string {
+		b, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	docRoot := "/opt/app"
+	config := ServerConfig{
+		DocumentRoot:    docRoot,
+		MaxUploadSize:   16,
+		EnableAuth:      true,
+		ReadOnlyTokens:  []string{hash("ro-hashed"), "ro-plain"},
+		ReadWriteTokens: []string{"rw-plain", hash("rw-hashed")},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+	router := server.router()
+
+	tests := []struct {
+		token     string
+		scope     string
+		putStatus int
+	}{
+		{"ro-hashed", `{"ok":true,"scope":"read-only"}`, http.StatusUnauthorized},
+		{"ro-plain", `{"ok":true,"scope":"read-only"}`, http.StatusUnauthorized},
+		{"rw-hashed", `{"ok":true,"scope":"read-write"}`, http.StatusCreated},
+		{"rw-plain", `{"ok":true,"scope":"read-write"}`, http.StatusCreated},
+		{config.ReadWriteTokens[1], `{"ok":false,"error":"unauthorized"}`, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if body := rr.Body.String(); body != tt.scope {
+				t.Errorf("whoami body = %s, want = %s", body, tt.scope)
+			}
+
+			req = httptest.NewRequest(http.MethodPut, "/files/"+url.PathEscape(tt.token)+".txt", strings.NewReader("hello"))
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tt.putStatus {
+				t.Errorf("PUT status = %d, want = %d", rr.Code, tt.putStatus)
+			}
+		})
+	}
+}
+
+func TestServer_SingleFileMode(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "dist", "config.json"), []byte(`{"version":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig{
+		DocumentRoot:   docRoot,
+		SingleFileMode: "dist/config.json",
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	router := server.router()
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		want   int
+		body   string
+	}{
+		{"GET /", http.MethodGet, "/", http.StatusOK, `{"version":1}`},
+		{"HEAD /", http.MethodHead, "/", http.StatusOK, ""},
+		{"GET /files is disabled", http.MethodGet, "/files/dist/config.json", http.StatusNotFound, `{"ok":false,"error":"not found"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+			if body := rr.Body.String(); body != tt.body {
+				t.Errorf("body = %s, want = %s", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestServer_RootRedirect(t *testing.T) {
+	tests := []struct {
+		name         string
+		rootRedirect string
+		want         int
+		wantLocation string
+	}{
+		{"redirect to the listing", "/files/", http.StatusFound, "/files/"},
+		{"redirect to a URL", "https://example.com/ui/", http.StatusFound, "https://example.com/ui/"},
+		{"not configured", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := Server{ServerConfig: ServerConfig{RootRedirect: tt.rootRedirect}, fs: afero.NewMemMapFs()}
+			rr := httptest.NewRecorder()
+			server.router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+			if got := rr.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want = %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+// flakyFs is an afero.Fs whose MkdirAll fails for the first `failures` calls.
+type flakyFs struct {
+	afero.Fs
+	failures int
+	calls    int
+}
+
+func (fs *flakyFs) MkdirAll(path string, perm os.FileMode) error {
+	fs.calls++
+	if fs.calls <= fs.failures {
+		return fmt.Errorf("transient error")
+	}
+	return fs.Fs.MkdirAll(path, perm)
+}
+
+func TestServer_WriteRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		writeRetries int
+		want         int
+	}{
+		{"without retries", 0, http.StatusInternalServerError},
+		{"with retries", 2, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRoot := "/opt/app"
+			fs := &flakyFs{Fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot), failures: 1}
+			config := ServerConfig{
+				DocumentRoot:  docRoot,
+				MaxUploadSize: 16,
+				WriteRetries:  tt.writeRetries,
+			}
+			server := Server{ServerConfig: config, fs: fs}
+			req, err := makeFormRequest(&url.URL{Path: "/files/foo/bar.txt"}, http.MethodPut, "bar.txt", strings.NewReader("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePut).ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+		})
+	}
+}
+
+// fullDiskFs is an afero.Fs whose files fail with ENOSPC after `capacity` bytes are written.
+type fullDiskFs struct {
+	afero.Fs
+	capacity int
+}
+
+func (fs *fullDiskFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &fullDiskFile{File: f, remaining: fs.capacity}, nil
+}
+
+type fullDiskFile struct {
+	afero.File
+	remaining int
+}
+
+func (f *fullDiskFile) Write(p []byte) (int, error) {
+	if len(p) > f.remaining {
+		n, _ := f.File.Write(p[:f.remaining])
+		f.remaining = 0
+		return n, &os.PathError{Op: "write", Path: f.Name(), Err: syscall.ENOSPC}
+	}
+	f.remaining -= len(p)
+	return f.File.Write(p)
+}
+
+func TestServer_DiskFull(t *testing.T) {
+	docRoot := "/opt/app"
+	memFs := afero.NewMemMapFs()
+	fs := &fullDiskFs{Fs: afero.NewBasePathFs(memFs, docRoot), capacity: 4}
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+	}
+	server := Server{ServerConfig: config, fs: fs}
+	req, err := makeFormRequest(&url.URL{Path: "/files/hello.txt"}, http.MethodPut, "hello.txt", strings.NewReader("hello, world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.handle(server.handlePut).ServeHTTP(rr, req)
+	if rr.Code != http.StatusInsufficientStorage {
+		t.Errorf("status = %d, want = %d", rr.Code, http.StatusInsufficientStorage)
+	}
+	if body, want := rr.Body.String(), `{"ok":false,"error":"insufficient storage"}`; body != want {
+		t.Errorf("body = %s, want = %s", body, want)
+	}
+	if exists, _ := afero.Exists(memFs, path.Join(docRoot, "hello.txt")); exists {
+		t.Errorf("partially written file should be removed")
+	}
+}
+
+func TestServer_Favicon(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ServerConfig
+	}{
+		{"default", ServerConfig{}},
+		{"with auth", ServerConfig{EnableAuth: true, ReadOnlyTokens: []string{"token"}}},
+		{"single file mode", ServerConfig{SingleFileMode: "config.json"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := Server{ServerConfig: tt.config, fs: afero.NewMemMapFs()}
+			req := httptest.NewRequest(http.MethodGet, "/favicon.ico", nil)
+			rr := httptest.NewRecorder()
+			server.router().ServeHTTP(rr, req)
+			if rr.Code != http.StatusNoContent {
+				t.Errorf("status = %d, want = %d", rr.Code, http.StatusNoContent)
+			}
+			if rr.Body.Len() != 0 {
+				t.Errorf("body = %s, want empty", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestServer_ConditionalPut(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		existing bool
+		want     int
+		content  string
+	}{
+		{"If-None-Match: * creates a new file", "If-None-Match", "*", false, http.StatusCreated, "new"},
+		{"If-None-Match: * fails on an existing file", "If-None-Match", "*", true, http.StatusPreconditionFailed, "old"},
+		{"If-Match: * updates an existing file", "If-Match", "*", true, http.StatusCreated, "new"},
+		{"If-Match: * fails on a missing file", "If-Match", "*", false, http.StatusPreconditionFailed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRoot := "/opt/app"
+			fs := afero.NewMemMapFs()
+			if tt.existing {
+				if err := afero.WriteFile(fs, path.Join(docRoot, "test.txt"), []byte("old"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			config := ServerConfig{
+				DocumentRoot:  docRoot,
+				MaxUploadSize: 16,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+			req, err := makeFormRequest(&url.URL{Path: "/files/test.txt"}, http.MethodPut, "test.txt", strings.NewReader("new"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set(tt.header, tt.value)
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePut).ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+			content, err := afero.ReadFile(fs, path.Join(docRoot, "test.txt"))
+			if err != nil && tt.content != "" {
+				t.Fatal(err)
+			}
+			if string(content) != tt.content {
+				t.Errorf("content = %q, want = %q", content, tt.content)
+			}
+		})
+	}
+}
+
+func TestServer_AllowedExtensions(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		want     int
+		body     string
+	}{
+		{"allowed", "photo.jpg", http.StatusCreated, uploadedBody("/files/photo.jpg", []byte("hello"))},
+		{"case-insensitive", "photo.PNG", http.StatusCreated, uploadedBody("/files/photo.PNG", []byte("hello"))},
+		{"not allowed", "script.sh", http.StatusUnsupportedMediaType, `{"ok":false,"error":"only .jpg, .png, .gif allowed"}`},
+		{"no extension", "jpg", http.StatusUnsupportedMediaType, `{"ok":false,"error":"only .jpg, .png, .gif allowed"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRoot := "/opt/app"
+			config := ServerConfig{
+				DocumentRoot:      docRoot,
+				MaxUploadSize:     16,
+				AllowedExtensions: []string{".jpg", "png", ".GIF"},
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+			req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, tt.filename, strings.NewReader("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePost).ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+			if body := rr.Body.String(); body != tt.body {
+				t.Errorf("body = %s, want = %s", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestServer_BlockedExtensions(t *testing.T) {
+	notAllowed := `{"ok":false,"error":"file type not allowed"}`
+	tests := []struct {
+		name     string
+		allowed  []string
+		filename string
+		want     int
+		body     string
+	}{
+		{"blocked", nil, "setup.exe", http.StatusUnsupportedMediaType, notAllowed},
+		{"case-insensitive", nil, "setup.EXE", http.StatusUnsupportedMediaType, notAllowed},
+		{"multi-part extension", nil, "backup.tar.gz", http.StatusUnsupportedMediaType, notAllowed},
+		{"not blocked", nil, "photo.jpg", http.StatusCreated, uploadedBody("/files/photo.jpg", []byte("hello"))},
+		{"no extension", nil, "exe", http.StatusCreated, uploadedBody("/files/exe", []byte("hello"))},
+		{"dotfile", nil, ".sh", http.StatusCreated, uploadedBody("/files/.sh", []byte("hello"))},
+		{"blocked in allowed", []string{".exe", ".jpg"}, "setup.exe", http.StatusUnsupportedMediaType, notAllowed},
+		{"allowed", []string{".exe", ".jpg"}, "photo.jpg", http.StatusCreated, uploadedBody("/files/photo.jpg", []byte("hello"))},
+		{"not allowed", []string{".exe", ".jpg"}, "notes.txt", http.StatusUnsupportedMediaType, `{"ok":false,"error":"only .exe, .jpg allowed"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRoot := "/opt/app"
+			config := ServerConfig{
+				DocumentRoot:      docRoot,
+				MaxUploadSize:     16,
+				AllowedExtensions: tt.allowed,
+				BlockedExtensions: []string{"exe", ".SH", ".tar.gz"},
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+			req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, tt.filename, strings.NewReader("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePost).ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+			if body := rr.Body.String(); body != tt.body {
+				t.Errorf("body = %s, want = %s", body, tt.body)
+			}
+		})
+	}
+}
+
+// slowReader reads r a few bytes at a time, waiting for delay before each read.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (s slowReader) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return s.r.Read(p[:min(len(p), 4)])
+}
+
+func TestServer_MaxUploadDuration(t *testing.T) {
+	content := []byte("hello, slow upload")
+	tests := []struct {
+		name      string
+		makeReq   func() (*http.Request, error)
+		delay     time.Duration
+		want      int
+		wantRange string
+	}{
+		{
+			name: "multipart",
+			makeReq: func() (*http.Request, error) {
+				return makeFormRequest(&url.URL{Path: "/files/foo.txt"}, http.MethodPut, "foo.txt", bytes.NewReader(content))
+			},
+			delay: 10 * time.Millisecond,
+			want:  http.StatusRequestTimeout,
+		},
+		{
+			name: "raw body",
+			makeReq: func() (*http.Request, error) {
+				return http.NewRequest(http.MethodPost, "/upload", bytes.NewReader(content))
+			},
+			delay: 10 * time.Millisecond,
+			want:  http.StatusRequestTimeout,
+		},
+		{
+			name: "chunk",
+			makeReq: func() (*http.Request, error) {
+				req, err := http.NewRequest(http.MethodPut, "/files/foo.txt", bytes.NewReader(content))
+				if err == nil {
+					req.Header.Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(content)-1, len(content)))
+				}
+				return req, err
+			},
+			delay:     10 * time.Millisecond,
+			want:      http.StatusRequestTimeout,
+			wantRange: "bytes=0-",
+		},
+		{
+			name: "fast enough",
+			makeReq: func() (*http.Request, error) {
+				return makeFormRequest(&url.URL{Path: "/files/foo.txt"}, http.MethodPut, "foo.txt", bytes.NewReader(content))
+			},
+			want: http.StatusCreated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRoot := "/opt/app"
+			fs := afero.NewMemMapFs()
+			config := ServerConfig{
+				DocumentRoot:      docRoot,
+				MaxUploadSize:     1024,
+				MaxUploadDuration: 30,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+			req, err := tt.makeReq()
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Body = io.NopCloser(slowReader{req.Body, tt.delay})
+			rr := httptest.NewRecorder()
+			server.router().ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Fatalf("status = %d, want = %d: %s", rr.Code, tt.want, rr.Body.String())
+			}
+			if tt.want != http.StatusRequestTimeout {
+				return
+			}
+			// the received bytes of a chunk are kept to resume
+			if got := rr.Header().Get("Range"); !strings.HasPrefix(got, tt.wantRange) {
+				t.Errorf("Range = %q, want prefix %q", got, tt.wantRange)
+			}
+			if exists, _ := afero.Exists(fs, path.Join(docRoot, "foo.txt")); exists {
+				t.Errorf("the file should not be stored")
+			}
+		})
+	}
+}
+
+func TestServer_DisableRanges(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "test.txt"), []byte("hello, world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+		DisableRanges: true,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+
+	req := httptest.NewRequest(http.MethodGet, "/files/test.txt", nil)
+	req.Header.Set("Range", "bytes=0-4")
+	rr := httptest.NewRecorder()
+	server.handle(server.handleGet).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("status = %d, want = %d", rr.Code, http.StatusOK)
+	}
+	if ar := rr.Header().Get("Accept-Ranges"); ar != "none" {
+		t.Errorf("Accept-Ranges = %s, want = none", ar)
+	}
+	if cr := rr.Header().Get("Content-Range"); cr != "" {
+		t.Errorf("Content-Range = %s, want = empty", cr)
+	}
+	if body := rr.Body.String(); body != "hello, world" {
+		t.Errorf("body = %q, want = %q", body, "hello, world")
+	}
+}
+
+func TestServer_HeadWithRange(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "test.txt"), []byte("hello, world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+
+	tests := []struct {
+		name          string
+		rangeHeader   string
+		want          int
+		contentLength string
+		contentRange  string
+	}{
+		{"without Range", "", http.StatusOK, "12", ""},
+		{"with Range", "bytes=0-4", http.StatusPartialContent, "5", "bytes 0-4/12"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodHead, "/files/test.txt", nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			rr := httptest.NewRecorder()
+			server.handle(server.handleGet).ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+			if ar := rr.Header().Get("Accept-Ranges"); ar != "bytes" {
+				t.Errorf("Accept-Ranges = %s, want = bytes", ar)
+			}
+			if cl := rr.Header().Get("Content-Length"); cl != tt.contentLength {
+				t.Errorf("Content-Length = %s, want = %s", cl, tt.contentLength)
+			}
+			if cr := rr.Header().Get("Content-Range"); cr != tt.contentRange {
+				t.Errorf("Content-Range = %s, want = %s", cr, tt.contentRange)
+			}
+			if rr.Body.Len() != 0 {
+				t.Errorf("body should be empty on HEAD, got %d bytes", rr.Body.Len())
+			}
+		})
+	}
+}
+
+func TestServer_MaxUploadSizeByType(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 24)
+	webm := "\x1a\x45\xdf\xa3" + strings.Repeat("\x00", 60)
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		want     int
+		body     string
+	}{
+		{"image over its limit", "image.png", png, http.StatusRequestEntityTooLarge, `{"ok":false,"error":"file size limit exceeded","max_bytes":16}`},
+		{"video under its limit", "video.webm", webm, http.StatusCreated, uploadedBody("/files/video.webm", []byte(webm))},
+		{"other type under the global limit", "text.txt", "hello, world", http.StatusCreated, uploadedBody("/files/text.txt", []byte("hello, world"))},
+		{"other type over the global limit", "text.txt", strings.Repeat("a", 33), http.StatusRequestEntityTooLarge, `{"ok":false,"error":"file size limit exceeded","max_bytes":32}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRoot := "/opt/app"
+			config := ServerConfig{
+				DocumentRoot:  docRoot,
+				MaxUploadSize: 32,
+				MaxUploadSizeByType: map[string]int64{
+					"image/": 16,
+					"video/": 128,
+				},
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+			req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, tt.filename, strings.NewReader(tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePost).ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+			if body := rr.Body.String(); body != tt.body {
+				t.Errorf("body = %s, want = %s", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestServer_UnreadableFile(t *testing.T) {
+	if v, ok := os.LookupEnv("TEST_WITH_REAL_FS"); !ok || v == "" {
+		t.Skip("TEST_WITH_REAL_FS is not set")
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any file")
+	}
+	docRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(docRoot, "secret.txt"), []byte("hello"), 0000); err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewOsFs(), docRoot)}
+
+	for _, target := range []string{"/files/secret.txt", "/files/secret.txt?meta=true"} {
+		t.Run(target, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			rr := httptest.NewRecorder()
+			server.handle(server.handleGet).ServeHTTP(rr, req)
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want = %d", rr.Code, http.StatusForbidden)
+			}
+			if body, want := rr.Body.String(), `{"ok":false,"error":"permission denied"}`; body != want {
+				t.Errorf("body = %s, want = %s", body, want)
+			}
+		})
+	}
+}
+
+func TestServer_UploadPathHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		uploadPath string
+		want       int
+		body       string
+		localPath  string
+	}{
+		{"nested path", "sub/dir/file.txt", http.StatusCreated, uploadedBody("/files/sub/dir/file.txt", []byte("hello")), "sub/dir/file.txt"},
+		{"leading slash", "/file.txt", http.StatusCreated, uploadedBody("/files/file.txt", []byte("hello")), "file.txt"},
+		{"redundant segments", "sub//./file.txt", http.StatusCreated, uploadedBody("/files/sub/file.txt", []byte("hello")), "sub/file.txt"},
+		{"traversal", "../etc/passwd", http.StatusBadRequest, `{"ok":false,"error":"invalid upload path"}`, ""},
+		{"traversal in the middle", "sub/../../file.txt", http.StatusBadRequest, `{"ok":false,"error":"invalid upload path"}`, ""},
+		{"backslash", `sub\file.txt`, http.StatusBadRequest, `{"ok":false,"error":"invalid upload path"}`, ""},
+		{"directory", "sub/", http.StatusBadRequest, `{"ok":false,"error":"invalid upload path"}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRoot := "/opt/app"
+			fs := afero.NewMemMapFs()
+			config := ServerConfig{
+				DocumentRoot:  docRoot,
+				MaxUploadSize: 16,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+			req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "original.txt", strings.NewReader("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Upload-Path", tt.uploadPath)
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePost).ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+			if body := rr.Body.String(); body != tt.body {
+				t.Errorf("body = %s, want = %s", body, tt.body)
+			}
+			if tt.localPath != "" {
+				verifyLocalFile(t, fs, path.Join(docRoot, tt.localPath), []byte("hello"))
+				if loc := rr.Header().Get("Location"); !strings.HasSuffix(loc, "/files/"+tt.localPath) {
+					t.Errorf("Location = %s, want suffix = /files/%s", loc, tt.localPath)
+				}
+			}
+			if exists, _ := afero.Exists(fs, path.Join(docRoot, "original.txt")); exists {
+				t.Errorf("original file name should not be used")
+			}
+		})
+	}
+}
+
+func TestServer_GenerateFileNames(t *testing.T) {
+	docRoot := "/opt/app"
+	tests := []struct {
+		name     string
+		filename string
+		wantName *regexp.Regexp
+	}{
+		{"keep extension", "photo.jpg", regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\.jpg$`)},
+		{"no extension", "README", regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\.txt$`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			config := ServerConfig{
+				DocumentRoot:       docRoot,
+				MaxUploadSize:      16,
+				FileNamingStrategy: "uuid",
+				GenerateFileNames:  true,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+			req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, tt.filename, strings.NewReader("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePost).ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+			}
+			var result SuccessfullyUploadedResult
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			name := strings.TrimPrefix(result.Path, "/files/")
+			if !tt.wantName.MatchString(name) {
+				t.Errorf("name = %s, want to match %s", name, tt.wantName)
+			}
+			verifyLocalFile(t, fs, path.Join(docRoot, name), []byte("hello"))
+		})
+	}
+}
+
+func TestLogAccess_RedactsToken(t *testing.T) {
+	var buf bytes.Buffer
+	orig := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(orig)
+
+	req := httptest.NewRequest(http.MethodGet, "/files/foo.txt?token=secret&download=true", nil)
+	req.Header.Set("Referer", "https://example.com/page?token=secret")
+	rr := httptest.NewRecorder()
+	logAccess(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+
+	logged := buf.String()
+	if strings.Contains(logged, "secret") {
+		t.Errorf("log contains the token: %s", logged)
+	}
+	for _, want := range []string{
+		`"GET /files/foo.txt?download=true&token=%5BREDACTED%5D HTTP/1.1"`,
+		`"https://example.com/page?token=%5BREDACTED%5D"`,
+	} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log does not contain %s: %s", want, logged)
+		}
+	}
+}
+
+func TestLogAccess_StatusAndSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"not found", http.NotFound, `HTTP/1.1" 404 19 `},
+		{"implicit 200", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) }, `HTTP/1.1" 200 5 `},
+		{"no body", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }, `HTTP/1.1" 204 0 `},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			orig := log.Writer()
+			log.SetOutput(&buf)
+			defer log.SetOutput(orig)
+
+			rr := httptest.NewRecorder()
+			logAccess(tt.handler).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/files/foo.txt", nil))
+			if logged := buf.String(); !strings.Contains(logged, tt.want) {
+				t.Errorf("log does not contain %s: %s", tt.want, logged)
+			}
+		})
+	}
+}
+
+func TestServer_AccessLogWithAuth(t *testing.T) {
+	config := ServerConfig{
+		DocumentRoot:    "/opt/app",
+		MaxUploadSize:   16,
+		EnableAuth:      true,
+		ReadOnlyTokens:  []string{"ro-secret"},
+		ReadWriteTokens: []string{"rw-secret"},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewMemMapFs()}
+	router := server.router()
+
+	tests := []struct {
+		name   string
+		target string
+		header string
+		want   int
+		logged string
+	}{
+		{"unauthorized request is logged", "/files/foo.txt?token=invalid-secret", "", http.StatusUnauthorized, `"GET /files/foo.txt?token=%5BREDACTED%5D HTTP/1.1"`},
+		{"token in query is not logged", "/whoami?token=ro-secret", "", http.StatusOK, `"GET /whoami?token=%5BREDACTED%5D HTTP/1.1"`},
+		{"token in header is not logged", "/whoami", "Bearer rw-secret", http.StatusOK, `"GET /whoami HTTP/1.1"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			orig := log.Writer()
+			log.SetOutput(&buf)
+			defer log.SetOutput(orig)
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+			logged := buf.String()
+			if !strings.Contains(logged, tt.logged) {
+				t.Errorf("log does not contain %s: %s", tt.logged, logged)
+			}
+			if strings.Contains(logged, "secret") {
+				t.Errorf("log contains the token: %s", logged)
+			}
+		})
+	}
+}
+
+func TestServer_FileAliases(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "foo/bar.txt"), []byte("hello, world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+		FileAliases:   []string{"/download", "legacy/files/"},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	router := server.router()
+
+	tests := []struct {
+		method string
+		target string
+		want   int
+		body   string
+	}{
+		{http.MethodGet, "/files/foo/bar.txt", http.StatusOK, "hello, world"},
+		{http.MethodGet, "/download/foo/bar.txt", http.StatusOK, "hello, world"},
+		{http.MethodGet, "/legacy/files/foo/bar.txt", http.StatusOK, "hello, world"},
+		{http.MethodHead, "/download/foo/bar.txt", http.StatusOK, ""},
+		{http.MethodGet, "/download/foo/missing.txt", http.StatusNotFound, `{"ok":false,"error":"file not found"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+			if body := rr.Body.String(); body != tt.body {
+				t.Errorf("body = %s, want = %s", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestServer_PostRawBodyInfersExtension(t *testing.T) {
+	docRoot := "/opt/app"
+	tests := []struct {
+		name    string
+		content []byte
+		wantExt string
+	}{
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), ".png"},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00"), ".gif"},
+		{"unknown binary", []byte{0x00, 0x01, 0x02, 0x03}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			config := ServerConfig{
+				DocumentRoot:       docRoot,
+				MaxUploadSize:      32,
+				FileNamingStrategy: "uuid",
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+			req := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(tt.content))
+			req.Header.Set("Content-Type", "application/octet-stream")
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePost).ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+			}
+			var result SuccessfullyUploadedResult
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			name := strings.TrimPrefix(result.Path, "/files/")
+			if ext := filepath.Ext(name); ext != tt.wantExt {
+				t.Errorf("extension of %s = %q, want = %q", name, ext, tt.wantExt)
+			}
+			verifyLocalFile(t, fs, path.Join(docRoot, name), tt.content)
+		})
+	}
+}
+
+func TestServer_SyncOnUpload(t *testing.T) {
+	if v, ok := os.LookupEnv("TEST_WITH_REAL_FS"); !ok || v == "" {
+		t.Skip("TEST_WITH_REAL_FS is not set")
+	}
+	docRoot := t.TempDir()
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+		SyncOnUpload:  true,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewOsFs(), docRoot)}
+	req, err := makeFormRequest(&url.URL{Path: "/files/foo/bar.txt"}, http.MethodPut, "bar.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.handle(server.handlePut).ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+	}
+	content, err := os.ReadFile(filepath.Join(docRoot, "foo", "bar.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello" {
+		t.Errorf("content = %q, want = %q", content, "hello")
+	}
+}
+
+func TestServer_Checksums(t *testing.T) {
+	docRoot := "/opt/app"
+	content := []byte("hello, checksums")
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 32,
+		Checksums:     []string{"md5", "sha256"},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+	req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "test.txt", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.handle(server.handlePost).ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+	}
+	var result SuccessfullyUploadedResult
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	if want := fmt.Sprintf("%x", md5.Sum(content)); result.MD5 != want {
+		t.Errorf("md5 = %s, want = %s", result.MD5, want)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256(content)); result.SHA256 != want {
+		t.Errorf("sha256 = %s, want = %s", result.SHA256, want)
+	}
+}
+
+func TestServer_UploadResultSizeAndChecksum(t *testing.T) {
+	docRoot := "/opt/app"
+	content := []byte("hello, size and checksum")
+	server := Server{ServerConfig: ServerConfig{DocumentRoot: docRoot, MaxUploadSize: 64}, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+	req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "test.txt", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.handle(server.handlePost).ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+	}
+	var result SuccessfullyUploadedResult
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	if result.Size != int64(len(content)) {
+		t.Errorf("size = %d, want = %d", result.Size, len(content))
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256(content)); result.SHA256 != want {
+		t.Errorf("sha256 = %s, want = %s", result.SHA256, want)
+	}
+	if result.MD5 != "" {
+		t.Errorf("md5 = %s, want empty", result.MD5)
+	}
+}
+
+func TestServer_DebugVerifyUploads(t *testing.T) {
+	docRoot := "/opt/app"
+	content := []byte("hello, verification")
+	tests := []struct {
+		name   string
+		verify bool
+	}{
+		{"enabled", true},
+		{"disabled", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := ServerConfig{
+				DocumentRoot:       docRoot,
+				MaxUploadSize:      32,
+				DebugVerifyUploads: tt.verify,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+			req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "test.txt", bytes.NewReader(content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePost).ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+			}
+			var result SuccessfullyUploadedResult
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if !tt.verify {
+				if result.Stored != nil {
+					t.Errorf("stored = %+v, want = nil", result.Stored)
+				}
+				return
+			}
+			if result.Stored == nil {
+				t.Fatal("stored is missing")
+			}
+			if result.Stored.Size != int64(len(content)) {
+				t.Errorf("stored size = %d, want = %d", result.Stored.Size, len(content))
+			}
+			if want := fmt.Sprintf("%x", sha256.Sum256(content)); result.Stored.SHA256 != want {
+				t.Errorf("stored sha256 = %s, want = %s", result.Stored.SHA256, want)
+			}
+		})
+	}
+}
+
+func TestServer_ConflictDetails(t *testing.T) {
+	docRoot := "/opt/app"
+	existing := []byte("existing content")
+	tests := []struct {
+		name            string
+		conflictDetails bool
+		wantExisting    bool
+	}{
+		{"disabled", false, false},
+		{"enabled", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			if err := afero.WriteFile(fs, path.Join(docRoot, "foo.txt"), existing, 0644); err != nil {
+				t.Fatal(err)
+			}
+			config := ServerConfig{
+				DocumentRoot:    docRoot,
+				MaxUploadSize:   32,
+				ConflictDetails: tt.conflictDetails,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+			req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "foo.txt", strings.NewReader("new"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePost).ServeHTTP(rr, req)
+			if rr.Code != http.StatusConflict {
+				t.Fatalf("status = %d, want = %d", rr.Code, http.StatusConflict)
+			}
+			var result ConflictResult
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if result.OK || result.Error != "the file already exists" {
+				t.Errorf("result = %+v, want the conflict error", result)
+			}
+			want := ExistingFileResult{}
+			if tt.wantExisting {
+				want.Size = int64(len(existing))
+				want.SHA256 = fmt.Sprintf("%x", sha256.Sum256(existing))
+			}
+			if got := result.Existing; got.Size != want.Size || got.SHA256 != want.SHA256 {
+				t.Errorf("existing = %+v, want = %+v", got, want)
+			}
+			if tt.wantExisting && result.Existing.ModTime.IsZero() {
+				t.Errorf("existing.mod_time should be set")
+			}
+			verifyLocalFile(t, fs, path.Join(docRoot, "foo.txt"), existing)
+		})
+	}
+}
+
+func TestServer_PreferReturn(t *testing.T) {
+	tests := []struct {
+		name           string
+		prefer         string
+		wantBody       bool
+		wantPreference string
+	}{
+		{"no preference", "", true, ""},
+		{"minimal", "return=minimal", false, "return=minimal"},
+		{"representation", "return=representation", true, "return=representation"},
+		{"minimal among others", `respond-async, return="minimal"; foo=bar`, false, "return=minimal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRoot := "/opt/app"
+			config := ServerConfig{
+				DocumentRoot:  docRoot,
+				MaxUploadSize: 16,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+			req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "test.txt", bytes.NewBufferString("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.prefer != "" {
+				req.Header.Set("Prefer", tt.prefer)
+			}
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePost).ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+			}
+			if loc := rr.Header().Get("Location"); !strings.HasSuffix(loc, "/files/test.txt") {
+				t.Errorf("Location = %s, want to point /files/test.txt", loc)
+			}
+			if pa := rr.Header().Get("Preference-Applied"); pa != tt.wantPreference {
+				t.Errorf("Preference-Applied = %q, want = %q", pa, tt.wantPreference)
+			}
+			if !tt.wantBody {
+				if rr.Body.Len() != 0 {
+					t.Errorf("body should be empty, got %q", rr.Body.String())
+				}
+				return
+			}
+			var result SuccessfullyUploadedResult
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if want := uploadedResult("/files/test.txt", []byte("hello")); !reflect.DeepEqual(result, want) {
+				t.Errorf("result = %+v, want = %+v", result, want)
+			}
+		})
+	}
+}
+
+func TestServer_CanonicalDestPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		uploadPath string
+		putPath    string
+		want       string
+	}{
+		{"file name", "", "/files/same.txt", "/files/same.txt"},
+		{"nested path", "sub/dir/same.txt", "/files/sub/dir/same.txt", "/files/sub/dir/same.txt"},
+		{"redundant segments", "sub//./same.txt", "/files/sub//same.txt", "/files/sub/same.txt"},
+		{"trailing slash", "sub/same.txt", "/files/sub/same.txt/", "/files/sub/same.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRoot := "/opt/app"
+			config := ServerConfig{
+				DocumentRoot:  docRoot,
+				MaxUploadSize: 16,
+			}
+			upload := func(method, target string, header http.Header) string {
+				server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+				req, err := makeFormRequest(&url.URL{Path: target}, method, "same.txt", strings.NewReader("hello"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for k, v := range header {
+					req.Header[k] = v
+				}
+				rr := httptest.NewRecorder()
+				if method == http.MethodPost {
+					server.handle(server.handlePost).ServeHTTP(rr, req)
+				} else {
+					server.handle(server.handlePut).ServeHTTP(rr, req)
+				}
+				if rr.Code != http.StatusCreated {
+					t.Fatalf("%s %s: status = %d, want = %d", method, target, rr.Code, http.StatusCreated)
+				}
+				var result SuccessfullyUploadedResult
+				if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+					t.Fatalf("failed to decode response body: %v", err)
+				}
+				if loc := rr.Header().Get("Location"); !strings.HasSuffix(loc, result.Path) {
+					t.Errorf("%s %s: Location = %s, want suffix = %s", method, target, loc, result.Path)
+				}
+				return result.Path
+			}
+
+			header := http.Header{}
+			if tt.uploadPath != "" {
+				header.Set(UploadPathHeader, tt.uploadPath)
+			}
+			if got := upload(http.MethodPost, "/upload", header); got != tt.want {
+				t.Errorf("POST: path = %s, want = %s", got, tt.want)
+			}
+			if got := upload(http.MethodPut, tt.putPath, nil); got != tt.want {
+				t.Errorf("PUT: path = %s, want = %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_RequireExtension(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		filename string
+		want     int
+	}{
+		{"POST with extension", http.MethodPost, "/upload", "photo.jpg", http.StatusCreated},
+		{"POST without extension", http.MethodPost, "/upload", "photo", http.StatusBadRequest},
+		{"POST dotfile", http.MethodPost, "/upload", ".hidden", http.StatusBadRequest},
+		{"PUT with extension", http.MethodPut, "/files/photo.jpg", "photo.jpg", http.StatusCreated},
+		{"PUT without extension", http.MethodPut, "/files/photo", "photo.jpg", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRoot := "/opt/app"
+			fs := afero.NewMemMapFs()
+			config := ServerConfig{
+				DocumentRoot:     docRoot,
+				MaxUploadSize:    16,
+				RequireExtension: true,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+			req, err := makeFormRequest(&url.URL{Path: tt.target}, tt.method, tt.filename, strings.NewReader("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			server.router().ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+			if tt.want == http.StatusBadRequest {
+				if body, want := rr.Body.String(), `{"ok":false,"error":"the file name must have an extension"}`; body != want {
+					t.Errorf("body = %s, want = %s", body, want)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_PathTraversal(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "/opt/secret", []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	handlers := map[string]http.Handler{
+		http.MethodGet:    server.handle(server.handleGet),
+		http.MethodPut:    server.handle(server.handlePut),
+		http.MethodDelete: server.handle(server.handleDelete),
+		MethodPropfind:    server.handle(server.handlePropfind),
+	}
+	targets := []string{
+		"/files/../secret",
+		"/files/foo/../../bar",
+		"/files/%2e%2e/secret",
+		"/files/foo/%2E%2E/%2e%2e/bar",
+	}
+	for method, handler := range handlers {
+		for _, target := range targets {
+			t.Run(method+" "+target, func(t *testing.T) {
+				var req *http.Request
+				if method == http.MethodPut {
+					var err error
+					req, err = makeFormRequest(&url.URL{Path: "/"}, method, "secret", strings.NewReader("overwritten"))
+					if err != nil {
+						t.Fatal(err)
+					}
+					req.URL, err = url.Parse(target)
+					if err != nil {
+						t.Fatal(err)
+					}
+				} else {
+					req = httptest.NewRequest(method, target, nil)
+				}
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				if rr.Code != http.StatusBadRequest {
+					t.Errorf("status = %d, want = %d", rr.Code, http.StatusBadRequest)
+				}
+				if body, want := rr.Body.String(), `{"ok":false,"error":"invalid path"}`; body != want {
+					t.Errorf("body = %s, want = %s", body, want)
+				}
+			})
+		}
+	}
+	verifyLocalFile(t, fs, "/opt/secret", []byte("secret"))
+}
+
+func TestServer_DestinationType(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{"parent is a file", "/files/a/b", `{"ok":false,"error":"cannot create /a/b: /a is a file"}`},
+		{"ancestor is a file", "/files/a/b/c", `{"ok":false,"error":"cannot create /a/b/c: /a is a file"}`},
+		{"destination is a directory", "/files/dir?overwrite=true", `{"ok":false,"error":"/dir is a directory"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRoot := "/opt/app"
+			fs := afero.NewMemMapFs()
+			if err := afero.WriteFile(fs, path.Join(docRoot, "a"), []byte("file"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.MkdirAll(path.Join(docRoot, "dir"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			config := ServerConfig{
+				DocumentRoot:  docRoot,
+				MaxUploadSize: 16,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+			u, err := url.Parse(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := makeFormRequest(u, http.MethodPut, "b", strings.NewReader("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			server.router().ServeHTTP(rr, req)
+			if rr.Code != http.StatusConflict {
+				t.Errorf("status = %d, want = %d", rr.Code, http.StatusConflict)
+			}
+			if body := rr.Body.String(); body != tt.want {
+				t.Errorf("body = %s, want = %s", body, tt.want)
+			}
+			verifyLocalFile(t, fs, path.Join(docRoot, "a"), []byte("file"))
+		})
+	}
+}
+
+func TestServer_NormalizeUnicode(t *testing.T) {
+	docRoot := "/opt/app"
+	nfd := "cafe\u0301.txt"
+	nfc := "caf\u00e9.txt"
+	content := []byte("hello")
+	tests := []struct {
+		name    string
+		makeReq func() (*http.Request, error)
+	}{
+		{
+			name: "POST with NFD file name",
+			makeReq: func() (*http.Request, error) {
+				return makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, nfd, bytes.NewReader(content))
+			},
+		},
+		{
+			name: "PUT to NFD path",
+			makeReq: func() (*http.Request, error) {
+				return makeFormRequest(&url.URL{Path: "/files/" + nfd}, http.MethodPut, "foo.txt", bytes.NewReader(content))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			config := ServerConfig{
+				DocumentRoot:     docRoot,
+				MaxUploadSize:    16,
+				NormalizeUnicode: true,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+			handler := server.router()
+			req, err := tt.makeReq()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want = %d: %s", rr.Code, http.StatusCreated, rr.Body.String())
+			}
+			var result SuccessfullyUploadedResult
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if result.Path != "/files/"+nfc {
+				t.Errorf("path = %q, want = %q", result.Path, "/files/"+nfc)
+			}
+			verifyLocalFile(t, fs, path.Join(docRoot, nfc), content)
+			if exists, _ := afero.Exists(fs, path.Join(docRoot, nfd)); exists {
+				t.Errorf("the file should not be stored in NFD")
+			}
+
+			// both forms retrieve the same file
+			for _, name := range []string{nfc, nfd} {
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/files/"+url.PathEscape(name), nil))
+				if rr.Code != http.StatusOK {
+					t.Errorf("GET %q status = %d, want = %d", name, rr.Code, http.StatusOK)
+				} else if body := rr.Body.String(); body != string(content) {
+					t.Errorf("GET %q body = %q, want = %q", name, body, content)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_WindowsCompatibleNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		filename string
+		want     int
+		// the content is sent as a chunk with Content-Range if set
+		contentRange string
+	}{
+		{"ordinary name", http.MethodPost, "/upload", "foo.txt", http.StatusCreated, ""},
+		{"trailing dot", http.MethodPost, "/upload", "foo.", http.StatusBadRequest, ""},
+		{"trailing space", http.MethodPut, "/files/bar ", "bar.txt", http.StatusBadRequest, ""},
+		{"reserved name with extension", http.MethodPost, "/upload", "CON.txt", http.StatusBadRequest, ""},
+		{"reserved name in lower case", http.MethodPut, "/files/nul", "nul.txt", http.StatusBadRequest, ""},
+		{"reserved name in directory", http.MethodPut, "/files/aux/foo.txt", "foo.txt", http.StatusBadRequest, ""},
+		{"reserved name as prefix", http.MethodPost, "/upload", "CONFIG.txt", http.StatusCreated, ""},
+		{"ordinary name in chunks", http.MethodPut, "/files/foo.txt", "", http.StatusCreated, "bytes 0-4/5"},
+		{"trailing dot in chunks", http.MethodPut, "/files/foo.", "", http.StatusBadRequest, "bytes 0-4/5"},
+		{"reserved name in chunks", http.MethodPut, "/files/CON.txt", "", http.StatusBadRequest, "bytes 0-4/10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRoot := "/opt/app"
+			fs := afero.NewMemMapFs()
+			config := ServerConfig{
+				DocumentRoot:           docRoot,
+				MaxUploadSize:          16,
+				WindowsCompatibleNames: true,
+			}
+			server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+			req, err := makeFormRequest(&url.URL{Path: tt.target}, tt.method, tt.filename, strings.NewReader("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.contentRange != "" {
+				req = httptest.NewRequest(tt.method, tt.target, strings.NewReader("hello"))
+				req.Header.Set("Content-Range", tt.contentRange)
+			}
+			rr := httptest.NewRecorder()
+			server.router().ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d: %s", rr.Code, tt.want, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestNewServer_Options(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	namer := func(_ multipart.File, info *multipart.FileHeader) (string, error) {
+		return "named-" + info.Filename, nil
+	}
+	server := NewServer(ServerConfig{
+		DocumentRoot:      "/nonexistent",
+		MaxUploadSize:     16,
+		GenerateFileNames: true,
+	}, WithFileSystem(fs), WithNamingStrategy(namer))
+	if err := server.checkDocumentRoot(); err != nil {
+		t.Fatalf("checkDocumentRoot() error = %v", err)
+	}
+
+	req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "foo.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+	}
+	if body, want := rr.Body.String(), uploadedBody("/files/named-foo.txt", []byte("hello")); body != want {
+		t.Errorf("body = %s, want = %s", body, want)
+	}
+	verifyLocalFile(t, fs, "/named-foo.txt", []byte("hello"))
+}
+
+func TestServer_Handler(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	server := NewServer(ServerConfig{MaxUploadSize: 16}, WithFileSystem(fs))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL + "/upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := makeFormRequest(u, http.MethodPost, "hello.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /upload: status = %d, want = %d", resp.StatusCode, http.StatusCreated)
+	}
+
+	resp, err = ts.Client().Get(ts.URL + "/files/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Errorf("GET /files/hello.txt = %d %q, want = 200 \"hello\"", resp.StatusCode, body)
+	}
+}
+
+func TestServer_ErrorFormatter(t *testing.T) {
+	type envelope struct {
+		Error struct {
+			Status  int    `json:"status"`
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	docRoot := "/opt/app"
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+		ErrorFormatter: func(status int, code, msg string) any {
+			var e envelope
+			e.Error.Status = status
+			e.Error.Code = code
+			e.Error.Message = msg
+			return e
+		},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot)}
+	req := httptest.NewRequest(http.MethodGet, "/files/missing.txt", nil)
+	rr := httptest.NewRecorder()
+	server.handle(server.handleGet).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want = %d", rr.Code, http.StatusNotFound)
+	}
+	if body, want := rr.Body.String(), `{"error":{"status":404,"code":"not_found","message":"file not found"}}`; body != want {
+		t.Errorf("body = %s, want = %s", body, want)
+	}
+}
+
+func TestServer_UnlimitedUploadSize(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 0,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	content := bytes.Repeat([]byte("a"), 1<<20)
+	req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "large.txt", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.handle(server.handlePost).ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+	}
+	verifyLocalFile(t, fs, path.Join(docRoot, "large.txt"), content)
+}
+
+func TestServer_NegativeUploadSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ServerConfig
+	}{
+		{"max_upload_size", ServerConfig{MaxUploadSize: -1}},
+		{"max_upload_size_by_type", ServerConfig{MaxUploadSize: 16, MaxUploadSizeByType: map[string]int64{"image/": -1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer(tt.config, WithFileSystem(afero.NewMemMapFs()))
+			err := server.Start(context.Background(), nil)
+			if err == nil || !strings.Contains(err.Error(), "must not be negative") {
+				t.Errorf("Start() error = %v, want negative size error", err)
+			}
+		})
+	}
+}
+
+func TestServer_FileModes(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 1024,
+		FileMode:      "0640",
+		DirMode:       "0750",
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	req, err := makeFormRequest(&url.URL{Path: "/files/sub/foo.txt"}, http.MethodPut, "foo.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.handle(server.handlePut).ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusCreated)
+	}
+	fi, err := fs.Stat(path.Join(docRoot, "sub/foo.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fi.Mode().Perm(); got != 0640 {
+		t.Errorf("file mode = %o, want = %o", got, 0640)
+	}
+	di, err := fs.Stat(path.Join(docRoot, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := di.Mode().Perm(); got != 0750 {
+		t.Errorf("dir mode = %o, want = %o", got, 0750)
+	}
+}
+
+func TestServer_InvalidFileMode(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ServerConfig
+	}{
+		{"file_mode", ServerConfig{FileMode: "0988"}},
+		{"dir_mode", ServerConfig{DirMode: "01777"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer(tt.config, WithFileSystem(afero.NewMemMapFs()))
+			err := server.Start(context.Background(), nil)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.name+":") {
+				t.Errorf("Start() error = %v, want invalid %s error", err, tt.name)
+			}
+		})
+	}
+}
+
+func TestServer_AllowedMethods(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ServerConfig
+		method string
+		target string
+		want   string
+	}{
+		{"OPTIONS /files", ServerConfig{}, http.MethodOptions, "/files/foo.txt", "GET, HEAD, PUT, DELETE, PROPFIND"},
+		{"OPTIONS /upload", ServerConfig{}, http.MethodOptions, "/upload", "POST"},
+		{"OPTIONS /files in read-only mode", ServerConfig{EnableAuth: true, ReadOnlyTokens: []string{"ro"}}, http.MethodOptions, "/files/foo.txt", "GET, HEAD, PROPFIND"},
+		{"OPTIONS /upload in read-only mode", ServerConfig{EnableAuth: true, ReadOnlyTokens: []string{"ro"}}, http.MethodOptions, "/upload", ""},
+		{"POST /files", ServerConfig{}, http.MethodPost, "/files/foo.txt", "GET, HEAD, PUT, DELETE, PROPFIND"},
+		{"PUT /download", ServerConfig{FileAliases: []string{"/download"}}, http.MethodPut, "/download/foo.txt", "GET, HEAD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.DocumentRoot = "/opt/app"
+			tt.config.MaxUploadSize = 16
+			server := Server{ServerConfig: tt.config, fs: afero.NewMemMapFs()}
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			rr := httptest.NewRecorder()
+			server.router().ServeHTTP(rr, req)
+			header := "Allow"
+			wantStatus := http.StatusMethodNotAllowed
+			if tt.method == http.MethodOptions {
+				header = "Access-Control-Allow-Methods"
+				wantStatus = http.StatusNoContent
+			}
+			if rr.Code != wantStatus {
+				t.Errorf("status = %d, want = %d", rr.Code, wantStatus)
+			}
+			if got := rr.Header().Get(header); got != tt.want {
+				t.Errorf("%s = %q, want = %q", header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_ConcurrentIdenticalUploads(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:       docRoot,
+		MaxUploadSize:      1 << 20,
+		FileNamingStrategy: "sha256",
+		GenerateFileNames:  true,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	content := bytes.Repeat([]byte("identical content\n"), 4096)
+
+	const n = 2
+	results := make(chan *httptest.ResponseRecorder, n)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		go func() {
+			<-start
+			req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "same.txt", bytes.NewReader(content))
+			if err != nil {
+				t.Error(err)
+				results <- nil
+				return
+			}
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePost).ServeHTTP(rr, req)
+			results <- rr
+		}()
+	}
+	close(start)
+
+	want := fmt.Sprintf("/files/%x.txt", sha256.Sum256(content))
+	codes := map[int]int{}
+	for i := 0; i < n; i++ {
+		rr := <-results
+		if rr == nil {
+			continue
+		}
+		codes[rr.Code]++
+		if rr.Code != http.StatusCreated && rr.Code != http.StatusOK {
+			continue
+		}
+		var result SuccessfullyUploadedResult
+		if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response body: %v", err)
+		}
+		if result.Path != want {
+			t.Errorf("path = %s, want = %s", result.Path, want)
+		}
+	}
+	// the first one stores the file and the other finds the same content
+	if want := map[int]int{http.StatusCreated: 1, http.StatusOK: 1}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("status codes = %v, want = %v", codes, want)
+	}
+	entries, err := afero.ReadDir(fs, docRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("files = %d, want = 1", len(entries))
+	}
+	verifyLocalFile(t, fs, path.Join(docRoot, strings.TrimPrefix(want, "/files/")), content)
+}
+
+func TestServer_DeduplicatedUploadPreconditions(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:       docRoot,
+		MaxUploadSize:      1024,
+		FileNamingStrategy: "sha256",
+		GenerateFileNames:  true,
+		EnableAuth:         true,
+		NamedTokens:        map[string]string{"alice": "alice-token", "bob": "bob-token"},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot), owners: newFileOwners("")}
+	handler := server.router()
+	post := func(token string, header http.Header) int {
+		t.Helper()
+		req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "same.txt", strings.NewReader("identical content"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := post("alice-token", nil); code != http.StatusCreated {
+		t.Fatalf("first upload: status = %d, want = %d", code, http.StatusCreated)
+	}
+	tests := []struct {
+		name   string
+		token  string
+		header http.Header
+		want   int
+	}{
+		{"same content", "alice-token", nil, http.StatusOK},
+		{"create-only", "alice-token", http.Header{"If-None-Match": {"*"}}, http.StatusPreconditionFailed},
+		{"update-only", "alice-token", http.Header{"If-Match": {"*"}}, http.StatusOK},
+		{"owned by another token", "bob-token", nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := post(tt.token, tt.header); code != tt.want {
+				t.Errorf("status = %d, want = %d", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_ConcurrentUploadsToSamePath(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 1 << 20,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	contents := [][]byte{
+		bytes.Repeat([]byte("first upload\n"), 4096),
+		bytes.Repeat([]byte("second upload\n"), 4096),
+	}
+
+	results := make(chan *httptest.ResponseRecorder, len(contents))
+	start := make(chan struct{})
+	for _, content := range contents {
+		go func(content []byte) {
+			<-start
+			req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "report.txt", bytes.NewReader(content))
+			if err != nil {
+				t.Error(err)
+				results <- nil
+				return
+			}
+			rr := httptest.NewRecorder()
+			server.handle(server.handlePost).ServeHTTP(rr, req)
+			results <- rr
+		}(content)
+	}
+	close(start)
+
+	codes := map[int]int{}
+	for range contents {
+		if rr := <-results; rr != nil {
+			codes[rr.Code]++
+		}
+	}
+	if codes[http.StatusCreated] != 1 || codes[http.StatusConflict] != 1 {
+		t.Errorf("status codes = %v, want one %d and one %d", codes, http.StatusCreated, http.StatusConflict)
+	}
+	got, err := afero.ReadFile(fs, path.Join(docRoot, "report.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, contents[0]) && !bytes.Equal(got, contents[1]) {
+		t.Errorf("the stored file is neither of the uploaded contents")
+	}
+}
+
+func TestServer_Delete(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "test.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, path.Join(docRoot, "test.txt"+UserMetadataFileSuffix), []byte(`{"author":"alice"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MkdirAll(path.Join(docRoot, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot), stats: newAccessStats()}
+	server.stats.Increment("/files/test.txt")
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantError  string
+	}{
+		{"existing file", "/files/test.txt", http.StatusNoContent, ""},
+		{"deleted file", "/files/test.txt", http.StatusNotFound, "file not found"},
+		{"missing file", "/files/missing.txt", http.StatusNotFound, "file not found"},
+		{"directory", "/files/dir", http.StatusConflict, "/dir is a directory"},
+		{"no file name", "/files/", http.StatusBadRequest, "no file name is specified; DELETE is accepted on /files/:name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, tt.target, nil)
+			rr := httptest.NewRecorder()
+			server.handle(server.handleDelete).ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want = %d", rr.Code, tt.wantStatus)
+			}
+			if tt.wantError == "" {
+				if rr.Body.Len() != 0 {
+					t.Errorf("body should be empty, got %q", rr.Body.String())
+				}
+				return
+			}
+			var result ErrorResult
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if want := (ErrorResult{false, tt.wantError}); result != want {
+				t.Errorf("body = %+v, want = %+v", result, want)
+			}
+		})
+	}
+	for _, name := range []string{"test.txt", "test.txt" + UserMetadataFileSuffix} {
+		if exists, _ := afero.Exists(fs, path.Join(docRoot, name)); exists {
+			t.Errorf("%s should be removed", name)
+		}
+	}
+	if exists, _ := afero.DirExists(fs, path.Join(docRoot, "dir")); !exists {
+		t.Errorf("directory should not be removed")
+	}
+	if popular := server.stats.Popular(10); len(popular) != 0 {
+		t.Errorf("popular files = %+v, want = empty", popular)
+	}
+}
@@ -0,0 +1,248 @@
+package simpleuploadserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/spf13/afero"
+)
+
+// stagedPathPrefix is the prefix of the path returned by processUpload for the staged uploads.
+const stagedPathPrefix = "/staged/"
+
+// stagedManifestSuffix is appended to the ID of the staged upload to store its manifest.
+const stagedManifestSuffix = ".json"
+
+// StagedUpload is an upload waiting in StagingDir to be promoted.
+type StagedUpload struct {
+	ID       string            `json:"id"`
+	Path     string            `json:"path"`
+	Size     int64             `json:"size"`
+	StagedAt time.Time         `json:"staged_at"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Owner    string            `json:"owner,omitempty"`
+}
+
+type UploadStagedResult struct {
+	OK   bool   `json:"ok"`
+	ID   string `json:"id"`
+	Path string `json:"path"`
+}
+
+type StagedUploadsResult struct {
+	OK      bool           `json:"ok"`
+	Uploads []StagedUpload `json:"uploads"`
+}
+
+// stageUpload stores the content read from `src` in the staging directory to be published at `path` later.
+// It returns 202 Accepted and stagedPathPrefix followed by the ID of the staged upload.
+func (s *Server) stageUpload(r *http.Request, src io.Reader, path string, sums checksums, limit int64) (int, string, error) {
+	id := uuid.NewString()
+	f, err := s.staging.Create(id)
+	if err != nil {
+		log.Printf("failed to create the staged file (id=%s): %v", id, err)
+		return http.StatusInternalServerError, "", fmt.Errorf("cannot stage the uploaded content")
+	}
+	trailerSum := trailerChecksum(r)
+	var dst io.Writer = f
+	if trailerSum != nil {
+		dst = io.MultiWriter(f, trailerSum)
+	}
+	written, err := io.Copy(sums.Writer(dst), src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		s.discardStagedUpload(id)
+		if isMaxBytesError(err) {
+			return http.StatusRequestEntityTooLarge, "", sizeLimitError{limit}
+		}
+		if errors.Is(err, errUploadTimeout) {
+			return http.StatusRequestTimeout, "", errUploadTimeout
+		}
+		log.Printf("failed to write the staged file (id=%s): %v", id, err)
+		return http.StatusInternalServerError, "", fmt.Errorf("failed to write the content")
+	}
+	if err := verifyTrailerChecksum(r, trailerSum); err != nil {
+		s.discardStagedUpload(id)
+		return http.StatusBadRequest, "", err
+	}
+	upload := StagedUpload{
+		ID:       id,
+		Path:     filesURLPath(path),
+		Size:     written,
+		StagedAt: time.Now(),
+		Metadata: userMetadataFromHeader(r.Header),
+		Owner:    tokenName(r),
+	}
+	b, err := json.Marshal(upload)
+	if err == nil {
+		err = afero.WriteFile(s.staging, id+stagedManifestSuffix, b, 0644)
+	}
+	if err != nil {
+		s.discardStagedUpload(id)
+		log.Printf("failed to write the manifest of the staged file (id=%s): %v", id, err)
+		return http.StatusInternalServerError, "", fmt.Errorf("cannot stage the uploaded content")
+	}
+	log.Printf("staged %s for %s (%d bytes, request_id=%s)", id, path, written, requestID(r.Context()))
+	return http.StatusAccepted, stagedPathPrefix + id, nil
+}
+
+// loadStagedUpload returns the staged upload of `id`. The error wraps os.ErrNotExist if there is no such upload.
+func (s *Server) loadStagedUpload(id string) (StagedUpload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return StagedUpload{}, fmt.Errorf("invalid ID %q: %w", id, os.ErrNotExist)
+	}
+	b, err := afero.ReadFile(s.staging, id+stagedManifestSuffix)
+	if err != nil {
+		return StagedUpload{}, err
+	}
+	var upload StagedUpload
+	if err := json.Unmarshal(b, &upload); err != nil {
+		return StagedUpload{}, err
+	}
+	return upload, nil
+}
+
+// discardStagedUpload removes the content and the manifest of the staged upload of `id`.
+func (s *Server) discardStagedUpload(id string) {
+	for _, name := range []string{id, id + stagedManifestSuffix} {
+		if err := s.staging.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("failed to remove the staged file (path=%s): %v", name, err)
+		}
+	}
+}
+
+// handleStaged lists the staged uploads in the order of staging. Read-only tokens cannot see them.
+func (s *Server) handleStaged(w http.ResponseWriter, r *http.Request) (int, any) {
+	if tokenScope(r) == ScopeReadOnly {
+		return http.StatusForbidden, fmt.Errorf("read-write token is required")
+	}
+	infos, err := afero.ReadDir(s.staging, "/")
+	if err != nil {
+		log.Printf("failed to read the staging directory: %v", err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to list the staged uploads")
+	}
+	uploads := []StagedUpload{}
+	for _, fi := range infos {
+		id, ok := strings.CutSuffix(fi.Name(), stagedManifestSuffix)
+		if !ok || fi.IsDir() {
+			continue
+		}
+		upload, err := s.loadStagedUpload(id)
+		if err != nil {
+			log.Printf("failed to load the staged upload (id=%s): %v", id, err)
+			continue
+		}
+		uploads = append(uploads, upload)
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].StagedAt.Before(uploads[j].StagedAt) })
+	return http.StatusOK, StagedUploadsResult{true, uploads}
+}
+
+// handlePromote publishes the staged upload under the document root.
+// An admin token is required if authentication is enabled.
+func (s *Server) handlePromote(w http.ResponseWriter, r *http.Request) (int, any) {
+	if s.EnableAuth && !isAdmin(r) {
+		return http.StatusForbidden, fmt.Errorf("admin token is required")
+	}
+	id := mux.Vars(r)["id"]
+	upload, err := s.loadStagedUpload(id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return http.StatusNotFound, fmt.Errorf("staged upload not found")
+		}
+		log.Printf("failed to load the staged upload (id=%s): %v", id, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to load the staged upload")
+	}
+	path := canonicalPath(strings.TrimPrefix(upload.Path, "/files"))
+
+	unlock := s.lockPath(path)
+	defer unlock()
+	allowOverwrite := parseBoolishValue(r.URL.Query().Get(OverwriteQueryKey))
+	if status, err := s.checkDestinationType(path); err != nil {
+		return status, err
+	}
+	if status, err := s.checkWritable(r, path, allowOverwrite); err != nil {
+		return status, err
+	}
+	sums := s.newChecksums()
+	if err := s.promoteStagedUpload(id, path, sums); err != nil {
+		log.Printf("failed to promote the staged upload (id=%s, path=%s): %v", id, path, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to promote the staged upload")
+	}
+	if err := s.saveUserMetadata(path, upload.Metadata); err != nil {
+		log.Printf("failed to store the user metadata (path=%s): %v", path, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to store the metadata")
+	}
+	if s.owners != nil {
+		// the owner is the token which uploaded the content, not the admin promoting it
+		if err := s.owners.Set(path, upload.Owner); err != nil {
+			log.Printf("failed to store the owner (path=%s): %v", path, err)
+		}
+	}
+	s.discardStagedUpload(id)
+	log.Printf("promoted %s to %s (request_id=%s)", id, path, requestID(r.Context()))
+	if s.index != nil {
+		if err := s.index.Update(s.fs, path); err != nil {
+			log.Printf("failed to update the index (path=%s): %v", path, err)
+		}
+	}
+	if s.listingCache != nil {
+		s.listingCache.Invalidate(path)
+	}
+	return s.respondUploaded(w, r, http.StatusCreated, filesURLPath(path), sums)
+}
+
+// promoteStagedUpload copies the staged content of `id` to `path` in the document root. `sums` are computed over the content.
+// The staging directory and the document root may be on different file systems, so the content is copied next to
+// `path` and then renamed to `path` in the same way as writeUploadedFile.
+func (s *Server) promoteStagedUpload(id, path string, sums checksums) error {
+	src, err := s.staging.Open(id)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dirsPath := filepath.Dir(path)
+	if err := s.withRetry(func() error { return s.fs.MkdirAll(dirsPath, s.dirMode()) }); err != nil {
+		return err
+	}
+	tmpPath := path + UploadingFileSuffix
+	dst, err := s.fs.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, s.fileMode())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := s.fs.Remove(tmpPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("failed to remove the temporary file (path=%s): %v", tmpPath, err)
+		}
+	}()
+	_, err = io.Copy(sums.Writer(dst), src)
+	if err == nil && s.SyncOnUpload {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.fs.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	if s.SyncOnUpload {
+		s.syncDir(dirsPath)
+	}
+	return nil
+}
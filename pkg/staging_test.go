@@ -0,0 +1,221 @@
+package simpleuploadserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_Staging(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	staging := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+		StagingDir:    "/var/staging",
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot), staging: staging}
+	handler := server.router()
+
+	// upload to staging
+	req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "staged.txt", bytes.NewBufferString("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Meta-Author", "alice")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("upload status = %d, want = %d", rr.Code, http.StatusAccepted)
+	}
+	var staged UploadStagedResult
+	if err := json.NewDecoder(rr.Body).Decode(&staged); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	if !staged.OK || staged.ID == "" || staged.Path != "/files/staged.txt" {
+		t.Errorf("result = %+v, want the ID and /files/staged.txt", staged)
+	}
+	if exists, _ := afero.Exists(fs, path.Join(docRoot, "staged.txt")); exists {
+		t.Errorf("staged file should not be in the document root")
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/files/staged.txt", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("download status before promotion = %d, want = %d", rr.Code, http.StatusNotFound)
+	}
+
+	// list staged uploads
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/staged", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list status = %d, want = %d", rr.Code, http.StatusOK)
+	}
+	var list StagedUploadsResult
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	if len(list.Uploads) != 1 {
+		t.Fatalf("uploads = %+v, want 1 upload", list.Uploads)
+	}
+	if got := list.Uploads[0]; got.ID != staged.ID || got.Path != "/files/staged.txt" || got.Size != 5 || got.Metadata["author"] != "alice" {
+		t.Errorf("uploads[0] = %+v, want the staged upload", got)
+	}
+
+	// promote then download
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/promote/"+staged.ID, nil))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("promote status = %d, want = %d: %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	var promoted SuccessfullyUploadedResult
+	if err := json.NewDecoder(rr.Body).Decode(&promoted); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	if want := uploadedResult("/files/staged.txt", []byte("hello")); promoted != want {
+		t.Errorf("result = %+v, want = %+v", promoted, want)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/files/staged.txt", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "hello" {
+		t.Errorf("download = %d %q, want = 200 \"hello\"", rr.Code, rr.Body.String())
+	}
+	if m, err := server.loadUserMetadata("/staged.txt"); err != nil || m["author"] != "alice" {
+		t.Errorf("user metadata = %v (err=%v), want author=alice", m, err)
+	}
+	if infos, _ := afero.ReadDir(staging, "/"); len(infos) != 0 {
+		t.Errorf("staging directory should be empty after promotion, got %d files", len(infos))
+	}
+
+	// promoted uploads are gone from staging
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/promote/"+staged.ID, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("second promote status = %d, want = %d", rr.Code, http.StatusNotFound)
+	}
+
+	// the existing file is checked on promotion
+	req, err = makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "staged.txt", bytes.NewBufferString("again"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if err := json.NewDecoder(rr.Body).Decode(&staged); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/promote/"+staged.ID, nil))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("conflicting promote status = %d, want = %d", rr.Code, http.StatusConflict)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/promote/"+staged.ID+"?overwrite=true", nil))
+	if rr.Code != http.StatusCreated {
+		t.Errorf("overwriting promote status = %d, want = %d", rr.Code, http.StatusCreated)
+	}
+	verifyLocalFile(t, fs, path.Join(docRoot, "staged.txt"), []byte("again"))
+}
+
+func TestServer_Staging_Auth(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:   docRoot,
+		MaxUploadSize:  16,
+		StagingDir:     "/var/staging",
+		EnableAuth:     true,
+		ReadOnlyTokens: []string{"ro-token"},
+		NamedTokens:    map[string]string{"alice": "alice-token"},
+		AdminTokens:    []string{"admin-token"},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot), staging: afero.NewMemMapFs(), owners: newFileOwners("")}
+	handler := server.router()
+	do := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "staged.txt", bytes.NewBufferString("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer alice-token")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("upload status = %d, want = %d", rr.Code, http.StatusAccepted)
+	}
+	var staged UploadStagedResult
+	if err := json.NewDecoder(rr.Body).Decode(&staged); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+
+	if rr := do(http.MethodGet, "/staged", "ro-token"); rr.Code != http.StatusForbidden {
+		t.Errorf("list by a read-only token: status = %d, want = %d", rr.Code, http.StatusForbidden)
+	}
+	if rr := do(http.MethodGet, "/staged", "alice-token"); rr.Code != http.StatusOK {
+		t.Errorf("list by a read-write token: status = %d, want = %d", rr.Code, http.StatusOK)
+	}
+	if rr := do(http.MethodPost, "/promote/"+staged.ID, "alice-token"); rr.Code != http.StatusForbidden {
+		t.Errorf("promote by a read-write token: status = %d, want = %d", rr.Code, http.StatusForbidden)
+	}
+	if rr := do(http.MethodPost, "/promote/"+staged.ID, "admin-token"); rr.Code != http.StatusCreated {
+		t.Fatalf("promote by an admin token: status = %d, want = %d: %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	verifyLocalFile(t, fs, path.Join(docRoot, "staged.txt"), []byte("hello"))
+	// the uploader owns the promoted file
+	if owner := server.owners.Get("/staged.txt"); owner != "alice" {
+		t.Errorf("owner = %q, want = alice", owner)
+	}
+}
+
+func TestServer_Staging_Timeout(t *testing.T) {
+	docRoot := "/opt/app"
+	staging := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:      docRoot,
+		MaxUploadSize:     1024,
+		MaxUploadDuration: 30,
+		StagingDir:        "/var/staging",
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(afero.NewMemMapFs(), docRoot), staging: staging}
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		fw, err := mw.CreateFormFile(FormFileKey, "slow.txt")
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		fw.Write([]byte("hello, "))
+		// the rest arrives after MaxUploadDuration while the content is being staged
+		time.Sleep(50 * time.Millisecond)
+		fw.Write([]byte("world"))
+		mw.Close()
+		pw.Close()
+	}()
+	req := httptest.NewRequest(http.MethodPut, "/files/slow.txt", pr)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rr := httptest.NewRecorder()
+	server.router().ServeHTTP(rr, req)
+	pr.Close()
+	if rr.Code != http.StatusRequestTimeout {
+		t.Errorf("status = %d, want = %d: %s", rr.Code, http.StatusRequestTimeout, rr.Body.String())
+	}
+	if infos, _ := afero.ReadDir(staging, "/"); len(infos) != 0 {
+		t.Errorf("the content should not be staged, got %d files", len(infos))
+	}
+}
@@ -0,0 +1,77 @@
+package simpleuploadserver
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultPopularFilesLimit is the number of files returned by /stats/popular if `limit` is not given.
+var DefaultPopularFilesLimit = 10
+
+type FileAccessCount struct {
+	Path  string `json:"path"`
+	Count int64  `json:"count"`
+}
+
+type PopularFilesResult struct {
+	OK    bool              `json:"ok"`
+	Files []FileAccessCount `json:"files"`
+}
+
+// accessStats counts downloads per file. It is safe for concurrent use.
+type accessStats struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func newAccessStats() *accessStats {
+	return &accessStats{counts: map[string]int64{}}
+}
+
+func (st *accessStats) Increment(path string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.counts[path]++
+}
+
+// Remove forgets the count of the file at `path`.
+func (st *accessStats) Remove(path string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.counts, path)
+}
+
+// Popular returns up to `limit` most accessed files in descending order of the count.
+func (st *accessStats) Popular(limit int) []FileAccessCount {
+	st.mu.Lock()
+	list := make([]FileAccessCount, 0, len(st.counts))
+	for p, c := range st.counts {
+		list = append(list, FileAccessCount{p, c})
+	}
+	st.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Path < list[j].Path
+	})
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+func (s *Server) handlePopular(w http.ResponseWriter, r *http.Request) (int, any) {
+	limit := DefaultPopularFilesLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return http.StatusBadRequest, fmt.Errorf("limit must be a positive integer")
+		}
+		limit = n
+	}
+	return http.StatusOK, PopularFilesResult{true, s.stats.Popular(limit)}
+}
@@ -0,0 +1,67 @@
+package simpleuploadserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_PopularFiles(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := afero.WriteFile(fs, path.Join(docRoot, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := ServerConfig{DocumentRoot: docRoot, EnableStats: true}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot), stats: newAccessStats()}
+	router := server.router()
+
+	downloads := []struct {
+		method string
+		path   string
+		times  int
+	}{
+		{http.MethodGet, "/files/a.txt", 1},
+		{http.MethodGet, "/files/b.txt", 3},
+		{http.MethodGet, "/files/c.txt", 2},
+		// HEAD and failed requests are not counted
+		{http.MethodHead, "/files/a.txt", 5},
+		{http.MethodGet, "/files/missing.txt", 5},
+	}
+	for _, d := range downloads {
+		for i := 0; i < d.times; i++ {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(d.method, d.path, nil))
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/stats/popular?limit=2", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+	}
+	var result PopularFilesResult
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	want := PopularFilesResult{true, []FileAccessCount{
+		{"/files/b.txt", 3},
+		{"/files/c.txt", 2},
+	}}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %+v, want = %+v", result, want)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/stats/popular?limit=zero", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid limit: status = %d, want = %d", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,128 @@
+package simpleuploadserver
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TokenCacheSize is the number of presented tokens whose verification results are kept.
+var TokenCacheSize = 1024
+
+// compareHash is bcrypt.CompareHashAndPassword, replaced in the tests to count the comparisons.
+var compareHash = bcrypt.CompareHashAndPassword
+
+// tokenGrant is what a token is allowed to do. The zero value is of an unknown token.
+type tokenGrant struct {
+	scope TokenScope
+	// the name in NamedTokens, empty if the token has no name
+	name string
+}
+
+func (g tokenGrant) valid() bool {
+	return g.scope != ""
+}
+
+// tokenEntry is a configured token, which may be a bcrypt hash, with the grant of all the token lists it is in.
+type tokenEntry struct {
+	token string
+	grant tokenGrant
+}
+
+// tokenTable resolves the presented tokens against all the token lists at once. It is safe for concurrent use.
+type tokenTable struct {
+	entries []tokenEntry
+
+	mu sync.Mutex
+	// verified grants keyed by the SHA-256 of the presented token, including the zero grant of unknown tokens
+	cache map[[sha256.Size]byte]tokenGrant
+}
+
+var scopeRanks = map[TokenScope]int{ScopeReadOnly: 1, ScopeReadWrite: 2, ScopeAdmin: 3}
+
+func newTokenTable(config ServerConfig) *tokenTable {
+	grants := map[string]tokenGrant{}
+	add := func(token string, grant tokenGrant) {
+		g := grants[token]
+		if scopeRanks[grant.scope] > scopeRanks[g.scope] {
+			g.scope = grant.scope
+		}
+		if g.name == "" {
+			g.name = grant.name
+		}
+		grants[token] = g
+	}
+	for _, token := range config.ReadOnlyTokens {
+		add(token, tokenGrant{scope: ScopeReadOnly})
+	}
+	for _, token := range config.ReadWriteTokens {
+		add(token, tokenGrant{scope: ScopeReadWrite})
+	}
+	names := make([]string, 0, len(config.NamedTokens))
+	for name := range config.NamedTokens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(config.NamedTokens[name], tokenGrant{scope: ScopeReadWrite, name: name})
+	}
+	for _, token := range config.AdminTokens {
+		add(token, tokenGrant{scope: ScopeAdmin})
+	}
+	t := &tokenTable{entries: make([]tokenEntry, 0, len(grants)), cache: map[[sha256.Size]byte]tokenGrant{}}
+	for token, grant := range grants {
+		t.entries = append(t.entries, tokenEntry{token, grant})
+	}
+	return t
+}
+
+// Resolve returns the grant of the presented `token`. Each configured token is compared once, and the result is
+// cached so that a bcrypt hash is not compared again for the same token.
+func (t *tokenTable) Resolve(token string) tokenGrant {
+	key := sha256.Sum256([]byte(token))
+	t.mu.Lock()
+	grant, ok := t.cache[key]
+	t.mu.Unlock()
+	if ok {
+		return grant
+	}
+	for _, entry := range t.entries {
+		// all of them are compared so that the response time does not tell which one matched
+		if matchToken(entry.token, token) {
+			grant = entry.grant
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.cache) >= TokenCacheSize {
+		clear(t.cache)
+	}
+	t.cache[key] = grant
+	return grant
+}
+
+// tokens returns the token table of the configuration, which is built on the first call.
+func (s *Server) tokens() *tokenTable {
+	s.tokensOnce.Do(func() {
+		s.tokenTable = newTokenTable(s.ServerConfig)
+	})
+	return s.tokenTable
+}
+
+// matchToken reports whether the presented `token` is the configured token `t`, which may be a bcrypt hash.
+// Plaintext tokens are compared in constant time.
+func matchToken(t, token string) bool {
+	if isHashedToken(t) {
+		return compareHash([]byte(t), []byte(token)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1
+}
+
+// isHashedToken reports whether the configured token `t` is a bcrypt hash.
+func isHashedToken(t string) bool {
+	return strings.HasPrefix(t, "$2a$") || strings.HasPrefix(t, "$2b$")
+}
@@ -0,0 +1,160 @@
+package simpleuploadserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// TrashDir is the directory under the document root to keep the files deleted while SoftDelete is enabled.
+var TrashDir = "/.trash"
+
+// TrashPurgeInterval is the interval to remove the files kept in TrashDir longer than TrashRetention.
+var TrashPurgeInterval = time.Minute
+
+type TrashRestoredResult struct {
+	OK   bool   `json:"ok"`
+	Path string `json:"path"`
+}
+
+// trashPath returns the path in TrashDir to keep the file at the canonical path `p`.
+func trashPath(p string) string {
+	return path.Join(TrashDir, p)
+}
+
+// moveToTrash moves the file at `p` and its user metadata to TrashDir, replacing the file deleted at the same path before.
+// The modification time of the trashed file is set to the current time to count the retention from the deletion.
+func (s *Server) moveToTrash(p string) error {
+	dst := trashPath(p)
+	if err := s.fs.MkdirAll(filepath.Dir(dst), s.dirMode()); err != nil {
+		return err
+	}
+	if err := s.fs.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := s.fs.Rename(p, dst); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := s.fs.Chtimes(dst, now, now); err != nil {
+		log.Printf("failed to update the time of the trashed file (path=%s): %v", dst, err)
+	}
+	return s.moveUserMetadata(p, dst)
+}
+
+// moveUserMetadata moves the user metadata of the file at `from` to `to`. The stale metadata at `to` is removed if
+// `from` has none.
+func (s *Server) moveUserMetadata(from, to string) error {
+	src, dst := from+UserMetadataFileSuffix, to+UserMetadataFileSuffix
+	if err := s.fs.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := s.fs.Rename(src, dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// handleTrashRestore moves the file deleted at the path in the request body back from TrashDir.
+func (s *Server) handleTrashRestore(w http.ResponseWriter, r *http.Request) (int, any) {
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid request body")
+	}
+	p := canonicalPath(s.normalizeName(strings.TrimPrefix(req.Path, "/files")))
+	if p == "/" {
+		return http.StatusBadRequest, fmt.Errorf("no file name is specified")
+	}
+	if isReservedPath(p) {
+		return http.StatusBadRequest, errReservedPath
+	}
+
+	unlock := s.lockPath(p)
+	defer unlock()
+	src := trashPath(p)
+	if isDir, err := afero.IsDir(s.fs, src); err != nil || isDir {
+		return http.StatusNotFound, fmt.Errorf("file not found in the trash")
+	}
+	allowOverwrite := parseBoolishValue(r.URL.Query().Get(OverwriteQueryKey))
+	if status, err := s.checkDestinationType(p); err != nil {
+		return status, err
+	}
+	if status, err := s.checkWritable(r, p, allowOverwrite); err != nil {
+		return status, err
+	}
+	if err := s.fs.MkdirAll(filepath.Dir(p), s.dirMode()); err != nil {
+		log.Printf("failed to create directories (path=%s): %v", filepath.Dir(p), err)
+		return http.StatusInternalServerError, fmt.Errorf("cannot create directories")
+	}
+	if err := s.fs.Rename(src, p); err != nil {
+		log.Printf("failed to restore the file (from=%s, to=%s): %v", src, p, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to restore the file")
+	}
+	if err := s.moveUserMetadata(src, p); err != nil {
+		log.Printf("failed to restore the user metadata (path=%s): %v", p, err)
+	}
+	log.Printf("restored %s from the trash (request_id=%s)", p, requestID(r.Context()))
+	if s.index != nil {
+		if err := s.index.Update(s.fs, p); err != nil {
+			log.Printf("failed to update the index (path=%s): %v", p, err)
+		}
+	}
+	if s.listingCache != nil {
+		s.listingCache.Invalidate(p)
+		s.listingCache.Invalidate(src)
+	}
+	return http.StatusOK, TrashRestoredResult{true, filesURLPath(p)}
+}
+
+// purgeTrash removes the files kept in TrashDir since before `deadline`.
+func (s *Server) purgeTrash(deadline time.Time) error {
+	err := afero.Walk(s.fs, TrashDir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || strings.HasSuffix(p, UserMetadataFileSuffix) || !fi.ModTime().Before(deadline) {
+			return nil
+		}
+		if err := s.fs.Remove(p); err != nil {
+			return err
+		}
+		if err := s.fs.Remove(p + UserMetadataFileSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		log.Printf("purged %s from the trash", p)
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// runTrashPurger purges the trash every TrashPurgeInterval until `ctx` is done.
+func (s *Server) runTrashPurger(ctx context.Context) {
+	retention := time.Duration(s.TrashRetention) * time.Millisecond
+	t := time.NewTicker(TrashPurgeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			if err := s.purgeTrash(now.Add(-retention)); err != nil {
+				log.Printf("failed to purge the trash: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package simpleuploadserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_SoftDelete(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot: docRoot,
+		SoftDelete:   true,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	handler := server.router()
+	content := []byte("hello, trash")
+	if err := afero.WriteFile(fs, path.Join(docRoot, "sub/foo.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, path.Join(docRoot, "sub/foo.txt"+UserMetadataFileSuffix), []byte(`{"author":"alice"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// delete moves the file to the trash
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/files/sub/foo.txt", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d, want = %d", rr.Code, http.StatusNoContent)
+	}
+	if exists, _ := afero.Exists(fs, path.Join(docRoot, "sub/foo.txt")); exists {
+		t.Errorf("deleted file should not exist")
+	}
+	verifyLocalFile(t, fs, path.Join(docRoot, TrashDir, "sub/foo.txt"), content)
+	if exists, _ := afero.Exists(fs, path.Join(docRoot, TrashDir, "sub/foo.txt"+UserMetadataFileSuffix)); !exists {
+		t.Errorf("user metadata should be moved to the trash")
+	}
+
+	// restore brings the file back
+	restore := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trash/restore", strings.NewReader(`{"path":"/files/sub/foo.txt"}`)))
+		return rr
+	}
+	rr = restore()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("restore status = %d, want = %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var result TrashRestoredResult
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	if !result.OK || result.Path != "/files/sub/foo.txt" {
+		t.Errorf("result = %+v, want /files/sub/foo.txt", result)
+	}
+	verifyLocalFile(t, fs, path.Join(docRoot, "sub/foo.txt"), content)
+	if exists, _ := afero.Exists(fs, path.Join(docRoot, "sub/foo.txt"+UserMetadataFileSuffix)); !exists {
+		t.Errorf("user metadata should be restored")
+	}
+
+	// the trash is empty now
+	if rr := restore(); rr.Code != http.StatusNotFound {
+		t.Errorf("restore status of a missing file = %d, want = %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestServer_SoftDelete_TrashIsHidden(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	config := ServerConfig{
+		DocumentRoot:           docRoot,
+		MaxUploadSize:          16,
+		SoftDelete:             true,
+		EnableDirectoryListing: true,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	handler := server.router()
+	content := []byte("hello, trash")
+	if err := afero.WriteFile(fs, path.Join(docRoot, "foo.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, target string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := do(http.MethodDelete, "/files/foo.txt", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d, want = %d", rr.Code, http.StatusNoContent)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   int
+	}{
+		{"deleted file", http.MethodGet, "/files/foo.txt", http.StatusNotFound},
+		{"trashed file", http.MethodGet, "/files/.trash/foo.txt", http.StatusNotFound},
+		{"trashed file in another case", http.MethodGet, "/files/.TRASH/foo.txt", http.StatusNotFound},
+		{"trashed file by HEAD", http.MethodHead, "/files/.trash/foo.txt", http.StatusNotFound},
+		{"metadata of trashed file", http.MethodGet, "/files/.trash/foo.txt?meta=true", http.StatusNotFound},
+		{"trash directory", http.MethodGet, "/files/.trash", http.StatusNotFound},
+		{"size of trash directory", http.MethodGet, "/files/.trash?size=true", http.StatusNotFound},
+		{"trash directory by PROPFIND", MethodPropfind, "/files/.trash/", http.StatusNotFound},
+		{"upload into trash by PUT", http.MethodPut, "/files/.trash/foo.txt", http.StatusBadRequest},
+		{"delete trashed file", http.MethodDelete, "/files/.trash/foo.txt", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := do(tt.method, tt.target, "forged"); rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+		})
+	}
+
+	t.Run("upload into trash by POST", func(t *testing.T) {
+		req, err := makeFormRequest(&url.URL{Path: "/upload"}, http.MethodPost, "foo.txt", strings.NewReader("forged"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(UploadPathHeader, ".trash/foo.txt")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want = %d", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("trash is not listed", func(t *testing.T) {
+		rr := do(http.MethodGet, "/files/", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+		}
+		if body := rr.Body.String(); strings.Contains(body, ".trash") {
+			t.Errorf("listing = %s, should not contain the trash", body)
+		}
+		rr = do(MethodPropfind, "/files/", "")
+		if body := rr.Body.String(); strings.Contains(body, ".trash") {
+			t.Errorf("PROPFIND = %s, should not contain the trash", body)
+		}
+	})
+
+	t.Run("trash is not counted", func(t *testing.T) {
+		rr := do(http.MethodGet, "/files/?size=true", "")
+		if body, want := rr.Body.String(), `{"ok":true,"size":0,"file_count":0}`; body != want {
+			t.Errorf("body = %s, want = %s", body, want)
+		}
+	})
+
+	// the trashed file is kept as it is
+	verifyLocalFile(t, fs, path.Join(docRoot, TrashDir, "foo.txt"), content)
+}
+
+func TestServer_SoftDelete_RestoreConflict(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	server := Server{ServerConfig: ServerConfig{DocumentRoot: docRoot, SoftDelete: true}, fs: afero.NewBasePathFs(fs, docRoot)}
+	handler := server.router()
+	if err := afero.WriteFile(fs, path.Join(docRoot, TrashDir, "foo.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, path.Join(docRoot, "foo.txt"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trash/restore", strings.NewReader(`{"path":"/files/foo.txt"}`)))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("restore status = %d, want = %d", rr.Code, http.StatusConflict)
+	}
+	verifyLocalFile(t, fs, path.Join(docRoot, "foo.txt"), []byte("new"))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trash/restore?overwrite=true", strings.NewReader(`{"path":"/files/foo.txt"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("restore status with overwrite = %d, want = %d", rr.Code, http.StatusOK)
+	}
+	verifyLocalFile(t, fs, path.Join(docRoot, "foo.txt"), []byte("old"))
+}
+
+func TestServer_PurgeTrash(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	server := Server{ServerConfig: ServerConfig{DocumentRoot: docRoot, SoftDelete: true}, fs: afero.NewBasePathFs(fs, docRoot)}
+	for _, name := range []string{"old.txt", "new.txt"} {
+		if err := afero.WriteFile(fs, path.Join(docRoot, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.moveToTrash("/old.txt"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if err := server.moveToTrash("/new.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.purgeTrash(deadline); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := afero.Exists(fs, path.Join(docRoot, TrashDir, "old.txt")); exists {
+		t.Errorf("old.txt should be purged")
+	}
+	verifyLocalFile(t, fs, path.Join(docRoot, TrashDir, "new.txt"), []byte("new.txt"))
+}
+
+func TestServer_PurgeTrash_NoTrash(t *testing.T) {
+	server := Server{fs: afero.NewMemMapFs()}
+	if err := server.purgeTrash(time.Now()); err != nil {
+		t.Errorf("purgeTrash() error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,351 @@
+package simpleuploadserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// UploadPathHeader is the header to specify the destination path of POST request.
+var UploadPathHeader = "X-Upload-Path"
+
+// sanitizeUploadPath validates the path given by the client and returns it in the clean form starting with `/`.
+// Paths containing `..`, backslashes or control characters, and paths to a directory are rejected.
+func sanitizeUploadPath(p string) (string, error) {
+	if strings.Contains(p, `\`) || strings.ContainsFunc(p, unicode.IsControl) {
+		return "", fmt.Errorf("invalid upload path")
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return "", fmt.Errorf("invalid upload path")
+		}
+	}
+	if strings.HasSuffix(p, "/") {
+		return "", fmt.Errorf("invalid upload path")
+	}
+	clean := path.Clean("/" + p)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid upload path")
+	}
+	return clean, nil
+}
+
+// windowsReservedNames are the device names which cannot be used as file names on Windows, even with an extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// checkWindowsCompatible returns an error if any segment of `p` cannot be used as a file name on Windows, that is, it
+// ends with a space or a dot, or it is a reserved device name such as `CON` or `NUL.txt`. It is case-insensitive.
+func checkWindowsCompatible(p string) error {
+	for _, seg := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		if strings.HasSuffix(seg, " ") || strings.HasSuffix(seg, ".") {
+			return fmt.Errorf("%q is not a valid file name on Windows: it ends with a space or a dot", seg)
+		}
+		base, _, _ := strings.Cut(seg, ".")
+		if windowsReservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+			return fmt.Errorf("%q is not a valid file name on Windows: it is a reserved name", seg)
+		}
+	}
+	return nil
+}
+
+// canonicalPath returns `p` relative to the document root in the canonical form, which is cleaned and starts with `/`.
+func canonicalPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// UploadingFileSuffix is appended to the path of the file being uploaded until the whole content is written.
+var UploadingFileSuffix = ".uploading"
+
+// errReservedPath is the error for uploads to the paths used by the server itself.
+var errReservedPath = errors.New("the file name is reserved")
+
+// isReservedPath reports whether `p` is used by the server itself, such as the file being uploaded, the partial file of
+// a chunked upload, the user metadata of a file and TrashDir. The files at such paths cannot be uploaded, downloaded
+// nor listed.
+func isReservedPath(p string) bool {
+	if strings.HasSuffix(p, UploadingFileSuffix) || strings.HasSuffix(p, PartialFileSuffix) ||
+		strings.HasSuffix(p, UserMetadataFileSuffix) {
+		return true
+	}
+	// compared case-insensitively since the file system may not tell the case
+	p, trash := strings.ToLower(canonicalPath(p)), strings.ToLower(TrashDir)
+	return p == trash || strings.HasPrefix(p, trash+"/")
+}
+
+// normalizeName returns `p` in Unicode NFC if NormalizeUnicode is set, or `p` as is otherwise.
+// macOS gives names in NFD, so the same name may come in both forms.
+func (s *Server) normalizeName(p string) string {
+	if !s.NormalizeUnicode {
+		return p
+	}
+	return norm.NFC.String(p)
+}
+
+// filesURLPath returns the path to access the file at the canonical path `p` in this API.
+func filesURLPath(p string) string {
+	return "/files" + p
+}
+
+// preferredReturn returns the value of `return` preference in Prefer header (RFC 7240), such as `minimal`.
+func preferredReturn(r *http.Request) string {
+	for _, v := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(v, ",") {
+			// parameters of the preference are ignored
+			pref, _, _ = strings.Cut(pref, ";")
+			name, value, _ := strings.Cut(strings.TrimSpace(pref), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "return") {
+				return strings.ToLower(strings.Trim(strings.TrimSpace(value), `"`))
+			}
+		}
+	}
+	return ""
+}
+
+// parseIfNewerQuery parses IfNewerQueryKey as RFC 3339 timestamp. It returns zero time if the parameter is not given.
+func parseIfNewerQuery(q url.Values) (time.Time, error) {
+	v := q.Get(IfNewerQueryKey)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s parameter: %w", IfNewerQueryKey, err)
+	}
+	return t, nil
+}
+
+// openUploadedFile returns the uploaded content to be stored at `path`, which is empty if the name is not given yet.
+// If the request is not multipart/form-data, the request body is taken as the content. It is streamed into the
+// destination if it is read only once, and spooled by spoolRequestBody otherwise.
+func (s *Server) openUploadedFile(w http.ResponseWriter, r *http.Request, path string) (multipart.File, *multipart.FileHeader, int, error) {
+	if !isMultipartRequest(r) {
+		if !s.canStream(r, path, "") {
+			return s.spoolRequestBody(w, r)
+		}
+		src := limitUploadSize(w, r.Body, s.MaxUploadSize)
+		return streamedFile{src}, &multipart.FileHeader{Header: rawBodyHeader(r), Size: -1}, 0, nil
+	}
+
+	return s.readMultipartFile(w, r, path)
+}
+
+// readMultipartFile reads the part of FormFieldName from the multipart body of `r` without parsing the whole form.
+// The part is opened by openPart, so the memory usage does not grow with the upload size.
+func (s *Server) readMultipartFile(w http.ResponseWriter, r *http.Request, path string) (multipart.File, *multipart.FileHeader, int, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		log.Printf("failed to read multipart form: %v", err)
+		return nil, nil, http.StatusInternalServerError, fmt.Errorf("cannot obtain the uploaded content")
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			if errors.Is(err, errUploadTimeout) {
+				return nil, nil, http.StatusRequestTimeout, errUploadTimeout
+			}
+			if errors.Is(err, io.EOF) {
+				err = fmt.Errorf("no %s part", s.formFieldName())
+			}
+			log.Printf("failed to obtain form file: %v", err)
+			return nil, nil, http.StatusInternalServerError, fmt.Errorf("cannot obtain the uploaded content")
+		}
+		// the rest of other parts is skipped by NextPart
+		if part.FormName() != s.formFieldName() {
+			continue
+		}
+		return s.openPart(w, r, part, path)
+	}
+}
+
+// openPart returns the content of `part` uploaded to `path`. The part is streamed into the destination if the content
+// is read only once, and spooled by spoolPart otherwise.
+func (s *Server) openPart(w http.ResponseWriter, r *http.Request, part *multipart.Part, path string) (multipart.File, *multipart.FileHeader, int, error) {
+	if !s.canStream(r, path, part.FileName()) {
+		return s.spoolPart(w, part)
+	}
+	// the part is not closed since closing drains the rest of it, which must not be read beyond the size limit
+	src := limitUploadSize(w, io.NopCloser(part), s.MaxUploadSize)
+	return streamedFile{src}, &multipart.FileHeader{Filename: part.FileName(), Header: part.Header, Size: -1}, 0, nil
+}
+
+// canStream reports whether the content named `filename` and uploaded to `path` is read only once. It is read again to
+// detect the content type for MaxUploadSizeByType, and to generate the file name when the name is not given.
+func (s *Server) canStream(r *http.Request, path, filename string) bool {
+	if len(s.MaxUploadSizeByType) > 0 {
+		return false
+	}
+	if path != "" || r.Header.Get(UploadPathHeader) != "" {
+		return true
+	}
+	return filename != "" && !s.GenerateFileNames
+}
+
+// errNotSeekable is returned by streamedFile, which can be read only once.
+var errNotSeekable = errors.New("the uploaded content is not seekable")
+
+// streamedFile is the content read directly from the request.
+type streamedFile struct {
+	io.ReadCloser
+}
+
+func (streamedFile) ReadAt([]byte, int64) (int, error) {
+	return 0, errNotSeekable
+}
+
+func (streamedFile) Seek(int64, int) (int64, error) {
+	return 0, errNotSeekable
+}
+
+// spoolPart reads the content of `part` so that it can be read more than once. Up to MultipartMaxMemory bytes of the
+// content are kept in memory and the rest is stored in a temporary file. Reading stops as soon as the content exceeds
+// the size limit.
+func (s *Server) spoolPart(w http.ResponseWriter, part *multipart.Part) (multipart.File, *multipart.FileHeader, int, error) {
+	maxMemory := s.MultipartMaxMemory
+	if maxMemory <= 0 {
+		maxMemory = DefaultMultipartMaxMemory
+	}
+	limit := s.maxUploadSizeLimit()
+	f, size, err := spool(limitUploadSize(w, part, limit), s.tempDir(), maxMemory)
+	if err != nil {
+		if isMaxBytesError(err) {
+			return nil, nil, http.StatusRequestEntityTooLarge, sizeLimitError{limit}
+		}
+		if errors.Is(err, errUploadTimeout) {
+			return nil, nil, http.StatusRequestTimeout, errUploadTimeout
+		}
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			log.Printf("failed to store the uploaded content temporarily (dir=%s): %v", s.tempDir(), err)
+			return nil, nil, http.StatusInternalServerError, fmt.Errorf("cannot store the uploaded content in the temporary directory")
+		}
+		log.Printf("failed to read the uploaded content: %v", err)
+		return nil, nil, http.StatusInternalServerError, fmt.Errorf("cannot obtain the uploaded content")
+	}
+	return f, &multipart.FileHeader{Filename: part.FileName(), Header: part.Header, Size: size}, 0, nil
+}
+
+// formFieldName returns the name of the form field carrying the uploaded file.
+func (s *Server) formFieldName() string {
+	if s.FormFieldName == "" {
+		return FormFileKey
+	}
+	return s.FormFieldName
+}
+
+// tempDir returns the directory to store the uploaded contents temporarily.
+func (s *Server) tempDir() string {
+	if s.MultipartTempDir != "" {
+		return s.MultipartTempDir
+	}
+	return os.TempDir()
+}
+
+// spool reads `src` to the end so that it can be read more than once.
+// The content is kept in memory if it is not larger than `maxMemory`, or stored in a temporary file in `dir` otherwise.
+func spool(src io.Reader, dir string, maxMemory int64) (multipart.File, int64, error) {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, src, maxMemory+1)
+	if errors.Is(err, io.EOF) {
+		return memoryFile{bytes.NewReader(buf.Bytes())}, n, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.CreateTemp(dir, "simple-upload-server-multipart-")
+	if err != nil {
+		return nil, 0, err
+	}
+	tmp := &spooledFile{f}
+	written, err := io.Copy(tmp, io.MultiReader(&buf, src))
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		return nil, 0, err
+	}
+	return tmp, written, nil
+}
+
+// memoryFile is the content kept in memory.
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error {
+	return nil
+}
+
+// spoolRequestBody stores the raw request body to a temporary file so that it can be read more than once.
+// The file name is empty since a raw body has no file name.
+func (s *Server) spoolRequestBody(w http.ResponseWriter, r *http.Request) (multipart.File, *multipart.FileHeader, int, error) {
+	f, err := os.CreateTemp(s.tempDir(), "simple-upload-server-raw-")
+	if err != nil {
+		log.Printf("failed to create a temp file (dir=%s): %v", s.tempDir(), err)
+		return nil, nil, http.StatusInternalServerError, fmt.Errorf("cannot store the uploaded content in the temporary directory")
+	}
+	tmp := &spooledFile{f}
+	limit := s.maxUploadSizeLimit()
+	written, err := io.Copy(tmp, limitUploadSize(w, r.Body, limit))
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		if isMaxBytesError(err) {
+			return nil, nil, http.StatusRequestEntityTooLarge, sizeLimitError{limit}
+		}
+		if errors.Is(err, errUploadTimeout) {
+			return nil, nil, http.StatusRequestTimeout, errUploadTimeout
+		}
+		log.Printf("failed to read the request body: %v", err)
+		return nil, nil, http.StatusInternalServerError, fmt.Errorf("cannot obtain the uploaded content")
+	}
+	return tmp, &multipart.FileHeader{Header: rawBodyHeader(r), Size: written}, 0, nil
+}
+
+// rawBodyHeader returns the header of the content sent as the raw body of `r`, as if it is a part of multipart/form-data.
+func rawBodyHeader(r *http.Request) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		header.Set("Content-Type", ct)
+	}
+	return header
+}
+
+// spooledFile is a temporary file which is removed on Close.
+type spooledFile struct {
+	*os.File
+}
+
+func (f *spooledFile) Close() error {
+	err := f.File.Close()
+	if rerr := os.Remove(f.Name()); rerr != nil {
+		log.Printf("failed to remove the temp file (path=%s): %v", f.Name(), rerr)
+	}
+	return err
+}
+
+func isMultipartRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
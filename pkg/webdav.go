@@ -0,0 +1,121 @@
+package simpleuploadserver
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// MethodPropfind is the WebDAV method to retrieve properties of the resources.
+const MethodPropfind = "PROPFIND"
+
+// davMultistatus is the body of 207 Multi-Status response defined in RFC 4918.
+// The names have the prefix because encoding/xml cannot declare a namespace prefix.
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	Namespace string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	DisplayName   string          `xml:"D:displayname"`
+	ResourceType  davResourceType `xml:"D:resourcetype"`
+	ContentLength *int64          `xml:"D:getcontentlength,omitempty"`
+	LastModified  string          `xml:"D:getlastmodified"`
+	ContentType   string          `xml:"D:getcontenttype,omitempty"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection"`
+}
+
+// handlePropfind responds to PROPFIND request with the properties of the file or the directory and its children.
+// This is a minimal read-only implementation: the request body is ignored and all properties are returned.
+// `Depth: infinity` is treated as `Depth: 1`.
+func (s *Server) handlePropfind(w http.ResponseWriter, r *http.Request) (int, any) {
+	requestPath, err := s.pathFromURL(r.URL)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if requestPath == "" {
+		requestPath = "/"
+	}
+	if isReservedPath(requestPath) {
+		return http.StatusNotFound, fmt.Errorf("file not found")
+	}
+	fi, err := s.fs.Stat(requestPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return http.StatusNotFound, fmt.Errorf("file not found")
+		}
+		log.Printf("failed to stat (path=%s): %v", requestPath, err)
+		return http.StatusInternalServerError, fmt.Errorf("stat failed")
+	}
+
+	ms := davMultistatus{Namespace: "DAV:"}
+	ms.Responses = append(ms.Responses, newDAVResponse(requestPath, fi))
+	if fi.IsDir() && r.Header.Get("Depth") != "0" {
+		entries, err := s.readDirectory(requestPath)
+		if err != nil {
+			log.Printf("failed to read the directory (path=%s): %v", requestPath, err)
+			return http.StatusInternalServerError, fmt.Errorf("failed to read the directory")
+		}
+		for _, entry := range entries {
+			ms.Responses = append(ms.Responses, newDAVResponse(path.Join(requestPath, entry.Name()), entry))
+		}
+	}
+
+	body, err := xml.Marshal(ms)
+	if err != nil {
+		log.Printf("failed to encode response: %v", err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to encode response")
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		log.Printf("failed to write response: %v", err)
+		return 0, nil
+	}
+	if _, err := w.Write(body); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+	return 0, nil
+}
+
+// newDAVResponse returns the properties of the file at `p`, which is relative to the document root.
+func newDAVResponse(p string, fi os.FileInfo) davResponse {
+	href := path.Join("/files", p)
+	prop := davProp{
+		DisplayName:  fi.Name(),
+		LastModified: fi.ModTime().UTC().Format(http.TimeFormat),
+	}
+	if fi.IsDir() {
+		href += "/"
+		prop.ResourceType.Collection = &struct{}{}
+	} else {
+		size := fi.Size()
+		prop.ContentLength = &size
+		prop.ContentType = mime.TypeByExtension(filepath.Ext(fi.Name()))
+	}
+	return davResponse{
+		Href:     (&url.URL{Path: href}).EscapedPath(),
+		Propstat: davPropstat{Prop: prop, Status: "HTTP/1.1 200 OK"},
+	}
+}
@@ -0,0 +1,101 @@
+package simpleuploadserver
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_Propfind(t *testing.T) {
+	docRoot := "/opt/app"
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, path.Join(docRoot, "dir/hello world.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MkdirAll(path.Join(docRoot, "dir/sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := ServerConfig{
+		DocumentRoot:  docRoot,
+		MaxUploadSize: 16,
+	}
+	server := Server{ServerConfig: config, fs: afero.NewBasePathFs(fs, docRoot)}
+	router := server.router()
+
+	type prop struct {
+		Collection    *struct{} `xml:"resourcetype>collection"`
+		ContentLength string    `xml:"getcontentlength"`
+		LastModified  string    `xml:"getlastmodified"`
+	}
+	type multistatus struct {
+		Responses []struct {
+			Href   string `xml:"href"`
+			Prop   prop   `xml:"propstat>prop"`
+			Status string `xml:"propstat>status"`
+		} `xml:"DAV: response"`
+	}
+	propfind := func(t *testing.T, target, depth string) (int, multistatus) {
+		req := httptest.NewRequest(MethodPropfind, target, nil)
+		if depth != "" {
+			req.Header.Set("Depth", depth)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var ms multistatus
+		if rr.Code == http.StatusMultiStatus {
+			if err := xml.NewDecoder(rr.Body).Decode(&ms); err != nil {
+				t.Fatalf("failed to decode multistatus: %v", err)
+			}
+		}
+		return rr.Code, ms
+	}
+
+	t.Run("directory", func(t *testing.T) {
+		status, ms := propfind(t, "/files/dir", "1")
+		if status != http.StatusMultiStatus {
+			t.Fatalf("status = %d, want = %d", status, http.StatusMultiStatus)
+		}
+		got := map[string]prop{}
+		for _, resp := range ms.Responses {
+			if resp.Status != "HTTP/1.1 200 OK" {
+				t.Errorf("status of %s = %s", resp.Href, resp.Status)
+			}
+			if resp.Prop.LastModified == "" {
+				t.Errorf("getlastmodified of %s is empty", resp.Href)
+			}
+			got[resp.Href] = resp.Prop
+		}
+		if len(got) != 3 {
+			t.Errorf("responses = %v, want 3 entries", got)
+		}
+		if p, ok := got["/files/dir/"]; !ok || p.Collection == nil {
+			t.Errorf("/files/dir/ = %+v, want a collection", p)
+		}
+		if p, ok := got["/files/dir/sub/"]; !ok || p.Collection == nil {
+			t.Errorf("/files/dir/sub/ = %+v, want a collection", p)
+		}
+		if p, ok := got["/files/dir/hello%20world.txt"]; !ok || p.Collection != nil || p.ContentLength != "5" {
+			t.Errorf("/files/dir/hello%%20world.txt = %+v, want a file of 5 bytes", p)
+		}
+	})
+
+	t.Run("depth 0", func(t *testing.T) {
+		status, ms := propfind(t, "/files/dir/", "0")
+		if status != http.StatusMultiStatus {
+			t.Fatalf("status = %d, want = %d", status, http.StatusMultiStatus)
+		}
+		if len(ms.Responses) != 1 || ms.Responses[0].Href != "/files/dir/" {
+			t.Errorf("responses = %+v, want only /files/dir/", ms.Responses)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if status, _ := propfind(t, "/files/missing", "1"); status != http.StatusNotFound {
+			t.Errorf("status = %d, want = %d", status, http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package simpleuploadserver
+
+import (
+	"net/http"
+	"strings"
+)
+
+// UploadConfigPath is the path to report the upload limits for clients to configure themselves.
+// It is available without authentication.
+var UploadConfigPath = "/.well-known/upload-config"
+
+type UploadConfigResult struct {
+	MaxUploadSize          int64    `json:"max_upload_size"`
+	MaxRequestBytes        int64    `json:"max_request_bytes"`
+	AllowedExtensions      []string `json:"allowed_extensions"`
+	BlockedExtensions      []string `json:"blocked_extensions"`
+	NamingStrategy         string   `json:"naming_strategy"`
+	ChunkedUploadSupported bool     `json:"chunked_upload_supported"`
+}
+
+// handleUploadConfig reports the limits applied to uploads. The sizes are 0 if unlimited.
+func (s *Server) handleUploadConfig(w http.ResponseWriter, r *http.Request) (int, any) {
+	namingStrategy := strings.ToLower(s.FileNamingStrategy)
+	if s.namer != nil {
+		namingStrategy = "custom"
+	} else if namingStrategy == "" {
+		namingStrategy = "uuid"
+	}
+	allowedExtensions := s.AllowedExtensions
+	if allowedExtensions == nil {
+		allowedExtensions = []string{}
+	}
+	blockedExtensions := s.BlockedExtensions
+	if blockedExtensions == nil {
+		blockedExtensions = []string{}
+	}
+	return http.StatusOK, UploadConfigResult{
+		MaxUploadSize:     s.MaxUploadSize,
+		MaxRequestBytes:   s.maxUploadSizeLimit(),
+		AllowedExtensions: allowedExtensions,
+		BlockedExtensions: blockedExtensions,
+		NamingStrategy:    namingStrategy,
+		// chunks are not forwarded to the upstream in proxy mode
+		ChunkedUploadSupported: s.ProxyUploadURL == "",
+	}
+}
@@ -0,0 +1,54 @@
+package simpleuploadserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_UploadConfig(t *testing.T) {
+	config := ServerConfig{
+		DocumentRoot:        "/opt/app",
+		MaxUploadSize:       1024,
+		MaxUploadSizeByType: map[string]int64{"image/": 4096},
+		AllowedExtensions:   []string{".jpg", ".png"},
+		BlockedExtensions:   []string{".exe"},
+		FileNamingStrategy:  "SHA256",
+		EnableAuth:          true,
+		ReadWriteTokens:     []string{"rw"},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewMemMapFs()}
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/upload-config", nil)
+	rr := httptest.NewRecorder()
+	server.router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want = %d", rr.Code, http.StatusOK)
+	}
+	var result UploadConfigResult
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	want := UploadConfigResult{
+		MaxUploadSize:          1024,
+		MaxRequestBytes:        4096,
+		AllowedExtensions:      []string{".jpg", ".png"},
+		BlockedExtensions:      []string{".exe"},
+		NamingStrategy:         "sha256",
+		ChunkedUploadSupported: true,
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %+v, want = %+v", result, want)
+	}
+
+	// other endpoints still require the token
+	req = httptest.NewRequest(http.MethodGet, "/strategies", nil)
+	rr = httptest.NewRecorder()
+	server.router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("status of /strategies = %d, want = %d", rr.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,36 @@
+package simpleuploadserver
+
+import "net/http"
+
+// TokenScope is what the token of a request is allowed to do.
+type TokenScope string
+
+const (
+	ScopeReadOnly  TokenScope = "read-only"
+	ScopeReadWrite TokenScope = "read-write"
+	ScopeAdmin     TokenScope = "admin"
+)
+
+type WhoAmIResult struct {
+	OK    bool       `json:"ok"`
+	Scope TokenScope `json:"scope"`
+	Name  string     `json:"name,omitempty"`
+}
+
+type tokenScopeKey struct{}
+
+// tokenScope returns the scope of the token of the request `r`. It is empty if authentication is disabled.
+func tokenScope(r *http.Request) TokenScope {
+	scope, _ := r.Context().Value(tokenScopeKey{}).(TokenScope)
+	return scope
+}
+
+// handleWhoAmI reports the scope of the token of the request.
+// Invalid tokens are rejected by authenticationMiddleware. Every request has read-write scope if authentication is disabled.
+func (s *Server) handleWhoAmI(w http.ResponseWriter, r *http.Request) (int, any) {
+	scope := tokenScope(r)
+	if scope == "" {
+		scope = ScopeReadWrite
+	}
+	return http.StatusOK, WhoAmIResult{true, scope, tokenName(r)}
+}
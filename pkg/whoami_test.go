@@ -0,0 +1,61 @@
+package simpleuploadserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestServer_WhoAmI(t *testing.T) {
+	config := ServerConfig{
+		DocumentRoot:    "/opt/app",
+		MaxUploadSize:   16,
+		EnableAuth:      true,
+		ReadOnlyTokens:  []string{"ro-token"},
+		ReadWriteTokens: []string{"rw-token"},
+		NamedTokens:     map[string]string{"alice": "alice-token"},
+	}
+	server := Server{ServerConfig: config, fs: afero.NewMemMapFs()}
+	router := server.router()
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+		body  string
+	}{
+		{"read-only token", "ro-token", http.StatusOK, `{"ok":true,"scope":"read-only"}`},
+		{"read-write token", "rw-token", http.StatusOK, `{"ok":true,"scope":"read-write"}`},
+		{"named token", "alice-token", http.StatusOK, `{"ok":true,"scope":"read-write","name":"alice"}`},
+		{"invalid token", "invalid", http.StatusUnauthorized, `{"ok":false,"error":"unauthorized"}`},
+		{"no token", "", http.StatusUnauthorized, `{"ok":false,"error":"unauthorized"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want = %d", rr.Code, tt.want)
+			}
+			if body := rr.Body.String(); body != tt.body {
+				t.Errorf("body = %s, want = %s", body, tt.body)
+			}
+		})
+	}
+
+	t.Run("authentication disabled", func(t *testing.T) {
+		server := Server{ServerConfig: ServerConfig{DocumentRoot: "/opt/app"}, fs: afero.NewMemMapFs()}
+		req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+		rr := httptest.NewRecorder()
+		server.router().ServeHTTP(rr, req)
+		if body, want := rr.Body.String(), `{"ok":true,"scope":"read-write"}`; body != want {
+			t.Errorf("body = %s, want = %s", body, want)
+		}
+	})
+}